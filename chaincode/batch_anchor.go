@@ -0,0 +1,287 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package chaincode
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// batchIndexName is the composite key object type for anchored reading batches.
+const batchIndexName = "batch"
+
+// Readings collected by a gateway are kept off-chain and only the Merkle root
+// of the batch is anchored on the ledger. The tree must be built exactly like
+// client/merkle does: a leaf is sha256(0x00 || canonicalReading), an inner node
+// is sha256(0x01 || left || right) and the last node of an odd level is
+// promoted to the next level unchanged.
+const (
+	merkleLeafPrefix = 0x00
+	merkleNodePrefix = 0x01
+)
+
+// BatchAnchor is the on-ledger record of a batch of off-chain readings.
+type BatchAnchor struct {
+	ID           string   `json:"id"`
+	Gateway      string   `json:"gateway"`
+	MerkleRoot   string   `json:"merkle_root"`
+	ReadingCount int      `json:"reading_count"`
+	From         string   `json:"from"`
+	To           string   `json:"to"`
+	StorageRef   string   `json:"storage_ref"`
+	Crops        []string `json:"crops"`
+	AnchoredAt   string   `json:"anchored_at"`
+}
+
+// MerkleProofStep is one sibling hash on the path from a leaf to the root.
+// Left is true when the sibling is the left child of their parent.
+type MerkleProofStep struct {
+	Hash string `json:"hash"`
+	Left bool   `json:"left"`
+}
+
+// ============================================================
+// anchorBatch - anchor the Merkle root of a batch of off-chain readings
+// ============================================================
+func (t *SimpleChaincode) anchorBatch(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0          1           2              3          4       5     6               7
+	// "batchId", "gateway", "merkle root", "count", "from", "to", "storage ref", "crop,crop,..."
+	if len(args) != 8 {
+		return shim.Error("Incorrect number of arguments. Expecting 8")
+	}
+
+	fmt.Println("- start anchor batch")
+
+	for i, arg := range args {
+		if len(arg) == 0 {
+			return shim.Error(fmt.Sprintf("argument %d must be a non-empty string", i+1))
+		}
+	}
+	// only the organisation that registered the gateway anchors its batches
+	gateway, err := requireDeviceOwner(stub, args[1])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if gateway.Status != deviceActive {
+		return shim.Error("gateway is not active: " + gateway.ID)
+	}
+	root, err := hex.DecodeString(args[2])
+	if err != nil || len(root) != sha256.Size {
+		return shim.Error("merkle root must be a hex encoded SHA-256 hash")
+	}
+	count, err := strconv.Atoi(args[3])
+	if err != nil || count < 1 {
+		return shim.Error("reading count must be a positive integer")
+	}
+	from, err := parseReadingTime(args[4])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	to, err := parseReadingTime(args[5])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if to < from {
+		return shim.Error("batch window ends before it starts")
+	}
+	// the gateway reports on a crop only when bound to it, as devices do for signed readings
+	crops := strings.Split(args[7], ",")
+	for _, cropName := range crops {
+		_, err = checkDeviceForCrop(stub, gateway.ID, cropName)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	batchKey, err := stub.CreateCompositeKey(batchIndexName, []string{args[0]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	batchAsBytes, err := stub.GetState(batchKey)
+	if err != nil {
+		return shim.Error("Failed to get batch: " + err.Error())
+	} else if batchAsBytes != nil {
+		return shim.Error("This batch already exists: " + args[0])
+	}
+	anchoredAt, err := txTimestamp(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	batch := BatchAnchor{
+		ID:           args[0],
+		Gateway:      gateway.ID,
+		MerkleRoot:   hex.EncodeToString(root),
+		ReadingCount: count,
+		From:         from,
+		To:           to,
+		StorageRef:   args[6],
+		Crops:        crops,
+		AnchoredAt:   anchoredAt,
+	}
+	batchJSONasBytes, err := json.Marshal(batch)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = stub.PutState(batchKey, batchJSONasBytes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	fmt.Println("- end anchor batch (successful)")
+	return shim.Success(nil)
+}
+
+// ============================================================
+// readBatch - read an anchored batch from chaincode state
+// ============================================================
+func (t *SimpleChaincode) readBatch(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting id of the batch to query")
+	}
+
+	batchKey, err := stub.CreateCompositeKey(batchIndexName, []string{args[0]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	batchAsBytes, err := stub.GetState(batchKey)
+	if err != nil {
+		return shim.Error("Failed to get batch: " + err.Error())
+	} else if batchAsBytes == nil {
+		return shim.Error("batch does not exist: " + args[0])
+	}
+	return shim.Success(batchAsBytes)
+}
+
+// ============================================================
+// verifyBatchReading - prove that a reading was part of an anchored batch
+// ============================================================
+func (t *SimpleChaincode) verifyBatchReading(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0          1                 2
+	// "batchId", "reading json", "proof json"
+	// The proof is a JSON array of MerkleProofStep ordered from the leaf up.
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 3")
+	}
+
+	batchKey, err := stub.CreateCompositeKey(batchIndexName, []string{args[0]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	batchAsBytes, err := stub.GetState(batchKey)
+	if err != nil {
+		return shim.Error("Failed to get batch: " + err.Error())
+	} else if batchAsBytes == nil {
+		return shim.Error("batch does not exist: " + args[0])
+	}
+	var batch BatchAnchor
+	err = json.Unmarshal(batchAsBytes, &batch)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	var reading SensorReading
+	err = json.Unmarshal([]byte(args[1]), &reading)
+	if err != nil {
+		return shim.Error("Failed to decode reading: " + err.Error())
+	}
+	reading.Timestamp, err = parseReadingTime(reading.Timestamp)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	var proof []MerkleProofStep
+	err = json.Unmarshal([]byte(args[2]), &proof)
+	if err != nil {
+		return shim.Error("Failed to decode proof: " + err.Error())
+	}
+
+	leaf := merkleLeafHash(canonicalReading(reading))
+	root, err := merkleRootFromProof(leaf, proof)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	result := struct {
+		Batch    string `json:"batch"`
+		Leaf     string `json:"leaf"`
+		Verified bool   `json:"verified"`
+		Reason   string `json:"reason,omitempty"`
+	}{
+		Batch: batch.ID,
+		Leaf:  hex.EncodeToString(leaf),
+	}
+	switch {
+	case hex.EncodeToString(root) != batch.MerkleRoot:
+		result.Reason = "proof does not lead to the anchored merkle root"
+	case reading.Timestamp < batch.From || reading.Timestamp > batch.To:
+		result.Reason = "reading timestamp is outside the batch window"
+	case !containsString(batch.Crops, reading.Crop):
+		result.Reason = "reading crop is not covered by the batch"
+	default:
+		result.Verified = true
+	}
+
+	resultJSON, err := json.Marshal(result)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(resultJSON)
+}
+
+// merkleLeafHash hashes the canonical encoding of a reading into a leaf.
+func merkleLeafHash(data []byte) []byte {
+	sum := sha256.Sum256(append([]byte{merkleLeafPrefix}, data...))
+	return sum[:]
+}
+
+// merkleNodeHash hashes two child hashes into their parent.
+func merkleNodeHash(left, right []byte) []byte {
+	var buffer bytes.Buffer
+	buffer.WriteByte(merkleNodePrefix)
+	buffer.Write(left)
+	buffer.Write(right)
+	sum := sha256.Sum256(buffer.Bytes())
+	return sum[:]
+}
+
+// merkleRootFromProof folds the proof steps into the leaf and returns the root.
+func merkleRootFromProof(leaf []byte, proof []MerkleProofStep) ([]byte, error) {
+	node := leaf
+	for _, step := range proof {
+		sibling, err := hex.DecodeString(step.Hash)
+		if err != nil || len(sibling) != sha256.Size {
+			return nil, fmt.Errorf("proof hashes must be hex encoded SHA-256 hashes")
+		}
+		if step.Left {
+			node = merkleNodeHash(sibling, node)
+		} else {
+			node = merkleNodeHash(node, sibling)
+		}
+	}
+	return node, nil
+}
@@ -0,0 +1,341 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+const (
+	speciesIndexName         = "species"
+	cultivarIndexName        = "cultivar"
+	speciesCultivarIndexName = "species~cultivar"
+	agronomistAttribute      = "hssf.agronomist"
+)
+
+// RangeType is an inclusive range of values. A range with both bounds zero is
+// not set.
+type RangeType struct {
+	Min float64 `json:"min"`
+	Max float64 `json:"max"`
+}
+
+// IdealRangesType are the ideal growing conditions of a growth stage. Soil
+// moisture is volumetric water content in percent.
+type IdealRangesType struct {
+	Celcius             RangeType `json:"celcius"`
+	RelativeHumidity    RangeType `json:"relative_humidity"`
+	SoilPh              RangeType `json:"soil_ph"`
+	SoilMoisturePercent RangeType `json:"soil_moisture_percent"`
+	SoilNitrogenPercent RangeType `json:"soil_nitrogen_percent"`
+}
+
+// SpeciesEntry is the catalog entry of a crop species. Validations and
+// advisories take their thresholds from the entry of the crop species:
+// ideal ranges and frost thresholds per growth stage, the growing degree day
+// model, crop coefficients and the seasonal nutrient budget.
+type SpeciesEntry struct {
+	ID                   string                        `json:"id"`
+	Name                 string                        `json:"name"`
+	ScientificName       string                        `json:"scientific_name"`
+	IdealRanges          map[string]IdealRangesType    `json:"ideal_ranges"`
+	Frost                map[string]FrostThresholdType `json:"frost"`
+	Phenology            PhenologyModel                `json:"phenology"`
+	Coefficients         CropCoefficients              `json:"coefficients"`
+	NutrientBudget       NutrientBudget                `json:"nutrient_budget"`
+	TypicalYieldTonnesHa float64                       `json:"typical_yield_t_ha"`
+}
+
+// Cultivar is a variety of a catalog species. Values left zero are taken
+// from the species.
+type Cultivar struct {
+	ID                   string  `json:"id"`
+	Species              string  `json:"species"`
+	Name                 string  `json:"name"`
+	TypicalYieldTonnesHa float64 `json:"typical_yield_t_ha"`
+	HarvestGDD           float64 `json:"harvest_gdd"`
+}
+
+// ============================================================
+// putSpecies - add or replace a species in the catalog
+// ============================================================
+func (t *SimpleChaincode) putSpecies(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0
+	// "species entry json"
+	// Only identities carrying the hssf.agronomist=true attribute may edit the catalog.
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	fmt.Println("- start put species")
+
+	err := requireAgronomist(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	var entry SpeciesEntry
+	err = json.Unmarshal([]byte(args[0]), &entry)
+	if err != nil {
+		return shim.Error("species entry must be a JSON document: " + err.Error())
+	}
+	entry, err = validateSpeciesEntry(entry)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = putAsset(stub, speciesIndexName, entry.ID, entry)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	fmt.Println("- end put species (successful)")
+	return shim.Success(nil)
+}
+
+// ============================================================
+// putCultivar - add or replace a cultivar of a catalog species
+// ============================================================
+func (t *SimpleChaincode) putCultivar(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0     1          2       3                   4
+	// "id", "species", "name", "typical yield t/ha", "harvest gdd"
+	// yield and harvest gdd may be empty to use the values of the species
+	if len(args) != 5 {
+		return shim.Error("Incorrect number of arguments. Expecting 5")
+	}
+
+	err := requireAgronomist(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	cultivar := Cultivar{
+		ID:      strings.ToLower(args[0]),
+		Species: strings.ToLower(args[1]),
+		Name:    args[2],
+	}
+	if len(cultivar.ID) == 0 || len(cultivar.Name) == 0 {
+		return shim.Error("cultivar id and name must be non-empty strings")
+	}
+	err = getAsset(stub, speciesIndexName, cultivar.Species, &SpeciesEntry{})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	var existing Cultivar
+	if getAsset(stub, cultivarIndexName, cultivar.ID, &existing) == nil && existing.Species != cultivar.Species {
+		return shim.Error("cultivar " + cultivar.ID + " belongs to species " + existing.Species)
+	}
+	for i, value := range []*float64{&cultivar.TypicalYieldTonnesHa, &cultivar.HarvestGDD} {
+		if args[3+i] == "" {
+			continue
+		}
+		*value, err = strconv.ParseFloat(args[3+i], 64)
+		if err != nil || *value < 0 {
+			return shim.Error(fmt.Sprintf("argument %d must be a non-negative number", 4+i))
+		}
+	}
+
+	err = putAsset(stub, cultivarIndexName, cultivar.ID, cultivar)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	indexKey, err := stub.CreateCompositeKey(speciesCultivarIndexName, []string{cultivar.Species, cultivar.ID})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = stub.PutState(indexKey, []byte{0x00})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(nil)
+}
+
+// ============================================================
+// readSpecies - read a catalog species and its cultivars
+// ============================================================
+func (t *SimpleChaincode) readSpecies(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting id of the species to query")
+	}
+
+	var species struct {
+		SpeciesEntry
+		Cultivars []Cultivar `json:"cultivars"`
+	}
+	err := getAsset(stub, speciesIndexName, strings.ToLower(args[0]), &species.SpeciesEntry)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(speciesCultivarIndexName, []string{species.ID})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	species.Cultivars = []Cultivar{}
+	for resultsIterator.HasNext() {
+		responseRange, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		_, keyParts, err := stub.SplitCompositeKey(responseRange.Key)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		var cultivar Cultivar
+		err = getAsset(stub, cultivarIndexName, keyParts[1], &cultivar)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		species.Cultivars = append(species.Cultivars, cultivar)
+	}
+
+	speciesJSON, err := json.Marshal(species)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(speciesJSON)
+}
+
+// validateSpeciesEntry checks a species entry and normalizes its keys.
+func validateSpeciesEntry(entry SpeciesEntry) (SpeciesEntry, error) {
+	entry.ID = strings.ToLower(entry.ID)
+	if len(entry.ID) == 0 || len(entry.Name) == 0 {
+		return entry, fmt.Errorf("species id and name must be non-empty strings")
+	}
+	if entry.TypicalYieldTonnesHa < 0 {
+		return entry, fmt.Errorf("typical yield must be non-negative")
+	}
+
+	idealRanges := map[string]IdealRangesType{}
+	for name, ranges := range entry.IdealRanges {
+		stage, err := parseGrowthStage(name)
+		if err != nil {
+			return entry, err
+		}
+		for _, r := range []RangeType{ranges.Celcius, ranges.RelativeHumidity, ranges.SoilPh, ranges.SoilMoisturePercent, ranges.SoilNitrogenPercent} {
+			if r.Min > r.Max {
+				return entry, fmt.Errorf("ideal range minimum above maximum in stage %s", stage)
+			}
+		}
+		idealRanges[stage] = ranges
+	}
+	entry.IdealRanges = idealRanges
+
+	frost := map[string]FrostThresholdType{}
+	for name, threshold := range entry.Frost {
+		stage, err := parseGrowthStage(name)
+		if err != nil {
+			return entry, err
+		}
+		if threshold.CriticalCelcius > threshold.WarningCelcius {
+			return entry, fmt.Errorf("critical frost temperature must not be above the warning temperature: %s", stage)
+		}
+		frost[stage] = threshold
+	}
+	entry.Frost = frost
+
+	phenology := entry.Phenology
+	if len(phenology.Stages) > 0 || phenology.HarvestGDD > 0 {
+		if phenology.CapCelcius <= phenology.BaseCelcius {
+			return entry, fmt.Errorf("cap temperature must be above the base temperature")
+		}
+		for code, gdd := range phenology.Stages {
+			if err := validateBBCHCode(code); err != nil {
+				return entry, err
+			}
+			if gdd < 0 {
+				return entry, fmt.Errorf("growing degree days must be non-negative: %s", code)
+			}
+		}
+		if phenology.HarvestGDD <= 0 {
+			return entry, fmt.Errorf("harvest growing degree days must be a positive number")
+		}
+	}
+
+	coefficients := entry.Coefficients
+	for _, value := range []float64{coefficients.KcInitial, coefficients.KcMid, coefficients.KcEnd, coefficients.RootDepthM, coefficients.FieldCapacityPercent} {
+		if value < 0 {
+			return entry, fmt.Errorf("crop coefficients must be non-negative")
+		}
+	}
+	if coefficients.FieldCapacityPercent > 100 {
+		return entry, fmt.Errorf("field capacity must be a percentage")
+	}
+
+	budget := entry.NutrientBudget
+	for _, value := range []float64{budget.NitrogenKgHa, budget.PhosphorusKgHa, budget.PotassiumKgHa, budget.SoilNitrogenMaxPercent} {
+		if value < 0 {
+			return entry, fmt.Errorf("nutrient budget values must be non-negative")
+		}
+	}
+	return entry, nil
+}
+
+// getCatalogEntry returns the catalog entry of a crop with the values of its
+// cultivar applied. It fails when the species of the crop is not cataloged.
+func getCatalogEntry(stub shim.ChaincodeStubInterface, info CropInfo) (SpeciesEntry, error) {
+	var entry SpeciesEntry
+	err := getAsset(stub, speciesIndexName, info.Species, &entry)
+	if err != nil {
+		return entry, err
+	}
+	if info.Cultivar == "" {
+		return entry, nil
+	}
+	var cultivar Cultivar
+	err = getAsset(stub, cultivarIndexName, info.Cultivar, &cultivar)
+	if err != nil {
+		return entry, err
+	}
+	if cultivar.TypicalYieldTonnesHa > 0 {
+		entry.TypicalYieldTonnesHa = cultivar.TypicalYieldTonnesHa
+	}
+	if cultivar.HarvestGDD > 0 {
+		entry.Phenology.HarvestGDD = cultivar.HarvestGDD
+	}
+	return entry, nil
+}
+
+// lookupCatalogEntry is getCatalogEntry for optional checks: crops created
+// before the catalog may have an uncataloged species, found is false then.
+func lookupCatalogEntry(stub shim.ChaincodeStubInterface, info CropInfo) (SpeciesEntry, bool, error) {
+	speciesKey, err := stub.CreateCompositeKey(speciesIndexName, []string{info.Species})
+	if err != nil {
+		return SpeciesEntry{}, false, err
+	}
+	speciesAsBytes, err := stub.GetState(speciesKey)
+	if err != nil {
+		return SpeciesEntry{}, false, fmt.Errorf("Failed to get species: %s", err.Error())
+	} else if speciesAsBytes == nil {
+		return SpeciesEntry{}, false, nil
+	}
+	entry, err := getCatalogEntry(stub, info)
+	return entry, err == nil, err
+}
+
+// requireAgronomist checks that the invoker may edit the crop catalog.
+func requireAgronomist(stub shim.ChaincodeStubInterface) error {
+	return requireAttribute(stub, agronomistAttribute, "only agronomists may edit the crop catalog")
+}
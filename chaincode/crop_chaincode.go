@@ -15,7 +15,10 @@ specific language governing permissions and limitations
 under the License.
 */
 
-package main
+// Package chaincode is the precision farming chaincode. SimpleChaincode is
+// started by the cmd/cropcc command, and can be driven in-process through
+// shim.NewMockStub.
+package chaincode
 
 import (
 	"bytes"
@@ -25,6 +28,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/hyperledger/fabric/core/chaincode/lib/cid"
 	"github.com/hyperledger/fabric/core/chaincode/shim"
 	pb "github.com/hyperledger/fabric/protos/peer"
 )
@@ -65,6 +69,13 @@ type PhosphorusType struct {
 	Percentage float64 `json:"percentage"`
 }
 
+// WeatherType is the weather of a crop or a reading. The field names are
+// kept for existing clients, the units are those the FAO-56 models read:
+// Temperature.Celcius is the air temperature in degrees Celsius,
+// Pressure.Pascal the atmospheric pressure in Pa, Humidity.CubicMeter the
+// absolute humidity in g of water vapour per m3 of air and Radiation.Rem the
+// solar radiation as a daily rate in MJ/m2/day, so the mean over the readings
+// of a day is the daily total.
 type WeatherType struct {
 	Temperature TemperatureType `json:"temperature"`
 	Pressure    PressureType    `json:"pressure"`
@@ -77,31 +88,69 @@ type SoilConditionType struct {
 	Ph         int            `json:"ph"`
 	Nitrogen   NitrogenType   `json:"nitrogen"`
 	Phosphorus PhosphorusType `json:"phosphorus"`
+	Potassium  PotassiumType  `json:"potassium"`
 }
 
-type Crop struct {
-	Name           string            `json:"name"`
-	Owner          string            `json:"owner"`
-	Quantity       int               `json:"quantity"`
-	FarmInfo       FarmInfoType      `json:"farm_info"`
-	Weather        WeatherType       `json:"weather"`
-	SoilCondition  SoilConditionType `json:"soil_condition"`
-	Image          string            `json:"image"`
-	Cghc           int               `json:"cghc"`
-	Irrigation     bool              `json:"irrigation"`
-	AddFertilizer  bool              `json:"fertilizer_addition"`
-	ApplyPesticide bool              `json:"apply_pesticide"`
-	Harvesting     bool              `json:"harvesting"`
+// CropInfo is the base record of a crop, stored under the crop name.
+// OwnerMSP is the MSP of the identity that created the crop, empty for crops
+// created before it was kept.
+type CropInfo struct {
+	Name      string       `json:"name"`
+	Owner     string       `json:"owner"`
+	OwnerMSP  string       `json:"owner_msp,omitempty"`
+	Quantity  int          `json:"quantity"`
+	FarmInfo  FarmInfoType `json:"farm_info"`
+	Field     string       `json:"field,omitempty"`
+	Species   string       `json:"species,omitempty"`
+	Cultivar  string       `json:"cultivar,omitempty"`
+	PlantedAt string       `json:"planted_at,omitempty"`
 }
 
-// ===================================================================================
-// Main
-// ===================================================================================
-func main() {
-	err := shim.Start(new(SimpleChaincode))
-	if err != nil {
-		fmt.Printf("Error starting Simple chaincode: %s", err)
-	}
+// CropConditions is the part of a crop written by sensor and condition updates.
+// Cghc is the crop growth and health condition grade recorded by updateCrop
+// together with the image of the crop. It is a free grade kept for existing
+// clients; the pathology of the crop is derived from disease observations.
+// The growth stage is kept here rather than in the status, the health score
+// depends on it and conditions writers do not read the status.
+type CropConditions struct {
+	Weather       WeatherType                `json:"weather"`
+	SoilCondition SoilConditionType          `json:"soil_condition"`
+	Image         string                     `json:"image"`
+	Cghc          int                        `json:"cghc"`
+	LatestReading LatestReadingType          `json:"latest_reading"`
+	SoilTest      SoilTestSummaryType        `json:"soil_test"`
+	FrostRisk     string                     `json:"frost_risk,omitempty"`
+	Pathology     PathologyType              `json:"pathology"`
+	DiseaseRisks  map[string]DiseaseRiskType `json:"disease_risks,omitempty"`
+	Pests         map[string]PestLevelType   `json:"pests,omitempty"`
+	Health        HealthScoreType            `json:"health"`
+	GrowthStage   string                     `json:"growth_stage,omitempty"`
+}
+
+// CropActivities is the part of a crop written by farming activities.
+type CropActivities struct {
+	Irrigation     bool `json:"irrigation"`
+	AddFertilizer  bool `json:"fertilizer_addition"`
+	ApplyPesticide bool `json:"apply_pesticide"`
+}
+
+// CropStatus is the part of a crop written when its lifecycle status changes.
+// HarvestedAt is the time of the harvest, recordYieldSeason ends the season there.
+// HarvestedKg is the harvested quantity in kilograms the yield is computed
+// from, nil for crops harvested before it was kept.
+type CropStatus struct {
+	Harvesting  bool     `json:"harvesting"`
+	HarvestedAt string   `json:"harvested_at,omitempty"`
+	HarvestedKg *float64 `json:"harvested_kg,omitempty"`
+}
+
+// Crop is the composed view of a crop. The embedded parts are stored as
+// separate records, see crop_state.go, but marshal to one flat document.
+type Crop struct {
+	CropInfo
+	CropConditions
+	CropActivities
+	CropStatus
 }
 
 // Init initializes chaincode
@@ -121,7 +170,7 @@ func (t *SimpleChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
 		return t.initCrop(stub, args)
 	} else if function == "queryCrop" { //find Crop based on an ad hoc rich query
 		return t.queryCrop(stub, args)
-	}else if function == "updateCrop" { //update Crop based on an ad hoc rich query
+	} else if function == "updateCrop" { //update Crop based on an ad hoc rich query
 		return t.updateCrop(stub, args)
 	} else if function == "historyOfCrop" { //find Crop based on an ad hoc rich query
 		return t.getHistoryForCrop(stub, args)
@@ -137,6 +186,160 @@ func (t *SimpleChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
 		return t.applyPesticide(stub, args)
 	} else if function == "harvestCrop" { //find Crop based on an ad hoc rich query
 		return t.harvest(stub, args)
+	} else if function == "queryReadings" { //find readings of a Crop inside a time window
+		return t.queryReadings(stub, args)
+	} else if function == "registerDevice" { //register a new IoT device
+		return t.registerDevice(stub, args)
+	} else if function == "bindDevice" { //bind a device to a Crop or field
+		return t.bindDevice(stub, args)
+	} else if function == "decommissionDevice" { //retire a device
+		return t.decommissionDevice(stub, args)
+	} else if function == "readDevice" { //read a registered device
+		return t.readDevice(stub, args)
+	} else if function == "devicesOfCrop" { //find the devices bound to a Crop
+		return t.devicesOfCrop(stub, args)
+	} else if function == "submitSignedReading" { //store a reading signed by its device
+		return t.submitSignedReading(stub, args)
+	} else if function == "anchorBatch" { //anchor the merkle root of off-chain readings
+		return t.anchorBatch(stub, args)
+	} else if function == "readBatch" { //read an anchored batch
+		return t.readBatch(stub, args)
+	} else if function == "verifyBatchReading" { //prove a reading is part of an anchored batch
+		return t.verifyBatchReading(stub, args)
+	} else if function == "initFarm" { //create a new farm
+		return t.initFarm(stub, args)
+	} else if function == "initField" { //create a new field on a farm
+		return t.initField(stub, args)
+	} else if function == "readFarm" { //read a farm
+		return t.readFarm(stub, args)
+	} else if function == "readField" { //read a field
+		return t.readField(stub, args)
+	} else if function == "fieldsOfFarm" { //find the fields of a farm
+		return t.fieldsOfFarm(stub, args)
+	} else if function == "assignCropField" { //place an existing Crop on a field
+		return t.assignCropField(stub, args)
+	} else if function == "fieldHistory" { //find the crops, soil tests and treatments of a field
+		return t.fieldHistory(stub, args)
+	} else if function == "setFieldBoundary" { //set the GeoJSON boundary of a field
+		return t.setFieldBoundary(stub, args)
+	} else if function == "fieldContainsPoint" { //check whether a point lies inside a field
+		return t.fieldContainsPoint(stub, args)
+	} else if function == "cropsInBoundingBox" { //find Crops inside a bounding box
+		return t.cropsInBoundingBox(stub, args)
+	} else if function == "cropsNearPoint" { //find Crops within a radius of a point
+		return t.cropsNearPoint(stub, args)
+	} else if function == "indexCropLocation" { //rebuild the geohash index entry of a Crop
+		return t.indexCropLocation(stub, args)
+	} else if function == "submitSoilTest" { //store the lab result of a soil sample
+		return t.submitSoilTest(stub, args)
+	} else if function == "invalidateSoilTest" { //withdraw a soil test
+		return t.invalidateSoilTest(stub, args)
+	} else if function == "readSoilTest" { //read a soil test
+		return t.readSoilTest(stub, args)
+	} else if function == "soilTestsOf" { //find the soil tests of a Crop or field
+		return t.soilTestsOf(stub, args)
+	} else if function == "accreditLab" { //register or renew an accredited lab
+		return t.accreditLab(stub, args)
+	} else if function == "revokeLab" { //withdraw the accreditation of a lab
+		return t.revokeLab(stub, args)
+	} else if function == "readLab" { //read a lab
+		return t.readLab(stub, args)
+	} else if function == "submitResidueTest" { //store the lab result of a residue analysis
+		return t.submitResidueTest(stub, args)
+	} else if function == "invalidateResidueTest" { //withdraw a residue test
+		return t.invalidateResidueTest(stub, args)
+	} else if function == "readResidueTest" { //read a residue test
+		return t.readResidueTest(stub, args)
+	} else if function == "residueTestsOf" { //find the residue tests of a Crop
+		return t.residueTestsOf(stub, args)
+	} else if function == "verifyLabResult" { //verify the issuer and integrity of a lab result
+		return t.verifyLabResult(stub, args)
+	} else if function == "registerPesticide" { //register an approved pesticide product
+		return t.registerPesticide(stub, args)
+	} else if function == "withdrawPesticide" { //withdraw the approval of a pesticide product
+		return t.withdrawPesticide(stub, args)
+	} else if function == "readPesticide" { //read a pesticide product
+		return t.readPesticide(stub, args)
+	} else if function == "pesticideApplicationsOf" { //find the pesticide applications of a Crop
+		return t.pesticideApplicationsOf(stub, args)
+	} else if function == "setMRLTable" { //store the maximum residue limits of a market
+		return t.setMRLTable(stub, args)
+	} else if function == "readMRLTable" { //read the maximum residue limits of a market
+		return t.readMRLTable(stub, args)
+	} else if function == "evaluateMRLCompliance" { //check a Crop against the residue limits of markets
+		return t.evaluateMRLCompliance(stub, args)
+	} else if function == "nutrientBalanceOf" { //read the nutrients applied on a field or Crop in a season
+		return t.nutrientBalanceOf(stub, args)
+	} else if function == "fertilizerApplicationsOf" { //find the fertilizer applications of a Crop
+		return t.fertilizerApplicationsOf(stub, args)
+	} else if function == "setGrowthStage" { //move a Crop to another growth stage
+		return t.setGrowthStage(stub, args)
+	} else if function == "adviseIrrigation" { //compute the irrigation advice of a Crop for a day
+		return t.adviseIrrigation(stub, args)
+	} else if function == "recordIrrigation" { //record irrigation applied to a Crop
+		return t.recordIrrigation(stub, args)
+	} else if function == "irrigationAdviceOf" { //find the irrigation advice of a Crop
+		return t.irrigationAdviceOf(stub, args)
+	} else if function == "irrigationEventsOf" { //find the irrigation applied to a Crop inside a time window
+		return t.irrigationEventsOf(stub, args)
+	} else if function == "frostAlertsOf" { //find the frost alerts of a Crop
+		return t.frostAlertsOf(stub, args)
+	} else if function == "recordDiseaseObservation" { //record a disease observation on a Crop
+		return t.recordDiseaseObservation(stub, args)
+	} else if function == "readDiseaseObservation" { //read a disease observation
+		return t.readDiseaseObservation(stub, args)
+	} else if function == "diseaseHistory" { //find the disease observations of a Crop or field
+		return t.diseaseHistory(stub, args)
+	} else if function == "diseaseInRegion" { //find the disease observations inside a bounding box
+		return t.diseaseInRegion(stub, args)
+	} else if function == "evaluateDiseaseRisk" { //evaluate disease risk models over the readings of a Crop
+		return t.evaluateDiseaseRisk(stub, args)
+	} else if function == "diseaseRiskModels" { //list the available disease risk models
+		return t.diseaseRiskModelsList(stub, args)
+	} else if function == "setPlantingDate" { //set the planting date of a Crop
+		return t.setPlantingDate(stub, args)
+	} else if function == "phenologyOf" { //growing degree days, BBCH stage and harvest date of a Crop
+		return t.phenologyOf(stub, args)
+	} else if function == "putSpecies" { //add or replace a species in the catalog
+		return t.putSpecies(stub, args)
+	} else if function == "putCultivar" { //add or replace a cultivar of a species
+		return t.putCultivar(stub, args)
+	} else if function == "readSpecies" { //read a species and its cultivars
+		return t.readSpecies(stub, args)
+	} else if function == "recordPestObservation" { //record a pest scouting observation on a Crop
+		return t.recordPestObservation(stub, args)
+	} else if function == "pestObservationsOf" { //find the pest observations of a Crop inside a time window
+		return t.pestObservationsOf(stub, args)
+	} else if function == "healthScoreOf" { //read the health score of a Crop and its breakdown
+		return t.healthScoreOf(stub, args)
+	} else if function == "refreshHealthScore" { //compute the health score of a Crop again
+		return t.refreshHealthScore(stub, args)
+	} else if function == "cropsByHealthScore" { //find Crops ordered by health score
+		return t.cropsByHealthScore(stub, args)
+	} else if function == "forecastYield" { //forecast the yield of a growing Crop from past seasons
+		return t.forecastYield(stub, args)
+	} else if function == "yieldForecastsOf" { //find the yield forecasts of a Crop
+		return t.yieldForecastsOf(stub, args)
+	} else if function == "yieldSeasonsOf" { //find the harvested seasons of a species
+		return t.yieldSeasonsOf(stub, args)
+	} else if function == "recordYieldSeason" { //keep the season of a harvested Crop
+		return t.recordYieldSeason(stub, args)
+	} else if function == "registerModel" { //register a version of a machine learning model
+		return t.registerModel(stub, args)
+	} else if function == "retireModel" { //stop a model version from recommending
+		return t.retireModel(stub, args)
+	} else if function == "readModel" { //read a model version or every version of a model
+		return t.readModel(stub, args)
+	} else if function == "recordRecommendation" { //record an action a model recommends for a Crop
+		return t.recordRecommendation(stub, args)
+	} else if function == "decideRecommendation" { //record whether the farmer accepted a recommendation
+		return t.decideRecommendation(stub, args)
+	} else if function == "recommendationsOf" { //find the recommendations for a Crop or of a model
+		return t.recommendationsOf(stub, args)
+	} else if function == "modelPerformance" { //audit the recommendations of a model version
+		return t.modelPerformance(stub, args)
+	} else if function == "cropsOfOwner" { //find the Crops of an owner
+		return t.cropsOfOwner(stub, args)
 	}
 
 	fmt.Println("invoke did not find func: " + function) //error
@@ -149,8 +352,12 @@ func (t *SimpleChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
 func (t *SimpleChaincode) initCrop(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 	var err error
 
-	if len(args) != 20 {
-		return shim.Error("Incorrect number of arguments. Expecting 20")
+	// an optional 21st argument places the crop on a registered field, the
+	// farm info arguments are then ignored in favour of the field. An optional
+	// 22nd argument names the catalog species, it defaults to the crop name,
+	// and an optional 23rd argument a cultivar of that species.
+	if len(args) < 20 || len(args) > 23 {
+		return shim.Error("Incorrect number of arguments. Expecting 20 to 23")
 	}
 
 	// ==== Input sanitation ====
@@ -179,50 +386,95 @@ func (t *SimpleChaincode) initCrop(stub shim.ChaincodeStubInterface, args []stri
 	if err != nil {
 		return shim.Error(err.Error())
 	}
+	ownerMSP, err := cid.GetMSPID(stub)
+	if err != nil {
+		return shim.Error("Failed to get invoker MSP: " + err.Error())
+	}
 	crop := Crop{
-		Name:     cropnamev,
-		Owner:    ownerv,
-		Quantity: quantityv,
-		FarmInfo: FarmInfoType{
-			GeoLocation: GeoLocationType{
-				Latitude:  lativ,
-				Longitude: longiv,
+		CropInfo: CropInfo{
+			Name:     cropnamev,
+			Owner:    ownerv,
+			OwnerMSP: ownerMSP,
+			Quantity: quantityv,
+			FarmInfo: FarmInfoType{
+				GeoLocation: GeoLocationType{
+					Latitude:  lativ,
+					Longitude: longiv,
+				},
+				SoilType: strings.ToLower(args[5]),
 			},
-			SoilType: strings.ToLower(args[5]),
 		},
-		Weather: WeatherType{
-			Temperature: TemperatureType{
-				Celcius: celv,
-			},
-			Pressure: PressureType{
-				Pascal: pasv,
-			},
-			Humidity: HumidityType{
-				CubicMeter: humv,
+		CropConditions: CropConditions{
+			Weather: WeatherType{
+				Temperature: TemperatureType{
+					Celcius: celv,
+				},
+				Pressure: PressureType{
+					Pascal: pasv,
+				},
+				Humidity: HumidityType{
+					CubicMeter: humv,
+				},
+				Radiation: RadiationType{
+					Rem: radv,
+				},
 			},
-			Radiation: RadiationType{
-				Rem: radv,
+			//soil condition
+			SoilCondition: SoilConditionType{
+				Moisture: MoistureType{
+					CubicMeter: moistv,
+				},
+				Ph: phv,
+				Nitrogen: NitrogenType{
+					Percentage: nitrov,
+				},
+				Phosphorus: PhosphorusType{
+					Percentage: phosv,
+				},
 			},
+			Image: imagev,
+			Cghc:  cgphv,
 		},
-		//soil condition
-		SoilCondition: SoilConditionType{
-			Moisture: MoistureType{
-				CubicMeter: moistv,
-			},
-			Ph: phv,
-			Nitrogen: NitrogenType{
-				Percentage: nitrov,
-			},
-			Phosphorus: PhosphorusType{
-				Percentage: phosv,
-			},
+		CropActivities: CropActivities{
+			Irrigation:     irrv,
+			AddFertilizer:  ferv,
+			ApplyPesticide: appv,
+		},
+		CropStatus: CropStatus{
+			Harvesting: harv,
 		},
-		Image:          imagev,
-		Cghc:           cgphv,
-		Irrigation:     irrv,
-		AddFertilizer:  ferv,
-		ApplyPesticide: appv,
-		Harvesting:     harv,
+	}
+
+	if len(args) > 20 && args[20] != "" {
+		field, err := getField(stub, args[20])
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		err = requireOwnerMSP(stub, field.OwnerMSP, "field "+field.ID)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		crop.Field = field.ID
+		crop.FarmInfo = FarmInfoType{}
+	}
+	crop.Species = strings.ToLower(cropnamev)
+	if len(args) > 21 && args[21] != "" {
+		crop.Species = strings.ToLower(args[21])
+	}
+	err = getAsset(stub, speciesIndexName, crop.Species, &SpeciesEntry{})
+	if err != nil {
+		return shim.Error("crops must reference a catalog species: " + err.Error())
+	}
+	if len(args) == 23 && args[22] != "" {
+		var cultivar Cultivar
+		err = getAsset(stub, cultivarIndexName, strings.ToLower(args[22]), &cultivar)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if cultivar.Species != crop.Species {
+			return shim.Error("cultivar " + cultivar.ID + " is not a cultivar of " + crop.Species)
+		}
+		crop.Cultivar = cultivar.ID
 	}
 
 	// ==== Check if crop already exists ====
@@ -234,16 +486,8 @@ func (t *SimpleChaincode) initCrop(stub shim.ChaincodeStubInterface, args []stri
 		return shim.Error("This marble already exists: " + cropnamev)
 	}
 
-	// ==== Create crop object and crop to JSON ====
-	cropJsonBytes, err := json.Marshal(crop)
-	if err != nil {
-		return shim.Error(err.Error())
-	}
-
-	
-
-	// === Save crop to state ===
-	err = stub.PutState(cropnamev, cropJsonBytes)
+	// === Save crop and its sub-records to state ===
+	err = putCrop(stub, crop)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
@@ -264,6 +508,29 @@ func (t *SimpleChaincode) initCrop(stub shim.ChaincodeStubInterface, args []stri
 	value := []byte{0x00}
 	stub.PutState(ownerNameIndexKey, value)
 
+	// ==== Index the crop location for geospatial queries ====
+	location, err := cropLocation(stub, crop.CropInfo)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = putGeohashIndex(stub, crop.Name, location)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// ==== Keep the crop in the history of its field ====
+	if crop.Field != "" {
+		err = addFieldEvent(stub, crop.Field, fieldEventCrop, crop.Name, "")
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		// the crop has no soil tests of its own yet, it takes those of its field
+		err = refreshCropSoilCondition(stub, crop.CropInfo)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
 	// ==== Crop saved and indexed. Return success ====
 	fmt.Println("- end init crop successful")
 	return shim.Success(nil)
@@ -274,10 +541,13 @@ func (t *SimpleChaincode) initCrop(stub shim.ChaincodeStubInterface, args []stri
 // ============================================================
 func (t *SimpleChaincode) updateCrop(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 	var err error
-	var cropJSON Crop
 
-	if len(args) != 16 {
-		return shim.Error("Incorrect number of arguments. Expecting 20")
+	//   0       1        2
+	// "name", "image", "cghc"
+	// An empty image or cghc leaves that value unchanged. Weather and soil
+	// values are readings, only bound devices submit them with submitSignedReading.
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 3")
 	}
 
 	// ==== Input sanitation ====
@@ -286,95 +556,24 @@ func (t *SimpleChaincode) updateCrop(stub shim.ChaincodeStubInterface, args []st
 	cropnamev := args[0]
 
 	// ==== Check if crop already exists ====
-	gotCropAsBytes, err := stub.GetState(cropnamev)
-	if err != nil {
-		return shim.Error("Failed to get marble: " + err.Error())
-	}
-	err = json.Unmarshal([]byte(gotCropAsBytes), &cropJSON)
-	if err != nil {
-		return shim.Error("Failed to unmarshal crop to json format " + err.Error())
-	}
-
-	ownerv := cropJSON.Owner
-	quantityv := cropJSON.Quantity
-	lativ := cropJSON.FarmInfo.GeoLocation.Latitude
-	longiv := cropJSON.FarmInfo.GeoLocation.Longitude
-	soilv := cropJSON.FarmInfo.SoilType
-
-	celv, err := strconv.ParseFloat(args[6], 64)
-	pasv, err := strconv.ParseFloat(args[7], 64)
-	humv, err := strconv.ParseFloat(args[8], 64)
-	radv, err := strconv.ParseFloat(args[9], 64)
-	moistv, err := strconv.ParseFloat(args[10], 64)
-	phv, err := strconv.Atoi(args[11])
-	nitrov, err := strconv.ParseFloat(args[12], 64)
-	phosv, err := strconv.ParseFloat(args[13], 64)
-	imagev := args[14]
-	cgphv, err := strconv.Atoi(args[15])
-	irrv := cropJSON.Irrigation
-	ferv := cropJSON.AddFertilizer
-	appv := cropJSON.ApplyPesticide
-	harv := cropJSON.Harvesting
-
+	var conditions CropConditions
+	err = getCropAspect(stub, cropnamev, conditionsPart, &conditions)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
-	crop := Crop{
-		Name:     cropnamev,
-		Owner:    ownerv,
-		Quantity: quantityv,
-		FarmInfo: FarmInfoType{
-			GeoLocation: GeoLocationType{
-				Latitude:  lativ,
-				Longitude: longiv,
-			},
-			SoilType: strings.ToLower(soilv),
-		},
-		Weather: WeatherType{
-			Temperature: TemperatureType{
-				Celcius: celv,
-			},
-			Pressure: PressureType{
-				Pascal: pasv,
-			},
-			Humidity: HumidityType{
-				CubicMeter: humv,
-			},
-			Radiation: RadiationType{
-				Rem: radv,
-			},
-		},
-		//soil condition
-		SoilCondition: SoilConditionType{
-			Moisture: MoistureType{
-				CubicMeter: moistv,
-			},
-			Ph: phv,
-			Nitrogen: NitrogenType{
-				Percentage: nitrov,
-			},
-			Phosphorus: PhosphorusType{
-				Percentage: phosv,
-			},
-		},
-		Image:          imagev,
-		Cghc:           cgphv,
-		Irrigation:     irrv,
-		AddFertilizer:  ferv,
-		ApplyPesticide: appv,
-		Harvesting:     harv,
-	}
 
-	// ==== Create crop object and crop to JSON ====
-	cropJsonBytes, err := json.Marshal(crop)
-	if err != nil {
-		return shim.Error(err.Error())
+	if args[1] != "" {
+		conditions.Image = args[1]
+	}
+	if args[2] != "" {
+		conditions.Cghc, err = strconv.Atoi(args[2])
+		if err != nil {
+			return shim.Error("cghc must be an integer grade: " + args[2])
+		}
 	}
 
-	
-
-	// === Save marble to state ===
-	err = stub.PutState(cropnamev, cropJsonBytes)
+	// === Save the crop conditions to state ===
+	err = putCropConditions(stub, cropnamev, &conditions)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
@@ -390,6 +589,8 @@ func (t *SimpleChaincode) queryCrop(stub shim.ChaincodeStubInterface, args []str
 
 	//   0
 	// "queryString"
+	// The query runs against the stored documents, so it matches the crop base
+	// records and the crop sub-records separately, see crop_state.go.
 	if len(args) < 1 {
 		return shim.Error("Incorrect number of arguments. Expecting 1")
 	}
@@ -459,7 +660,17 @@ func (t *SimpleChaincode) getHistoryForCrop(stub shim.ChaincodeStubInterface, ar
 
 	fmt.Printf("- start getHistoryForCrop: %s\n", cropName)
 
-	resultsIterator, err := stub.GetHistoryForKey(cropName)
+	// an optional second argument selects the history of one crop sub-record
+	historyKey := cropName
+	if len(args) > 1 && args[1] != "" {
+		partKey, err := stub.CreateCompositeKey(cropPartIndexName, []string{cropName, args[1]})
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		historyKey = partKey
+	}
+
+	resultsIterator, err := stub.GetHistoryForKey(historyKey)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
@@ -526,18 +737,57 @@ func (t *SimpleChaincode) readCrop(stub shim.ChaincodeStubInterface, args []stri
 	}
 
 	name = args[0]
-	valAsbytes, err := stub.GetState(name) //get the crop from chaincode state
+	crop, err := getCrop(stub, name) //compose the crop from its records in chaincode state
 	if err != nil {
-		jsonResp = "{\"Error\":\"Failed to get state for " + name + "\"}"
-		return shim.Error(jsonResp)
-	} else if valAsbytes == nil {
-		jsonResp = "{\"Error\":\"Marble does not exist: " + name + "\"}"
+		jsonResp = "{\"Error\":\"" + err.Error() + "\"}"
 		return shim.Error(jsonResp)
 	}
 
+	valAsbytes, err := json.Marshal(crop)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
 	return shim.Success(valAsbytes)
 }
 
+// ===============================================
+// cropsOfOwner - read the Crops of an owner through the owner~name index
+// ===============================================
+func (t *SimpleChaincode) cropsOfOwner(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting owner of the Crops to query")
+	}
+
+	resultsIterator, err := stub.GetStateByPartialCompositeKey("owner~name", []string{args[0]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	crops := []Crop{}
+	for resultsIterator.HasNext() {
+		responseRange, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		_, keyParts, err := stub.SplitCompositeKey(responseRange.Key)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		crop, err := getCrop(stub, keyParts[1])
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		crops = append(crops, crop)
+	}
+
+	cropsJSON, err := json.Marshal(crops)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(cropsJSON)
+}
+
 // ==================================================
 // delete - remove a crop key/value pair from state
 // ==================================================
@@ -565,11 +815,33 @@ func (t *SimpleChaincode) delete(stub shim.ChaincodeStubInterface, args []string
 		return shim.Error(jsonResp)
 	}
 
+	location, err := cropLocation(stub, cropJSON.CropInfo)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	var conditions CropConditions
+	err = getCropAspect(stub, cropName, conditionsPart, &conditions)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
 	err = stub.DelState(cropName) //remove the crop from chaincode state
 	if err != nil {
 		return shim.Error("Failed to delete state:" + err.Error())
 	}
 
+	// remove the crop sub-records
+	for _, part := range cropParts {
+		partKey, err := stub.CreateCompositeKey(cropPartIndexName, []string{cropName, part})
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		err = stub.DelState(partKey)
+		if err != nil {
+			return shim.Error("Failed to delete state:" + err.Error())
+		}
+	}
+
 	// maintain the index
 	indexName := "owner~name"
 	ownerNameIndexKey, err := stub.CreateCompositeKey(indexName, []string{cropJSON.Owner, cropJSON.Name})
@@ -582,6 +854,14 @@ func (t *SimpleChaincode) delete(stub shim.ChaincodeStubInterface, args []string
 	if err != nil {
 		return shim.Error("Failed to delete state:" + err.Error())
 	}
+	err = delGeohashIndex(stub, cropName, location)
+	if err != nil {
+		return shim.Error("Failed to delete state:" + err.Error())
+	}
+	err = delHealthScoreIndex(stub, cropName, conditions.Health)
+	if err != nil {
+		return shim.Error("Failed to delete state:" + err.Error())
+	}
 	return shim.Success(nil)
 }
 
@@ -601,25 +881,23 @@ func (t *SimpleChaincode) irrigation(stub shim.ChaincodeStubInterface, args []st
 	}
 	fmt.Println("- start irrigation value update", cropName, newIrrigationValue)
 
-	cropAsBytes, err := stub.GetState(cropName)
-	if err != nil {
-		return shim.Error("Failed to get crop:" + err.Error())
-	} else if cropAsBytes == nil {
-		return shim.Error("crop does not exist")
-	}
-
-	cropIrrigation := Crop{}
-	err = json.Unmarshal(cropAsBytes, &cropIrrigation) //unmarshal it aka JSON.parse()
+	cropIrrigation := CropActivities{}
+	err = getCropAspect(stub, cropName, activitiesPart, &cropIrrigation)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
-	cropIrrigation.Irrigation = newIrrigationValue //change the owner
+	cropIrrigation.Irrigation = newIrrigationValue //change the irrigation value
 
-	cropJSONasBytes, _ := json.Marshal(cropIrrigation)
-	err = stub.PutState(cropName, cropJSONasBytes) //rewrite the crop
+	err = putCropPart(stub, cropName, activitiesPart, cropIrrigation) //rewrite only the activities of the crop
 	if err != nil {
 		return shim.Error(err.Error())
 	}
+	if cropIrrigation.Irrigation {
+		err = addCropFieldEvent(stub, cropName, fieldEventTreatment, "irrigation")
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
 
 	fmt.Println("- end irrigation value update(successful)")
 	return shim.Success(nil)
@@ -630,39 +908,67 @@ func (t *SimpleChaincode) irrigation(stub shim.ChaincodeStubInterface, args []st
 // ===========================================================
 func (t *SimpleChaincode) addFertilizer(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 
-	if len(args) < 2 {
-		return shim.Error("Incorrect number of arguments. Expecting 2")
+	//   0       1          2            3              4             5          6             7
+	// "crop", "product", "nitrogen %", "phosphorus %", "potassium %", "kg/ha", "applied at", "season"
+	// applied at defaults to the transaction time and season to its year. The
+	// flag of a crop can still be cleared with the arguments "crop", "false".
+	if len(args) != 2 && (len(args) < 6 || len(args) > 8) {
+		return shim.Error("Incorrect number of arguments. Expecting 6 to 8")
 	}
 
 	cropName := args[0]
-	newFertilizerValue, err := strconv.ParseBool(args[1])
-	if err != nil {
-		return shim.Error("Unable to parse boolean")
+	newFertilizerValue := true
+	var application FertilizerApplication
+	var info CropInfo
+	var err error
+	if len(args) == 2 {
+		newFertilizerValue, err = strconv.ParseBool(args[1])
+		if err != nil || newFertilizerValue {
+			return shim.Error("fertilizer applications must state the product, its N/P/K content and rate")
+		}
+	} else {
+		info, err = getCropInfo(stub, cropName)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		application, err = parseFertilizerApplication(stub, info, args[1:])
+		if err != nil {
+			return shim.Error(err.Error())
+		}
 	}
 	fmt.Println("- start fertilization value update ", cropName, newFertilizerValue)
 
-	cropAsBytes, err := stub.GetState(cropName)
+	cropFertilization := CropActivities{}
+	err = getCropAspect(stub, cropName, activitiesPart, &cropFertilization)
 	if err != nil {
-		return shim.Error("Failed to get crop:" + err.Error())
-	} else if cropAsBytes == nil {
-		return shim.Error("crop does not exist")
+		return shim.Error(err.Error())
 	}
+	cropFertilization.AddFertilizer = newFertilizerValue //change the fertilizer value
 
-	cropFertilization := Crop{}
-	err = json.Unmarshal(cropAsBytes, &cropFertilization) //unmarshal it aka JSON.parse()
+	err = putCropPart(stub, cropName, activitiesPart, cropFertilization) //rewrite only the activities of the crop
 	if err != nil {
 		return shim.Error(err.Error())
 	}
-	cropFertilization.AddFertilizer = newFertilizerValue //change the fertilizer value
+	if !cropFertilization.AddFertilizer {
+		fmt.Println("- end addFertilizer value update(successful)")
+		return shim.Success(nil)
+	}
 
-	cropJSONasBytes, _ := json.Marshal(cropFertilization)
-	err = stub.PutState(cropName, cropJSONasBytes) //rewrite the crop
+	err = recordFertilizerApplication(stub, info, &application)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = addCropFieldEvent(stub, cropName, fieldEventTreatment, "fertilizer "+application.Product)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	applicationJSONasBytes, err := json.Marshal(application)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
 
 	fmt.Println("- end addFertilizer value update(successful)")
-	return shim.Success(nil)
+	return shim.Success(applicationJSONasBytes)
 }
 
 // ===========================================================
@@ -670,36 +976,56 @@ func (t *SimpleChaincode) addFertilizer(stub shim.ChaincodeStubInterface, args [
 // ===========================================================
 func (t *SimpleChaincode) applyPesticide(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 
-	if len(args) < 2 {
-		return shim.Error("Incorrect number of arguments. Expecting 2")
+	//   0       1          2                    3
+	// "crop", "product", "dose per hectare", "applied at"
+	// applied at is optional and defaults to the transaction time. The flag of a
+	// crop can still be cleared with the arguments "crop", "false".
+	if len(args) < 2 || len(args) > 4 {
+		return shim.Error("Incorrect number of arguments. Expecting 3 or 4")
 	}
 
 	cropName := args[0]
-	newPesticideValue, err := strconv.ParseBool(args[1])
-	if err != nil {
-		return shim.Error("Unable to parse boolean")
+	newPesticideValue := true
+	var application PesticideApplication
+	var err error
+	if len(args) == 2 {
+		newPesticideValue, err = strconv.ParseBool(args[1])
+		if err != nil || newPesticideValue {
+			return shim.Error("pesticide applications must reference a registered product and dose per hectare")
+		}
+	} else {
+		appliedAt := ""
+		if len(args) == 4 {
+			appliedAt = args[3]
+		}
+		application, err = parsePesticideApplication(stub, cropName, args[1], args[2], appliedAt)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
 	}
 	fmt.Println("- start applyPesticide value update ", cropName, newPesticideValue)
 
-	cropAsBytes, err := stub.GetState(cropName)
-	if err != nil {
-		return shim.Error("Failed to get crop:" + err.Error())
-	} else if cropAsBytes == nil {
-		return shim.Error("crop does not exist")
-	}
-
-	cropPesticideAddition := Crop{}
-	err = json.Unmarshal(cropAsBytes, &cropPesticideAddition) //unmarshal it aka JSON.parse()
+	cropPesticideAddition := CropActivities{}
+	err = getCropAspect(stub, cropName, activitiesPart, &cropPesticideAddition)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
 	cropPesticideAddition.ApplyPesticide = newPesticideValue //change the ApplyPesticide value
 
-	cropJSONasBytes, _ := json.Marshal(cropPesticideAddition)
-	err = stub.PutState(cropName, cropJSONasBytes) //rewrite the crop
+	err = putCropPart(stub, cropName, activitiesPart, cropPesticideAddition) //rewrite only the activities of the crop
 	if err != nil {
 		return shim.Error(err.Error())
 	}
+	if cropPesticideAddition.ApplyPesticide {
+		err = putPesticideApplication(stub, application)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		err = addCropFieldEvent(stub, cropName, fieldEventTreatment, "pesticide "+application.Product)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
 
 	fmt.Println("- end applyPesticide value update(successful)")
 	return shim.Success(nil)
@@ -710,6 +1036,9 @@ func (t *SimpleChaincode) applyPesticide(stub shim.ChaincodeStubInterface, args
 // ===========================================================
 func (t *SimpleChaincode) harvest(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 
+	//   0       1             2
+	// "name", "harvesting", "harvested kg"
+	// the harvested quantity is required when harvesting
 	if len(args) < 2 {
 		return shim.Error("Incorrect number of arguments. Expecting 2")
 	}
@@ -719,27 +1048,51 @@ func (t *SimpleChaincode) harvest(stub shim.ChaincodeStubInterface, args []strin
 	if err != nil {
 		return shim.Error("Unable to parse boolean")
 	}
+	var harvestedKg *float64
+	if newHarvestValue {
+		if len(args) < 3 {
+			return shim.Error("Incorrect number of arguments. Expecting 3 when harvesting")
+		}
+		quantity, err := strconv.ParseFloat(args[2], 64)
+		if err != nil || quantity < 0 {
+			return shim.Error("harvested quantity must be a non-negative number of kilograms")
+		}
+		harvestedKg = &quantity
+	}
 	fmt.Println("- start harvest value update ", cropName, newHarvestValue)
 
-	cropAsBytes, err := stub.GetState(cropName)
-	if err != nil {
-		return shim.Error("Failed to get crop:" + err.Error())
-	} else if cropAsBytes == nil {
-		return shim.Error("crop does not exist")
+	if newHarvestValue {
+		err = checkPreHarvestIntervals(stub, cropName)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
 	}
 
-	cropHarvest := Crop{}
-	err = json.Unmarshal(cropAsBytes, &cropHarvest) //unmarshal it aka JSON.parse()
+	cropHarvest := CropStatus{}
+	err = getCropAspect(stub, cropName, statusPart, &cropHarvest)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
 	cropHarvest.Harvesting = newHarvestValue //change the harvest value
+	cropHarvest.HarvestedAt = ""
+	cropHarvest.HarvestedKg = harvestedKg
+	if newHarvestValue {
+		cropHarvest.HarvestedAt, err = txTimestamp(stub)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
 
-	cropJSONasBytes, _ := json.Marshal(cropHarvest)
-	err = stub.PutState(cropName, cropJSONasBytes) //rewrite the crop
+	err = putCropPart(stub, cropName, statusPart, cropHarvest) //rewrite only the status of the crop
 	if err != nil {
 		return shim.Error(err.Error())
 	}
+	if cropHarvest.Harvesting {
+		err = addCropFieldEvent(stub, cropName, fieldEventHarvest, "")
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
 
 	fmt.Println("- end Harvest value update(successful)")
 	return shim.Success(nil)
@@ -0,0 +1,244 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// A Crop is stored as a base record under the crop name holding CropInfo, plus
+// one sub-record per independently written aspect under a crop~part composite
+// key. Sensor updates only touch the conditions part, operator actions the
+// activities part and harvesting the status part, so transactions writing
+// different aspects of the same crop in one block never invalidate each other
+// with MVCC read conflicts.
+const cropPartIndexName = "crop~part"
+
+const (
+	conditionsPart = "conditions"
+	activitiesPart = "activities"
+	statusPart     = "status"
+)
+
+// cropParts lists every sub-record of a crop, used when composing or deleting it.
+var cropParts = []string{conditionsPart, activitiesPart, statusPart}
+
+// legacyPartFields names a field of each sub-record that is only found in base
+// records stored before the split.
+var legacyPartFields = map[string]string{
+	conditionsPart: "weather",
+	activitiesPart: "irrigation",
+	statusPart:     "harvesting",
+}
+
+// getCrop composes the Crop view from its base record and sub-records.
+// Crops written before the split are stored whole under the base key, their
+// values are kept unless a sub-record has been written since.
+func getCrop(stub shim.ChaincodeStubInterface, name string) (Crop, error) {
+	var crop Crop
+
+	cropAsBytes, err := getCropBase(stub, name)
+	if err != nil {
+		return crop, err
+	}
+	err = json.Unmarshal(cropAsBytes, &crop)
+	if err != nil {
+		return crop, fmt.Errorf("Failed to unmarshal crop to json format %s", err.Error())
+	}
+
+	if err = getCropPart(stub, name, conditionsPart, &crop.CropConditions); err != nil {
+		return crop, err
+	}
+	if err = getCropPart(stub, name, activitiesPart, &crop.CropActivities); err != nil {
+		return crop, err
+	}
+	if err = getCropPart(stub, name, statusPart, &crop.CropStatus); err != nil {
+		return crop, err
+	}
+
+	if crop.Species == "" {
+		crop.Species = strings.ToLower(crop.Name)
+	}
+	// crops on a field share its location and soil instead of keeping a copy
+	if crop.Field != "" {
+		field, err := getField(stub, crop.Field)
+		if err != nil {
+			return crop, err
+		}
+		crop.FarmInfo = FarmInfoType{
+			GeoLocation: field.GeoLocation,
+			SoilType:    field.SoilProfile.Type,
+		}
+	}
+	return crop, nil
+}
+
+// getCropInfo returns the base record of a crop. Crops created before species
+// were recorded take their name as species.
+func getCropInfo(stub shim.ChaincodeStubInterface, name string) (CropInfo, error) {
+	var info CropInfo
+	cropAsBytes, err := getCropBase(stub, name)
+	if err != nil {
+		return info, err
+	}
+	err = json.Unmarshal(cropAsBytes, &info)
+	if err != nil {
+		return info, fmt.Errorf("Failed to unmarshal crop to json format %s", err.Error())
+	}
+	if info.Species == "" {
+		info.Species = strings.ToLower(info.Name)
+	}
+	return info, nil
+}
+
+// putCrop writes the base record and every sub-record of a Crop.
+func putCrop(stub shim.ChaincodeStubInterface, crop Crop) error {
+	cropJSONasBytes, err := json.Marshal(crop.CropInfo)
+	if err != nil {
+		return err
+	}
+	err = stub.PutState(crop.Name, cropJSONasBytes)
+	if err != nil {
+		return err
+	}
+
+	// the base record is not readable yet, so the health score is applied here
+	err = applyHealthScore(stub, crop.CropInfo, cropGrowthStage(crop.CropConditions), &crop.CropConditions)
+	if err != nil {
+		return err
+	}
+	if err = putCropPart(stub, crop.Name, conditionsPart, crop.CropConditions); err != nil {
+		return err
+	}
+	if err = putCropPart(stub, crop.Name, activitiesPart, crop.CropActivities); err != nil {
+		return err
+	}
+	return putCropPart(stub, crop.Name, statusPart, crop.CropStatus)
+}
+
+// putCropInfo rewrites the base record of an existing crop. Crops stored
+// before the split keep the values of a sub-record in the base record until
+// the sub-record is first written, so those values are moved to their
+// sub-records before the base record is replaced.
+func putCropInfo(stub shim.ChaincodeStubInterface, info CropInfo) error {
+	cropAsBytes, err := getCropBase(stub, info.Name)
+	if err != nil {
+		return err
+	}
+	var stored map[string]json.RawMessage
+	err = json.Unmarshal(cropAsBytes, &stored)
+	if err != nil {
+		return fmt.Errorf("Failed to unmarshal crop to json format %s", err.Error())
+	}
+
+	for _, part := range cropParts {
+		if _, legacy := stored[legacyPartFields[part]]; !legacy {
+			continue
+		}
+		partKey, err := stub.CreateCompositeKey(cropPartIndexName, []string{info.Name, part})
+		if err != nil {
+			return err
+		}
+		partAsBytes, err := stub.GetState(partKey)
+		if err != nil {
+			return fmt.Errorf("Failed to get crop %s: %s", part, err.Error())
+		} else if partAsBytes != nil {
+			continue
+		}
+		var value interface{}
+		switch part {
+		case conditionsPart:
+			value = &CropConditions{}
+		case activitiesPart:
+			value = &CropActivities{}
+		case statusPart:
+			value = &CropStatus{}
+		}
+		err = json.Unmarshal(cropAsBytes, value)
+		if err != nil {
+			return fmt.Errorf("Failed to unmarshal crop to json format %s", err.Error())
+		}
+		if err = putCropPart(stub, info.Name, part, value); err != nil {
+			return err
+		}
+	}
+
+	cropJSONasBytes, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(info.Name, cropJSONasBytes)
+}
+
+// getCropBase returns the raw base record of a crop, failing when it does not exist.
+func getCropBase(stub shim.ChaincodeStubInterface, name string) ([]byte, error) {
+	cropAsBytes, err := stub.GetState(name)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get crop: %s", err.Error())
+	} else if cropAsBytes == nil {
+		return nil, fmt.Errorf("crop does not exist: %s", name)
+	}
+	return cropAsBytes, nil
+}
+
+// getCropPart reads one sub-record of a crop into value. A missing sub-record
+// leaves value untouched. The crop itself must be checked with getCropBase.
+func getCropPart(stub shim.ChaincodeStubInterface, name, part string, value interface{}) error {
+	partKey, err := stub.CreateCompositeKey(cropPartIndexName, []string{name, part})
+	if err != nil {
+		return err
+	}
+	partAsBytes, err := stub.GetState(partKey)
+	if err != nil {
+		return fmt.Errorf("Failed to get crop %s: %s", part, err.Error())
+	} else if partAsBytes == nil {
+		return nil
+	}
+	return json.Unmarshal(partAsBytes, value)
+}
+
+// putCropPart writes one sub-record of a crop.
+func putCropPart(stub shim.ChaincodeStubInterface, name, part string, value interface{}) error {
+	partKey, err := stub.CreateCompositeKey(cropPartIndexName, []string{name, part})
+	if err != nil {
+		return err
+	}
+	partJSONasBytes, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(partKey, partJSONasBytes)
+}
+
+// getCropAspect reads the value of one sub-record of an existing crop.
+func getCropAspect(stub shim.ChaincodeStubInterface, name, part string, value interface{}) error {
+	cropAsBytes, err := getCropBase(stub, name)
+	if err != nil {
+		return err
+	}
+	// start from the base record so crops stored before the split keep their values
+	err = json.Unmarshal(cropAsBytes, value)
+	if err != nil {
+		return fmt.Errorf("Failed to unmarshal crop to json format %s", err.Error())
+	}
+	return getCropPart(stub, name, part, value)
+}
@@ -0,0 +1,437 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package chaincode
+
+import (
+	"crypto/ecdsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/lib/cid"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// deviceIndexName is the composite key object type for registered devices.
+const deviceIndexName = "device"
+
+// cropDeviceIndexName indexes the devices bound to a crop.
+const cropDeviceIndexName = "crop~device"
+
+// fieldDeviceIndexName indexes the devices bound to a field.
+const fieldDeviceIndexName = "field~device"
+
+const (
+	deviceActive         = "active"
+	deviceDecommissioned = "decommissioned"
+)
+
+// Device is an IoT device registered on the ledger. Readings are only accepted
+// from active devices bound to the crop they report on, or to the field the
+// crop grows on. Only the MSP that registered a device can bind or
+// decommission it, and it can only bind it to crops and fields it owns.
+type Device struct {
+	ID              string   `json:"id"`
+	Type            string   `json:"type"`
+	Vendor          string   `json:"vendor"`
+	PublicKey       string   `json:"public_key"`
+	CalibrationDate string   `json:"calibration_date"`
+	Farm            string   `json:"farm"`
+	Crops           []string `json:"crops"`
+	Fields          []string `json:"fields"`
+	Status          string   `json:"status"`
+	Decommissioned  string   `json:"decommissioned,omitempty"`
+	Owner           string   `json:"owner"`
+	OwnerMSP        string   `json:"owner_msp"`
+}
+
+// ============================================================
+// registerDevice - register a new IoT device
+// ============================================================
+func (t *SimpleChaincode) registerDevice(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0     1       2         3             4                   5
+	// "id", "type", "vendor", "public key", "calibration date", "farm"
+	if len(args) != 6 {
+		return shim.Error("Incorrect number of arguments. Expecting 6")
+	}
+
+	fmt.Println("- start register device")
+
+	for i, arg := range args {
+		if len(arg) == 0 {
+			return shim.Error(fmt.Sprintf("argument %d must be a non-empty string", i+1))
+		}
+	}
+	publicKey, err := parsePublicKey(args[3])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if _, ok := publicKey.(*ecdsa.PublicKey); !ok {
+		return shim.Error("device public key must be an ECDSA key")
+	}
+	calibrationDate, err := time.Parse("2006-01-02", args[4])
+	if err != nil {
+		return shim.Error("calibration date must be formatted as YYYY-MM-DD")
+	}
+
+	_, err = getDevice(stub, args[0])
+	if err == nil {
+		return shim.Error("This device already exists: " + args[0])
+	}
+
+	owner, err := cid.GetID(stub)
+	if err != nil {
+		return shim.Error("Failed to get invoker identity: " + err.Error())
+	}
+	ownerMSP, err := cid.GetMSPID(stub)
+	if err != nil {
+		return shim.Error("Failed to get invoker MSP: " + err.Error())
+	}
+
+	device := Device{
+		ID:              args[0],
+		Type:            args[1],
+		Vendor:          args[2],
+		PublicKey:       args[3],
+		CalibrationDate: calibrationDate.Format("2006-01-02"),
+		Farm:            args[5],
+		Crops:           []string{},
+		Fields:          []string{},
+		Status:          deviceActive,
+		Owner:           owner,
+		OwnerMSP:        ownerMSP,
+	}
+	err = putDevice(stub, device)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	fmt.Println("- end register device (successful)")
+	return shim.Success(nil)
+}
+
+// ============================================================
+// bindDevice - bind a device to a crop or a field it reports on
+// ============================================================
+func (t *SimpleChaincode) bindDevice(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0     1                  2
+	// "id", "crop" | "field", "target"
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 3")
+	}
+
+	fmt.Println("- start bind device", args[0], args[1], args[2])
+
+	device, err := requireDeviceOwner(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if device.Status != deviceActive {
+		return shim.Error("device is not active: " + device.ID)
+	}
+
+	target := args[2]
+	switch args[1] {
+	case "crop":
+		// binding lets the device report for the target, so the invoker
+		// must own the target as well as the device
+		info, err := getCropInfo(stub, target)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		err = requireOwnerMSP(stub, info.OwnerMSP, "crop "+info.Name)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if containsString(device.Crops, target) {
+			return shim.Error("device is already bound to crop " + target)
+		}
+		device.Crops = append(device.Crops, target)
+
+		cropDeviceIndexKey, err := stub.CreateCompositeKey(cropDeviceIndexName, []string{target, device.ID})
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		err = stub.PutState(cropDeviceIndexKey, []byte{0x00})
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	case "field":
+		field, err := getField(stub, target)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		err = requireOwnerMSP(stub, field.OwnerMSP, "field "+field.ID)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if containsString(device.Fields, target) {
+			return shim.Error("device is already bound to field " + target)
+		}
+		device.Fields = append(device.Fields, target)
+
+		fieldDeviceIndexKey, err := stub.CreateCompositeKey(fieldDeviceIndexName, []string{target, device.ID})
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		err = stub.PutState(fieldDeviceIndexKey, []byte{0x00})
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	default:
+		return shim.Error("binding type must be crop or field")
+	}
+
+	err = putDevice(stub, device)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	fmt.Println("- end bind device (successful)")
+	return shim.Success(nil)
+}
+
+// ============================================================
+// decommissionDevice - retire a device, its readings are refused afterwards
+// ============================================================
+func (t *SimpleChaincode) decommissionDevice(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0
+	// "id"
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	fmt.Println("- start decommission device", args[0])
+
+	device, err := requireDeviceOwner(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if device.Status == deviceDecommissioned {
+		return shim.Error("device is already decommissioned: " + device.ID)
+	}
+	timestamp, err := txTimestamp(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// the bindings stay on the device record for audit, only the indexes are removed
+	for _, cropName := range device.Crops {
+		cropDeviceIndexKey, err := stub.CreateCompositeKey(cropDeviceIndexName, []string{cropName, device.ID})
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		err = stub.DelState(cropDeviceIndexKey)
+		if err != nil {
+			return shim.Error("Failed to delete state:" + err.Error())
+		}
+	}
+	for _, fieldID := range device.Fields {
+		fieldDeviceIndexKey, err := stub.CreateCompositeKey(fieldDeviceIndexName, []string{fieldID, device.ID})
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		err = stub.DelState(fieldDeviceIndexKey)
+		if err != nil {
+			return shim.Error("Failed to delete state:" + err.Error())
+		}
+	}
+
+	device.Status = deviceDecommissioned
+	device.Decommissioned = timestamp
+	err = putDevice(stub, device)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	fmt.Println("- end decommission device (successful)")
+	return shim.Success(nil)
+}
+
+// ============================================================
+// readDevice - read a device from chaincode state
+// ============================================================
+func (t *SimpleChaincode) readDevice(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting id of the device to query")
+	}
+
+	device, err := getDevice(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	deviceJSONasBytes, err := json.Marshal(device)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(deviceJSONasBytes)
+}
+
+// ============================================================
+// devicesOfCrop - list the active devices bound to a crop or to its field
+// ============================================================
+func (t *SimpleChaincode) devicesOfCrop(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	info, err := getCropInfo(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	devices, err := getBoundDevices(stub, cropDeviceIndexName, info.Name, nil)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if info.Field != "" {
+		devices, err = getBoundDevices(stub, fieldDeviceIndexName, info.Field, devices)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	devicesJSON, err := json.Marshal(devices)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(devicesJSON)
+}
+
+// getBoundDevices appends the devices of a crop~device or field~device index
+// entry to devices, skipping devices already listed.
+func getBoundDevices(stub shim.ChaincodeStubInterface, indexName, target string, devices []Device) ([]Device, error) {
+	if devices == nil {
+		devices = []Device{}
+	}
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(indexName, []string{target})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	for resultsIterator.HasNext() {
+		responseRange, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		_, keyParts, err := stub.SplitCompositeKey(responseRange.Key)
+		if err != nil {
+			return nil, err
+		}
+		duplicate := false
+		for _, device := range devices {
+			if device.ID == keyParts[1] {
+				duplicate = true
+				break
+			}
+		}
+		if duplicate {
+			continue
+		}
+		device, err := getDevice(stub, keyParts[1])
+		if err != nil {
+			return nil, err
+		}
+		devices = append(devices, device)
+	}
+	return devices, nil
+}
+
+// checkDeviceForCrop returns the device when it is registered, active and bound
+// to the crop or to the field of the crop, and an error explaining why
+// readings are refused otherwise.
+func checkDeviceForCrop(stub shim.ChaincodeStubInterface, deviceID, cropName string) (Device, error) {
+	device, err := getDevice(stub, deviceID)
+	if err != nil {
+		return device, err
+	}
+	if device.Status != deviceActive {
+		return device, fmt.Errorf("device is not active: %s", deviceID)
+	}
+	if containsString(device.Crops, cropName) {
+		return device, nil
+	}
+	if len(device.Fields) > 0 {
+		info, err := getCropInfo(stub, cropName)
+		if err != nil {
+			return device, err
+		}
+		if info.Field != "" && containsString(device.Fields, info.Field) {
+			return device, nil
+		}
+	}
+	return device, fmt.Errorf("device %s is not bound to crop %s or its field", deviceID, cropName)
+}
+
+// requireDeviceOwner reads a device and checks the invoker belongs to the MSP
+// that registered it.
+func requireDeviceOwner(stub shim.ChaincodeStubInterface, deviceID string) (Device, error) {
+	device, err := getDevice(stub, deviceID)
+	if err != nil {
+		return device, err
+	}
+	mspID, err := cid.GetMSPID(stub)
+	if err != nil {
+		return device, fmt.Errorf("Failed to get invoker MSP: %s", err.Error())
+	}
+	if mspID != device.OwnerMSP {
+		return device, fmt.Errorf("device %s can only be used from MSP %s", device.ID, device.OwnerMSP)
+	}
+	return device, nil
+}
+
+// getDevice reads a registered device, failing when it does not exist.
+func getDevice(stub shim.ChaincodeStubInterface, deviceID string) (Device, error) {
+	var device Device
+	err := getAsset(stub, deviceIndexName, deviceID, &device)
+	return device, err
+}
+
+// putDevice writes a device to chaincode state.
+func putDevice(stub shim.ChaincodeStubInterface, device Device) error {
+	return putAsset(stub, deviceIndexName, device.ID, device)
+}
+
+// parsePublicKey decodes a PEM encoded PKIX public key.
+func parsePublicKey(publicKeyPEM string) (interface{}, error) {
+	block, _ := pem.Decode([]byte(publicKeyPEM))
+	if block == nil {
+		return nil, fmt.Errorf("public key must be PEM encoded")
+	}
+	publicKey, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse public key: %s", err.Error())
+	}
+	return publicKey, nil
+}
+
+// containsString reports whether value is one of values.
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,152 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package chaincode
+
+import (
+	"fmt"
+	"math"
+)
+
+// lateBlightModel implements the Hutton criteria for potato and tomato late
+// blight (Phytophthora infestans): a Hutton day has a minimum temperature of
+// at least 10°C and at least 6 hours of relative humidity of 90% or more. Two
+// consecutive Hutton days make a Hutton period, the point to protect the crop.
+type lateBlightModel struct{}
+
+func (lateBlightModel) Pathogen() string   { return "phytophthora infestans" }
+func (lateBlightModel) Threshold() float64 { return 100 }
+
+func (lateBlightModel) Evaluate(hours []weatherHour) (float64, string) {
+	huttonDays, consecutive, longest := 0, 0, 0
+	var previous []weatherHour
+	for _, day := range dailyHours(hours) {
+		if !nextDay(previous, day) {
+			consecutive = 0
+		}
+		previous = day
+		minCelcius, humidHours := math.Inf(1), 0.0
+		for _, hour := range day {
+			minCelcius = math.Min(minCelcius, hour.Celcius)
+			if hour.RelativeHumidity >= 90 {
+				humidHours += hour.Hours
+			}
+		}
+		if minCelcius >= 10 && humidHours >= 6 {
+			huttonDays++
+			consecutive++
+		} else {
+			consecutive = 0
+		}
+		if consecutive > longest {
+			longest = consecutive
+		}
+	}
+
+	// a single Hutton day is half way to a Hutton period
+	score := 50 * float64(longest)
+	explanation := fmt.Sprintf("%d Hutton days (min temperature >= 10°C and >= 6h RH >= 90%%), longest run %d", huttonDays, longest)
+	if longest >= 2 {
+		explanation += ", Hutton period reached"
+	}
+	return score, explanation
+}
+
+// powderyMildewModel implements the Gubler-Thomas risk index for grape
+// powdery mildew (Erysiphe necator). The index starts once three consecutive
+// days had at least 6 hours between 21°C and 30°C, then rises by 20 for every
+// such day and falls by 10 for every other day or day above 35°C.
+type powderyMildewModel struct{}
+
+func (powderyMildewModel) Pathogen() string   { return "erysiphe necator" }
+func (powderyMildewModel) Threshold() float64 { return 60 }
+
+func (powderyMildewModel) Evaluate(hours []weatherHour) (float64, string) {
+	index, consecutive, started, favourable := 0.0, 0, false, 0
+	var previous []weatherHour
+	for _, day := range dailyHours(hours) {
+		if !nextDay(previous, day) {
+			consecutive = 0
+		}
+		previous = day
+		favourableHours, hot := 0.0, false
+		for _, hour := range day {
+			if hour.Celcius >= 21 && hour.Celcius <= 30 {
+				favourableHours += hour.Hours
+			}
+			if hour.Celcius > 35 {
+				hot = true
+			}
+		}
+		dayFavourable := favourableHours >= 6
+
+		if !started {
+			if dayFavourable {
+				consecutive++
+			} else {
+				consecutive = 0
+			}
+			if consecutive == 3 {
+				started, index = true, 60
+			}
+			continue
+		}
+		if dayFavourable {
+			favourable++
+			index += 20
+		} else {
+			index -= 10
+		}
+		if hot {
+			index -= 10
+		}
+		index = math.Max(0, math.Min(100, index))
+	}
+
+	if !started {
+		return 0, fmt.Sprintf("index not started, %d consecutive days with >= 6h between 21°C and 30°C of the 3 needed", consecutive)
+	}
+	return index, fmt.Sprintf("index started after 3 favourable days, %d favourable days since", favourable)
+}
+
+// pythiumRootRotModel scores root rot risk (Pythium, Phytophthora) from
+// water-saturated soil: roots exposed to saturated soil for 18 hours are at
+// full risk. The longest continuous saturated stretch counts.
+type pythiumRootRotModel struct{}
+
+// saturatedMoisturePercent is the volumetric soil moisture treated as saturated.
+const saturatedMoisturePercent = 45.0
+
+func (pythiumRootRotModel) Pathogen() string   { return "pythium" }
+func (pythiumRootRotModel) Threshold() float64 { return 100 }
+
+func (pythiumRootRotModel) Evaluate(hours []weatherHour) (float64, string) {
+	saturated, longest := 0.0, 0.0
+	for i, hour := range hours {
+		// a gap without readings breaks the saturated stretch
+		if i > 0 && hour.Start.Sub(hours[i-1].Start).Hours() > hours[i-1].Hours {
+			saturated = 0
+		}
+		if hour.SoilMoisture >= saturatedMoisturePercent {
+			saturated += hour.Hours
+		} else {
+			saturated = 0
+		}
+		longest = math.Max(longest, saturated)
+	}
+	return longest / 18 * 100, fmt.Sprintf("longest saturated soil stretch %.1fh (moisture >= %g%%) of 18h", longest, saturatedMoisturePercent)
+}
@@ -0,0 +1,290 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+const (
+	diseaseRiskEvent = "DiseaseRisk"
+	// a reading stands for the conditions until the next reading, at most this long
+	maxReadingGap = 2 * time.Hour
+	// reading history evaluated when no window is given
+	defaultRiskWindowDays = 7
+)
+
+// DiseaseRiskModel is a disease risk model evaluated over the reading history
+// of a crop. Models are registered in diseaseRiskModels by name; adding a
+// model only needs an implementation and a registry entry.
+type DiseaseRiskModel interface {
+	// Pathogen is the pathogen the model predicts.
+	Pathogen() string
+	// Threshold is the score from which the risk is reported as high.
+	Threshold() float64
+	// Evaluate scores the risk from 0 to 100 over the weather hours, ordered
+	// by time, and explains the score.
+	Evaluate(hours []weatherHour) (float64, string)
+}
+
+// diseaseRiskModels holds every available disease risk model by name.
+var diseaseRiskModels = map[string]DiseaseRiskModel{
+	"lateblight":     lateBlightModel{},
+	"powderymildew":  powderyMildewModel{},
+	"pythiumrootrot": pythiumRootRotModel{},
+}
+
+// DiseaseRiskType is the latest evaluation of one disease risk model on a crop.
+type DiseaseRiskType struct {
+	Pathogen    string  `json:"pathogen"`
+	Score       float64 `json:"score"`
+	High        bool    `json:"high"`
+	Explanation string  `json:"explanation"`
+	From        string  `json:"from"`
+	To          string  `json:"to"`
+	Evaluated   string  `json:"evaluated"`
+}
+
+// DiseaseRiskCrossing is emitted as DiseaseRisk event when a model's score
+// crosses its threshold in either direction.
+type DiseaseRiskCrossing struct {
+	Crop  string          `json:"crop"`
+	Model string          `json:"model"`
+	Risk  DiseaseRiskType `json:"risk"`
+}
+
+// weatherHour is a stretch of time with the conditions of one reading.
+// Relative humidity is derived from the absolute humidity of the reading and
+// stands in for leaf wetness, which is not measured.
+type weatherHour struct {
+	Start            time.Time
+	Hours            float64
+	Celcius          float64
+	RelativeHumidity float64
+	SoilMoisture     float64
+}
+
+// ============================================================
+// evaluateDiseaseRisk - evaluate disease risk models over the reading history of a crop
+// ============================================================
+func (t *SimpleChaincode) evaluateDiseaseRisk(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0       1         2
+	// "crop", "models", "days"
+	// models is a comma separated list of model names, empty for every model.
+	// days is the reading history to evaluate up to now, empty for 7.
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 3")
+	}
+
+	fmt.Println("- start evaluate disease risk", args[0])
+
+	cropName := args[0]
+	names, err := diseaseRiskModelNames(args[1])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	days := defaultRiskWindowDays
+	if args[2] != "" {
+		days, err = strconv.Atoi(args[2])
+		if err != nil || days <= 0 {
+			return shim.Error("days must be a positive integer")
+		}
+	}
+
+	now, err := txTimestamp(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	to, _ := time.Parse(readingTimeLayout, now)
+	from := to.AddDate(0, 0, -days).Format(readingTimeLayout)
+	readings, err := getReadings(stub, cropName, "", from, now)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	hours := weatherHours(readings)
+
+	var conditions CropConditions
+	err = getCropAspect(stub, cropName, conditionsPart, &conditions)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if conditions.DiseaseRisks == nil {
+		conditions.DiseaseRisks = map[string]DiseaseRiskType{}
+	}
+
+	crossings := []DiseaseRiskCrossing{}
+	for _, name := range names {
+		model := diseaseRiskModels[name]
+		risk := DiseaseRiskType{
+			Pathogen:  model.Pathogen(),
+			From:      from,
+			To:        now,
+			Evaluated: now,
+		}
+		if len(hours) == 0 {
+			risk.Explanation = "no readings in the evaluated window"
+		} else {
+			score, explanation := model.Evaluate(hours)
+			risk.Score = roundDecimals(math.Max(0, math.Min(100, score)), 2)
+			risk.Explanation = explanation
+		}
+		risk.High = risk.Score >= model.Threshold()
+		if risk.High != conditions.DiseaseRisks[name].High {
+			crossings = append(crossings, DiseaseRiskCrossing{Crop: cropName, Model: name, Risk: risk})
+		}
+		conditions.DiseaseRisks[name] = risk
+	}
+
+	err = putCropConditions(stub, cropName, &conditions)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	// a transaction carries a single event, every crossing goes into it
+	if len(crossings) > 0 {
+		crossingsJSON, err := json.Marshal(crossings)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		err = stub.SetEvent(diseaseRiskEvent, crossingsJSON)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+	risksJSON, err := json.Marshal(conditions.DiseaseRisks)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	fmt.Println("- end evaluate disease risk (successful)")
+	return shim.Success(risksJSON)
+}
+
+// ============================================================
+// diseaseRiskModelsList - list the available disease risk models
+// ============================================================
+func (t *SimpleChaincode) diseaseRiskModelsList(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	type modelInfo struct {
+		Name      string  `json:"name"`
+		Pathogen  string  `json:"pathogen"`
+		Threshold float64 `json:"threshold"`
+	}
+
+	names, _ := diseaseRiskModelNames("")
+	models := []modelInfo{}
+	for _, name := range names {
+		model := diseaseRiskModels[name]
+		models = append(models, modelInfo{name, model.Pathogen(), model.Threshold()})
+	}
+	modelsJSON, err := json.Marshal(models)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(modelsJSON)
+}
+
+// diseaseRiskModelNames validates a comma separated list of model names. An
+// empty list selects every model. Names are sorted so every peer evaluates
+// the models in the same order.
+func diseaseRiskModelNames(list string) ([]string, error) {
+	var names []string
+	if list == "" {
+		for name := range diseaseRiskModels {
+			names = append(names, name)
+		}
+	} else {
+		for _, name := range strings.Split(list, ",") {
+			name = strings.ToLower(strings.TrimSpace(name))
+			if _, ok := diseaseRiskModels[name]; !ok {
+				return nil, fmt.Errorf("unknown disease risk model: %s", name)
+			}
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// weatherHours turns readings of any sensor into consecutive stretches of
+// time. Each reading stands for the time until the next one, at most
+// maxReadingGap, so gaps in the data do not count as any condition.
+func weatherHours(readings []SensorReading) []weatherHour {
+	sorted := append([]SensorReading(nil), readings...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Timestamp < sorted[j].Timestamp })
+
+	hours := []weatherHour{}
+	for i, reading := range sorted {
+		start, err := time.Parse(readingTimeLayout, reading.Timestamp)
+		if err != nil {
+			continue
+		}
+		duration := maxReadingGap
+		if i+1 < len(sorted) {
+			next, err := time.Parse(readingTimeLayout, sorted[i+1].Timestamp)
+			if err == nil && next.Sub(start) < duration {
+				duration = next.Sub(start)
+			}
+		}
+		if duration <= 0 {
+			// readings of several sensors at the same time
+			continue
+		}
+		celcius := reading.Weather.Temperature.Celcius
+		relativeHumidity := 100 * actualVapourPressure(reading.Weather.Humidity.CubicMeter, celcius) / saturationVapourPressure(celcius)
+		hours = append(hours, weatherHour{
+			Start:            start,
+			Hours:            duration.Hours(),
+			Celcius:          celcius,
+			RelativeHumidity: math.Min(100, relativeHumidity),
+			SoilMoisture:     reading.SoilCondition.Moisture.CubicMeter,
+		})
+	}
+	return hours
+}
+
+// dailyHours groups weather hours by UTC day in time order.
+func dailyHours(hours []weatherHour) [][]weatherHour {
+	days := [][]weatherHour{}
+	for _, hour := range hours {
+		if len(days) == 0 || days[len(days)-1][0].Start.Format("2006-01-02") != hour.Start.Format("2006-01-02") {
+			days = append(days, []weatherHour{})
+		}
+		days[len(days)-1] = append(days[len(days)-1], hour)
+	}
+	return days
+}
+
+// nextDay reports whether a day of weather hours is the calendar day after
+// the previous one. Days without readings are missing from dailyHours, so
+// runs of consecutive days break where this is false.
+func nextDay(previous, day []weatherHour) bool {
+	if previous == nil {
+		return false
+	}
+	y, m, d := previous[0].Start.Date()
+	return time.Date(y, m, d+1, 0, 0, 0, 0, time.UTC).Format("2006-01-02") == day[0].Start.Format("2006-01-02")
+}
@@ -0,0 +1,669 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/lib/cid"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+const (
+	farmIndexName         = "farm"
+	fieldIndexName        = "field"
+	farmFieldIndexName    = "farm~field"
+	fieldHistoryIndexName = "field~timestamp~txid"
+)
+
+// Kinds of entries in the history of a field.
+const (
+	fieldEventCrop      = "crop"
+	fieldEventTreatment = "treatment"
+	fieldEventHarvest   = "harvest"
+)
+
+// Farm is a farm holding one or more fields. OwnerMSP is the organisation
+// that created it, only that organisation adds fields to it.
+type Farm struct {
+	ID           string          `json:"id"`
+	Name         string          `json:"name"`
+	Owner        string          `json:"owner"`
+	OwnerMSP     string          `json:"owner_msp,omitempty"`
+	AreaHectares float64         `json:"area_hectares"`
+	GeoLocation  GeoLocationType `json:"geo_location"`
+}
+
+// SoilProfileType describes the soil of a field.
+type SoilProfileType struct {
+	Type     string `json:"type"`
+	Texture  string `json:"texture"`
+	Drainage string `json:"drainage"`
+}
+
+// Field is a piece of land of a farm that crops are grown on season after season.
+// OwnerMSP is the organisation of the farm, only that organisation changes
+// the field and places crops on it.
+type Field struct {
+	ID           string          `json:"id"`
+	Farm         string          `json:"farm"`
+	Name         string          `json:"name"`
+	Owner        string          `json:"owner"`
+	OwnerMSP     string          `json:"owner_msp,omitempty"`
+	AreaHectares float64         `json:"area_hectares"`
+	GeoLocation  GeoLocationType `json:"geo_location"`
+	SoilProfile  SoilProfileType `json:"soil_profile"`
+	Boundary     *GeoJSONPolygon `json:"boundary,omitempty"`
+}
+
+// FieldEvent is one entry in the history of a field, e.g. a crop planted on it
+// or a treatment applied to one of its crops.
+type FieldEvent struct {
+	Field     string `json:"field"`
+	Timestamp string `json:"timestamp"`
+	TxID      string `json:"tx_id"`
+	Kind      string `json:"kind"`
+	Ref       string `json:"ref"`
+	Detail    string `json:"detail,omitempty"`
+}
+
+// ============================================================
+// initFarm - create a new farm
+// ============================================================
+func (t *SimpleChaincode) initFarm(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0     1       2        3              4           5
+	// "id", "name", "owner", "area (ha)", "latitude", "longitude"
+	if len(args) != 6 {
+		return shim.Error("Incorrect number of arguments. Expecting 6")
+	}
+
+	fmt.Println("- start init farm")
+
+	if len(args[0]) == 0 || len(args[2]) == 0 {
+		return shim.Error("farm id and owner must be non-empty strings")
+	}
+	area, err := strconv.ParseFloat(args[3], 64)
+	if err != nil || area <= 0 {
+		return shim.Error("area must be a positive number of hectares")
+	}
+	location, err := parseGeoLocation(args[4], args[5])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	_, err = getFarm(stub, args[0])
+	if err == nil {
+		return shim.Error("This farm already exists: " + args[0])
+	}
+	ownerMSP, err := cid.GetMSPID(stub)
+	if err != nil {
+		return shim.Error("Failed to get invoker MSP: " + err.Error())
+	}
+
+	farm := Farm{
+		ID:           args[0],
+		Name:         args[1],
+		Owner:        args[2],
+		OwnerMSP:     ownerMSP,
+		AreaHectares: area,
+		GeoLocation:  location,
+	}
+	err = putAsset(stub, farmIndexName, farm.ID, farm)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	fmt.Println("- end init farm (successful)")
+	return shim.Success(nil)
+}
+
+// ============================================================
+// initField - create a new field on a farm
+// ============================================================
+func (t *SimpleChaincode) initField(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0     1       2       3        4              5           6            7            8               9
+	// "id", "farm", "name", "owner", "area (ha)", "latitude", "longitude", "soil type", "soil texture", "drainage"
+	// owner may be empty to inherit the owner of the farm.
+	if len(args) != 10 {
+		return shim.Error("Incorrect number of arguments. Expecting 10")
+	}
+
+	fmt.Println("- start init field")
+
+	if len(args[0]) == 0 {
+		return shim.Error("field id must be a non-empty string")
+	}
+	farm, err := getFarm(stub, args[1])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = requireOwnerMSP(stub, farm.OwnerMSP, "farm "+farm.ID)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	area, err := strconv.ParseFloat(args[4], 64)
+	if err != nil || area <= 0 {
+		return shim.Error("area must be a positive number of hectares")
+	}
+	location, err := parseGeoLocation(args[5], args[6])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	_, err = getField(stub, args[0])
+	if err == nil {
+		return shim.Error("This field already exists: " + args[0])
+	}
+
+	owner := args[3]
+	if owner == "" {
+		owner = farm.Owner
+	}
+	field := Field{
+		ID:           args[0],
+		Farm:         farm.ID,
+		Name:         args[2],
+		Owner:        owner,
+		OwnerMSP:     farm.OwnerMSP,
+		AreaHectares: area,
+		GeoLocation:  location,
+		SoilProfile: SoilProfileType{
+			Type:     strings.ToLower(args[7]),
+			Texture:  strings.ToLower(args[8]),
+			Drainage: strings.ToLower(args[9]),
+		},
+	}
+	err = putAsset(stub, fieldIndexName, field.ID, field)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	//  ==== Index the field to enable farm-based range queries ====
+	farmFieldIndexKey, err := stub.CreateCompositeKey(farmFieldIndexName, []string{farm.ID, field.ID})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = stub.PutState(farmFieldIndexKey, []byte{0x00})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	fmt.Println("- end init field (successful)")
+	return shim.Success(nil)
+}
+
+// ============================================================
+// readFarm - read a farm from chaincode state
+// ============================================================
+func (t *SimpleChaincode) readFarm(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting id of the farm to query")
+	}
+
+	farm, err := getFarm(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	farmJSONasBytes, err := json.Marshal(farm)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(farmJSONasBytes)
+}
+
+// ============================================================
+// readField - read a field from chaincode state
+// ============================================================
+func (t *SimpleChaincode) readField(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting id of the field to query")
+	}
+
+	field, err := getField(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	fieldJSONasBytes, err := json.Marshal(field)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(fieldJSONasBytes)
+}
+
+// ============================================================
+// setFieldBoundary - set the GeoJSON polygon boundary of a field
+// ============================================================
+func (t *SimpleChaincode) setFieldBoundary(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0        1
+	// "field", "geojson polygon"
+	// The area of the field is recomputed from the boundary.
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+
+	fmt.Println("- start set field boundary", args[0])
+
+	field, err := getField(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = requireOwnerMSP(stub, field.OwnerMSP, "field "+field.ID)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	var boundary GeoJSONPolygon
+	err = json.Unmarshal([]byte(args[1]), &boundary)
+	if err != nil {
+		return shim.Error("Failed to decode boundary: " + err.Error())
+	}
+	err = validatePolygon(boundary)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	field.Boundary = &boundary
+	field.AreaHectares = polygonAreaHectares(boundary)
+	err = putAsset(stub, fieldIndexName, field.ID, field)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	fmt.Println("- end set field boundary (successful)")
+	return shim.Success(nil)
+}
+
+// ============================================================
+// fieldContainsPoint - check whether a point lies inside a field boundary
+// ============================================================
+func (t *SimpleChaincode) fieldContainsPoint(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0        1           2
+	// "field", "latitude", "longitude"
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 3")
+	}
+
+	field, err := getField(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	location, err := parseGeoLocation(args[1], args[2])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	inside, err := fieldContains(field, location)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	resultJSON, err := json.Marshal(struct {
+		Field  string `json:"field"`
+		Inside bool   `json:"inside"`
+	}{field.ID, inside})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(resultJSON)
+}
+
+// ============================================================
+// fieldsOfFarm - list the fields of a farm
+// ============================================================
+func (t *SimpleChaincode) fieldsOfFarm(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(farmFieldIndexName, []string{args[0]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	fields := []Field{}
+	for resultsIterator.HasNext() {
+		responseRange, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		_, keyParts, err := stub.SplitCompositeKey(responseRange.Key)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		field, err := getField(stub, keyParts[1])
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		fields = append(fields, field)
+	}
+
+	fieldsJSON, err := json.Marshal(fields)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(fieldsJSON)
+}
+
+// ============================================================
+// assignCropField - move an existing crop onto a field
+// ============================================================
+func (t *SimpleChaincode) assignCropField(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0       1
+	// "crop", "field"
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+
+	fmt.Println("- start assign crop field", args[0], args[1])
+
+	var info CropInfo
+	cropAsBytes, err := getCropBase(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = json.Unmarshal(cropAsBytes, &info)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if info.Field != "" {
+		return shim.Error("crop " + info.Name + " is already on field " + info.Field)
+	}
+	// only the organisation of the crop moves it, and only onto its own fields
+	err = requireOwnerMSP(stub, info.OwnerMSP, "crop "+info.Name)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	field, err := getField(stub, args[1])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = requireOwnerMSP(stub, field.OwnerMSP, "field "+field.ID)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// the crop now takes its location from the field, move its index entry
+	err = delGeohashIndex(stub, info.Name, info.FarmInfo.GeoLocation)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = putGeohashIndex(stub, info.Name, field.GeoLocation)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	info.Field = field.ID
+	info.FarmInfo = FarmInfoType{}
+	err = putCropInfo(stub, info)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = addFieldEvent(stub, field.ID, fieldEventCrop, info.Name, "")
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	// crops without soil tests of their own take those of the field
+	err = refreshCropSoilCondition(stub, info)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	fmt.Println("- end assign crop field (successful)")
+	return shim.Success(nil)
+}
+
+// ============================================================
+// fieldHistory - list the crops, soil tests and treatments of a field
+// ============================================================
+func (t *SimpleChaincode) fieldHistory(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0        1       2
+	// "field", "from", "to"
+	// from and to may be empty to leave that side of the query open.
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 3")
+	}
+
+	var from, to string
+	var err error
+	if args[1] != "" {
+		if from, err = parseReadingTime(args[1]); err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+	if args[2] != "" {
+		if to, err = parseReadingTime(args[2]); err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(fieldHistoryIndexName, []string{args[0]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	events := []FieldEvent{}
+	for resultsIterator.HasNext() {
+		responseRange, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		var event FieldEvent
+		err = json.Unmarshal(responseRange.Value, &event)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if from != "" && event.Timestamp < from {
+			continue
+		}
+		if to != "" && event.Timestamp > to {
+			break
+		}
+		events = append(events, event)
+	}
+
+	eventsJSON, err := json.Marshal(events)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(eventsJSON)
+}
+
+// addFieldEvent appends an entry to the history of a field. Every entry is
+// written under its own key, so concurrent events never conflict.
+func addFieldEvent(stub shim.ChaincodeStubInterface, fieldID, kind, ref, detail string) error {
+	timestamp, err := txTimestamp(stub)
+	if err != nil {
+		return err
+	}
+	event := FieldEvent{
+		Field:     fieldID,
+		Timestamp: timestamp,
+		TxID:      stub.GetTxID(),
+		Kind:      kind,
+		Ref:       ref,
+		Detail:    detail,
+	}
+	eventKey, err := stub.CreateCompositeKey(fieldHistoryIndexName, []string{fieldID, timestamp, event.TxID, kind, ref})
+	if err != nil {
+		return err
+	}
+	eventJSONasBytes, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(eventKey, eventJSONasBytes)
+}
+
+// addCropFieldEvent records an event of a crop in the history of its field.
+// Crops that are not on a field have no history to add to.
+func addCropFieldEvent(stub shim.ChaincodeStubInterface, cropName, kind, detail string) error {
+	var info CropInfo
+	cropAsBytes, err := getCropBase(stub, cropName)
+	if err != nil {
+		return err
+	}
+	err = json.Unmarshal(cropAsBytes, &info)
+	if err != nil || info.Field == "" {
+		return err
+	}
+	return addFieldEvent(stub, info.Field, kind, cropName, detail)
+}
+
+// getFieldCrops returns the crops growing on a field, found through the crop
+// entries of its history. Deleted and harvested crops are left out.
+func getFieldCrops(stub shim.ChaincodeStubInterface, fieldID string) ([]CropInfo, error) {
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(fieldHistoryIndexName, []string{fieldID})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	crops := []CropInfo{}
+	seen := map[string]bool{}
+	for resultsIterator.HasNext() {
+		responseRange, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		_, keyParts, err := stub.SplitCompositeKey(responseRange.Key)
+		if err != nil {
+			return nil, err
+		}
+		if keyParts[3] != fieldEventCrop || seen[keyParts[4]] {
+			continue
+		}
+		seen[keyParts[4]] = true
+		cropAsBytes, err := stub.GetState(keyParts[4])
+		if err != nil {
+			return nil, fmt.Errorf("Failed to get crop: %s", err.Error())
+		} else if cropAsBytes == nil {
+			continue
+		}
+		info, err := getCropInfo(stub, keyParts[4])
+		if err != nil {
+			return nil, err
+		}
+		// a crop of the same name may have been created again elsewhere
+		if info.Field != fieldID {
+			continue
+		}
+		var status CropStatus
+		err = getCropAspect(stub, info.Name, statusPart, &status)
+		if err != nil {
+			return nil, err
+		}
+		if !status.Harvesting {
+			crops = append(crops, info)
+		}
+	}
+	return crops, nil
+}
+
+// fieldContains reports whether the location lies inside the field boundary.
+func fieldContains(field Field, location GeoLocationType) (bool, error) {
+	if field.Boundary == nil {
+		return false, fmt.Errorf("field %s has no boundary", field.ID)
+	}
+	return polygonContains(*field.Boundary, location.Longitude, location.Latitude), nil
+}
+
+// getFarm reads a farm, failing when it does not exist.
+func getFarm(stub shim.ChaincodeStubInterface, farmID string) (Farm, error) {
+	var farm Farm
+	err := getAsset(stub, farmIndexName, farmID, &farm)
+	return farm, err
+}
+
+// getField reads a field, failing when it does not exist.
+func getField(stub shim.ChaincodeStubInterface, fieldID string) (Field, error) {
+	var field Field
+	err := getAsset(stub, fieldIndexName, fieldID, &field)
+	return field, err
+}
+
+// getAsset reads the asset stored under the objectType~id composite key.
+func getAsset(stub shim.ChaincodeStubInterface, objectType, id string, value interface{}) error {
+	assetKey, err := stub.CreateCompositeKey(objectType, []string{id})
+	if err != nil {
+		return err
+	}
+	assetAsBytes, err := stub.GetState(assetKey)
+	if err != nil {
+		return fmt.Errorf("Failed to get %s: %s", objectType, err.Error())
+	} else if assetAsBytes == nil {
+		return fmt.Errorf("%s does not exist: %s", objectType, id)
+	}
+	return json.Unmarshal(assetAsBytes, value)
+}
+
+// requireOwnerMSP checks the invoker belongs to ownerMSP, the organisation
+// that created an asset. Assets stored before their owner MSP was recorded
+// have none and can no longer be changed.
+func requireOwnerMSP(stub shim.ChaincodeStubInterface, ownerMSP, asset string) error {
+	mspID, err := cid.GetMSPID(stub)
+	if err != nil {
+		return fmt.Errorf("Failed to get invoker MSP: %s", err.Error())
+	}
+	if ownerMSP == "" {
+		return fmt.Errorf("%s has no owner MSP recorded and cannot be changed", asset)
+	}
+	if mspID != ownerMSP {
+		return fmt.Errorf("%s can only be changed from MSP %s", asset, ownerMSP)
+	}
+	return nil
+}
+
+// putAsset writes an asset under the objectType~id composite key.
+func putAsset(stub shim.ChaincodeStubInterface, objectType, id string, value interface{}) error {
+	assetKey, err := stub.CreateCompositeKey(objectType, []string{id})
+	if err != nil {
+		return err
+	}
+	assetJSONasBytes, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(assetKey, assetJSONasBytes)
+}
+
+// parseGeoLocation parses a latitude/longitude pair in decimal degrees.
+func parseGeoLocation(latitude, longitude string) (GeoLocationType, error) {
+	var location GeoLocationType
+
+	lat, err := strconv.ParseFloat(latitude, 64)
+	if err != nil || lat < -90 || lat > 90 {
+		return location, fmt.Errorf("latitude must be a number between -90 and 90")
+	}
+	long, err := strconv.ParseFloat(longitude, 64)
+	if err != nil || long < -180 || long > 180 {
+		return location, fmt.Errorf("longitude must be a number between -180 and 180")
+	}
+	location.Latitude = lat
+	location.Longitude = long
+	return location, nil
+}
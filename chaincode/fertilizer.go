@@ -0,0 +1,301 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+const (
+	nutrientBalanceIndexName   = "nutrientbalance~kind~site~season"
+	cropFertilizerAppIndexName = "crop~appliedat~fertilizerapp"
+)
+
+// NutrientBudget is the seasonal nutrient allowance of a crop species in kg/ha,
+// part of the species catalog entry. A zero allowance leaves that nutrient
+// unbudgeted.
+// When the latest valid soil test of a crop, or of its field, already
+// measures SoilNitrogenMaxPercent any further nitrogen is flagged, high
+// nitrogen causes ammonia toxicity and disease. Enforced budgets refuse
+// applications that exceed them, others only flag them.
+type NutrientBudget struct {
+	NitrogenKgHa           float64 `json:"nitrogen_kg_ha"`
+	PhosphorusKgHa         float64 `json:"phosphorus_kg_ha"`
+	PotassiumKgHa          float64 `json:"potassium_kg_ha"`
+	SoilNitrogenMaxPercent float64 `json:"soil_nitrogen_max_percent"`
+	Enforce                bool    `json:"enforce"`
+}
+
+// NutrientBalance is the running total of nutrients applied on a field, or on
+// a crop that is not on a field, during a season, in kg/ha. SiteKind tells
+// which, so a crop and a field of the same name keep separate balances.
+type NutrientBalance struct {
+	SiteKind       string  `json:"site_kind"`
+	Site           string  `json:"site"`
+	Season         string  `json:"season"`
+	NitrogenKgHa   float64 `json:"nitrogen_kg_ha"`
+	PhosphorusKgHa float64 `json:"phosphorus_kg_ha"`
+	PotassiumKgHa  float64 `json:"potassium_kg_ha"`
+	Applications   int     `json:"applications"`
+}
+
+// FertilizerApplication records one fertilizer application on a crop. The
+// nutrient contents are percentages of the product mass, the rate is in kg of
+// product per hectare.
+type FertilizerApplication struct {
+	Crop              string   `json:"crop"`
+	SiteKind          string   `json:"site_kind"`
+	Site              string   `json:"site"`
+	Season            string   `json:"season"`
+	Product           string   `json:"product"`
+	NitrogenPercent   float64  `json:"nitrogen_percent"`
+	PhosphorusPercent float64  `json:"phosphorus_percent"`
+	PotassiumPercent  float64  `json:"potassium_percent"`
+	RateKgHa          float64  `json:"rate_kg_ha"`
+	NitrogenKgHa      float64  `json:"nitrogen_kg_ha"`
+	PhosphorusKgHa    float64  `json:"phosphorus_kg_ha"`
+	PotassiumKgHa     float64  `json:"potassium_kg_ha"`
+	AppliedAt         string   `json:"applied_at"`
+	Warnings          []string `json:"warnings,omitempty"`
+	TxID              string   `json:"tx_id"`
+}
+
+// ============================================================
+// nutrientBalanceOf - read the nutrients applied on a field or crop in a season
+// ============================================================
+func (t *SimpleChaincode) nutrientBalanceOf(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0                  1       2
+	// "field" | "crop", "name", "season"
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 3")
+	}
+	if args[0] != "field" && args[0] != "crop" {
+		return shim.Error("nutrient balances are kept for a field or a crop")
+	}
+
+	balance, err := getNutrientBalance(stub, args[0], args[1], args[2])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	balanceJSONasBytes, err := json.Marshal(balance)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(balanceJSONasBytes)
+}
+
+// ============================================================
+// fertilizerApplicationsOf - list the fertilizer applications of a crop in time order
+// ============================================================
+func (t *SimpleChaincode) fertilizerApplicationsOf(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting name of the crop to query")
+	}
+
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(cropFertilizerAppIndexName, []string{args[0]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	applications := []FertilizerApplication{}
+	for resultsIterator.HasNext() {
+		responseRange, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		var application FertilizerApplication
+		err = json.Unmarshal(responseRange.Value, &application)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		applications = append(applications, application)
+	}
+	applicationsJSON, err := json.Marshal(applications)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(applicationsJSON)
+}
+
+// parseFertilizerApplication validates the arguments of a fertilizer
+// application in the order product, nitrogen %, phosphorus %, potassium %,
+// rate kg/ha and the optional applied at and season.
+func parseFertilizerApplication(stub shim.ChaincodeStubInterface, info CropInfo, args []string) (FertilizerApplication, error) {
+	application := FertilizerApplication{
+		Crop:     info.Name,
+		SiteKind: "crop",
+		Site:     info.Name,
+		Product:  args[0],
+		TxID:     stub.GetTxID(),
+	}
+	if info.Field != "" {
+		application.SiteKind = "field"
+		application.Site = info.Field
+	}
+	if len(application.Product) == 0 {
+		return application, fmt.Errorf("product must be a non-empty string")
+	}
+
+	var values [4]float64
+	var err error
+	for i, arg := range args[1:5] {
+		values[i], err = strconv.ParseFloat(arg, 64)
+		if err != nil || values[i] < 0 {
+			return application, fmt.Errorf("nutrient contents and rate must be non-negative numbers: %q", arg)
+		}
+	}
+	if values[0]+values[1]+values[2] > 100 {
+		return application, fmt.Errorf("nutrient contents cannot exceed 100 percent")
+	}
+	application.NitrogenPercent = values[0]
+	application.PhosphorusPercent = values[1]
+	application.PotassiumPercent = values[2]
+	application.RateKgHa = values[3]
+	application.NitrogenKgHa = nutrientKgHa(values[3], values[0])
+	application.PhosphorusKgHa = nutrientKgHa(values[3], values[1])
+	application.PotassiumKgHa = nutrientKgHa(values[3], values[2])
+
+	now, err := txTimestamp(stub)
+	if err != nil {
+		return application, err
+	}
+	application.AppliedAt = now
+	if len(args) > 5 && args[5] != "" {
+		application.AppliedAt, err = parseReadingTime(args[5])
+		if err != nil {
+			return application, err
+		}
+		if application.AppliedAt > now {
+			return application, fmt.Errorf("applications cannot be recorded in advance")
+		}
+	}
+	// seasons default to the calendar year of the application
+	application.Season = application.AppliedAt[:4]
+	if len(args) > 6 && args[6] != "" {
+		application.Season = args[6]
+	}
+	return application, nil
+}
+
+// recordFertilizerApplication adds the application to the seasonal balance of
+// its site and checks the balance against the budget of the crop species.
+// Exceeding an enforced budget fails, everything else is flagged in the
+// warnings of the stored application.
+func recordFertilizerApplication(stub shim.ChaincodeStubInterface, info CropInfo, application *FertilizerApplication) error {
+	balance, err := getNutrientBalance(stub, application.SiteKind, application.Site, application.Season)
+	if err != nil {
+		return err
+	}
+	balance.NitrogenKgHa = roundDecimals(balance.NitrogenKgHa+application.NitrogenKgHa, 3)
+	balance.PhosphorusKgHa = roundDecimals(balance.PhosphorusKgHa+application.PhosphorusKgHa, 3)
+	balance.PotassiumKgHa = roundDecimals(balance.PotassiumKgHa+application.PotassiumKgHa, 3)
+	balance.Applications++
+
+	entry, found, err := lookupCatalogEntry(stub, info)
+	if err != nil {
+		return err
+	}
+	if budget := entry.NutrientBudget; found && budget != (NutrientBudget{}) {
+		var exceeded []string
+		for _, nutrient := range []struct {
+			name           string
+			applied, limit float64
+		}{
+			{"nitrogen", balance.NitrogenKgHa, budget.NitrogenKgHa},
+			{"phosphorus", balance.PhosphorusKgHa, budget.PhosphorusKgHa},
+			{"potassium", balance.PotassiumKgHa, budget.PotassiumKgHa},
+		} {
+			if nutrient.limit > 0 && nutrient.applied > nutrient.limit {
+				exceeded = append(exceeded, fmt.Sprintf("%s of %g kg/ha exceeds the %s budget of %g kg/ha for season %s",
+					nutrient.name, nutrient.applied, info.Species, nutrient.limit, balance.Season))
+			}
+		}
+		if budget.Enforce && len(exceeded) > 0 {
+			return fmt.Errorf("fertilizer application refused: %s", strings.Join(exceeded, "; "))
+		}
+		application.Warnings = append(application.Warnings, exceeded...)
+
+		if application.NitrogenKgHa > 0 && budget.SoilNitrogenMaxPercent > 0 {
+			// read the soil tests, not the crop conditions: every sensor reading
+			// rewrites the conditions and reading them would make fertilizing
+			// conflict with readings
+			test, err := getLatestValidSoilTest(stub, info)
+			if err != nil {
+				return err
+			}
+			if test != nil && test.Nutrients.Nitrogen.Percentage >= budget.SoilNitrogenMaxPercent {
+				application.Warnings = append(application.Warnings, fmt.Sprintf("soil nitrogen of %g%% in soil test %s already reaches the %g%% maximum for %s",
+					test.Nutrients.Nitrogen.Percentage, test.ID, budget.SoilNitrogenMaxPercent, info.Species))
+			}
+		}
+	}
+
+	balanceKey, err := stub.CreateCompositeKey(nutrientBalanceIndexName, []string{balance.SiteKind, balance.Site, balance.Season})
+	if err != nil {
+		return err
+	}
+	balanceJSONasBytes, err := json.Marshal(balance)
+	if err != nil {
+		return err
+	}
+	err = stub.PutState(balanceKey, balanceJSONasBytes)
+	if err != nil {
+		return err
+	}
+
+	applicationKey, err := stub.CreateCompositeKey(cropFertilizerAppIndexName, []string{application.Crop, application.AppliedAt, application.TxID})
+	if err != nil {
+		return err
+	}
+	applicationJSONasBytes, err := json.Marshal(application)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(applicationKey, applicationJSONasBytes)
+}
+
+// getNutrientBalance returns the nutrient balance of a site in a season, empty
+// when nothing was applied yet.
+func getNutrientBalance(stub shim.ChaincodeStubInterface, kind, site, season string) (NutrientBalance, error) {
+	balance := NutrientBalance{SiteKind: kind, Site: site, Season: season}
+	balanceKey, err := stub.CreateCompositeKey(nutrientBalanceIndexName, []string{kind, site, season})
+	if err != nil {
+		return balance, err
+	}
+	balanceAsBytes, err := stub.GetState(balanceKey)
+	if err != nil {
+		return balance, fmt.Errorf("Failed to get nutrient balance: %s", err.Error())
+	} else if balanceAsBytes == nil {
+		return balance, nil
+	}
+	err = json.Unmarshal(balanceAsBytes, &balance)
+	return balance, err
+}
+
+// nutrientKgHa is the nutrient applied in kg/ha by a product rate and content.
+func nutrientKgHa(rateKgHa, percent float64) float64 {
+	return roundDecimals(rateKgHa*percent/100, 3)
+}
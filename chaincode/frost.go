@@ -0,0 +1,271 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+const (
+	// the txid keeps alerts of two readings with the same sensor and
+	// timestamp from overwriting each other
+	frostAlertIndexName = "crop~timestamp~sensor~txid~frostalert"
+	// the latest reading of every sensor of a crop, the previous reading of
+	// the temperature trend
+	sensorLatestReadingIndexName = "crop~sensor~latestreading"
+	frostRiskEvent               = "FrostRisk"
+	// the temperature trend of a sensor is projected this far ahead
+	frostLookahead = 2 * time.Hour
+	// readings further apart than this give no usable trend
+	frostTrendWindow = 3 * time.Hour
+)
+
+const (
+	frostWarning  = "warning"
+	frostCritical = "critical"
+)
+
+// FrostThresholdType holds the temperatures in degrees Celcius at and below
+// which a growth stage is at risk. Buds and young shoots are more sensitive
+// than a dormant crop, so the catalog sets thresholds per stage. Stages without
+// thresholds are not evaluated.
+type FrostThresholdType struct {
+	WarningCelcius  float64 `json:"warning_celcius"`
+	CriticalCelcius float64 `json:"critical_celcius"`
+}
+
+// FrostAlert is the record of a reading that crossed the frost threshold of
+// the crop. The projected temperature extrapolates the trend of the sensor.
+type FrostAlert struct {
+	Crop             string             `json:"crop"`
+	Sensor           string             `json:"sensor"`
+	Timestamp        string             `json:"timestamp"`
+	GrowthStage      string             `json:"growth_stage"`
+	Celcius          float64            `json:"celcius"`
+	ProjectedCelcius float64            `json:"projected_celcius"`
+	Threshold        FrostThresholdType `json:"threshold"`
+	Level            string             `json:"level"`
+	TxID             string             `json:"tx_id"`
+}
+
+// ============================================================
+// frostAlertsOf - list the frost alerts of a crop inside a time window
+// ============================================================
+func (t *SimpleChaincode) frostAlertsOf(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0       1       2
+	// "crop", "from", "to"
+	// from and to may be empty to leave that side of the query open
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 3")
+	}
+
+	from, to, err := parseTimeWindow(args[1], args[2])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(frostAlertIndexName, []string{args[0]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	alerts := []FrostAlert{}
+	for resultsIterator.HasNext() {
+		responseRange, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		var alert FrostAlert
+		err = json.Unmarshal(responseRange.Value, &alert)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if from != "" && alert.Timestamp < from {
+			continue
+		}
+		if to != "" && alert.Timestamp > to {
+			break
+		}
+		alerts = append(alerts, alert)
+	}
+	alertsJSON, err := json.Marshal(alerts)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(alertsJSON)
+}
+
+// applyFrostRisk evaluates the frost risk of a new reading. A reading that
+// crosses the threshold of the crop species and growth stage is recorded as a
+// frost alert and emitted as a FrostRisk event. When the reading is the
+// latest of the crop its risk level becomes the frost risk of the crop, so it
+// must be called before applyLatestReading.
+func applyFrostRisk(stub shim.ChaincodeStubInterface, conditions *CropConditions, reading SensorReading) error {
+	alert, err := evaluateFrostRisk(stub, cropGrowthStage(*conditions), reading)
+	if err != nil {
+		return err
+	}
+	err = putSensorLatestReading(stub, reading)
+	if err != nil {
+		return err
+	}
+	if reading.Timestamp >= conditions.LatestReading.Timestamp {
+		conditions.FrostRisk = ""
+		if alert != nil {
+			conditions.FrostRisk = alert.Level
+		}
+	}
+	if alert == nil {
+		return nil
+	}
+
+	alertKey, err := stub.CreateCompositeKey(frostAlertIndexName, []string{alert.Crop, alert.Timestamp, alert.Sensor, alert.TxID})
+	if err != nil {
+		return err
+	}
+	alertJSONasBytes, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+	err = stub.PutState(alertKey, alertJSONasBytes)
+	if err != nil {
+		return err
+	}
+	return stub.SetEvent(frostRiskEvent, alertJSONasBytes)
+}
+
+// evaluateFrostRisk returns the frost alert a reading raises in a growth
+// stage, or nil. The level is critical at or below the critical temperature, and a warning at or
+// below the warning temperature or when the falling trend of the sensor is
+// projected to reach it within the lookahead.
+func evaluateFrostRisk(stub shim.ChaincodeStubInterface, stage string, reading SensorReading) (*FrostAlert, error) {
+	info, err := getCropInfo(stub, reading.Crop)
+	if err != nil {
+		return nil, err
+	}
+	entry, found, err := lookupCatalogEntry(stub, info)
+	if err != nil || !found {
+		return nil, err
+	}
+	threshold, ok := entry.Frost[stage]
+	if !ok {
+		return nil, nil
+	}
+
+	celcius := reading.Weather.Temperature.Celcius
+	projected, err := projectTemperature(stub, reading)
+	if err != nil {
+		return nil, err
+	}
+	level := ""
+	if celcius <= threshold.CriticalCelcius {
+		level = frostCritical
+	} else if celcius <= threshold.WarningCelcius || projected <= threshold.WarningCelcius {
+		level = frostWarning
+	}
+	if level == "" {
+		return nil, nil
+	}
+
+	return &FrostAlert{
+		Crop:             reading.Crop,
+		Sensor:           reading.Sensor,
+		Timestamp:        reading.Timestamp,
+		GrowthStage:      stage,
+		Celcius:          celcius,
+		ProjectedCelcius: projected,
+		Threshold:        threshold,
+		Level:            level,
+		TxID:             stub.GetTxID(),
+	}, nil
+}
+
+// projectTemperature extrapolates a falling temperature from the previous
+// reading of the same sensor over the frost lookahead. Without a recent
+// previous reading, or when the temperature is not falling, it returns the
+// temperature of the reading. The previous reading is the latest reading of
+// the sensor, read by its key rather than from the reading history, so a
+// reading arriving after a later one of its sensor has no trend.
+func projectTemperature(stub shim.ChaincodeStubInterface, reading SensorReading) (float64, error) {
+	celcius := reading.Weather.Temperature.Celcius
+	at, err := time.Parse(readingTimeLayout, reading.Timestamp)
+	if err != nil {
+		return celcius, err
+	}
+	previous, err := getSensorLatestReading(stub, reading.Crop, reading.Sensor)
+	if err != nil || previous == nil || previous.Timestamp >= reading.Timestamp {
+		return celcius, err
+	}
+	before, err := time.Parse(readingTimeLayout, previous.Timestamp)
+	if err != nil || at.Sub(before) > frostTrendWindow {
+		return celcius, err
+	}
+
+	hours := at.Sub(before).Hours()
+	slope := (celcius - previous.Weather.Temperature.Celcius) / hours
+	if slope >= 0 {
+		return celcius, nil
+	}
+	return roundDecimals(celcius+slope*frostLookahead.Hours(), 2), nil
+}
+
+// getSensorLatestReading returns the latest reading of a sensor on a crop, or
+// nil when the sensor has none.
+func getSensorLatestReading(stub shim.ChaincodeStubInterface, cropName, sensor string) (*SensorReading, error) {
+	latestKey, err := stub.CreateCompositeKey(sensorLatestReadingIndexName, []string{cropName, sensor})
+	if err != nil {
+		return nil, err
+	}
+	latestAsBytes, err := stub.GetState(latestKey)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get latest reading: %s", err.Error())
+	} else if latestAsBytes == nil {
+		return nil, nil
+	}
+	var latest SensorReading
+	err = json.Unmarshal(latestAsBytes, &latest)
+	return &latest, err
+}
+
+// putSensorLatestReading keeps the reading as the latest of its sensor unless
+// the sensor already has a later one.
+func putSensorLatestReading(stub shim.ChaincodeStubInterface, reading SensorReading) error {
+	latest, err := getSensorLatestReading(stub, reading.Crop, reading.Sensor)
+	if err != nil {
+		return err
+	}
+	if latest != nil && latest.Timestamp > reading.Timestamp {
+		return nil
+	}
+	latestKey, err := stub.CreateCompositeKey(sensorLatestReadingIndexName, []string{reading.Crop, reading.Sensor})
+	if err != nil {
+		return err
+	}
+	readingJSONasBytes, err := json.Marshal(reading)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(latestKey, readingJSONasBytes)
+}
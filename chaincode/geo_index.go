@@ -0,0 +1,363 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package chaincode
+
+import (
+	"encoding/json"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// geohashIndexName indexes crops by the geohash of their location. Every
+// geohash character is its own key attribute followed by the crop name, so a
+// partial composite key query on the first n characters returns every crop in
+// that geohash cell. This works the same on LevelDB and CouchDB.
+const geohashIndexName = "geohash~crop"
+
+// geohashPrecision is the number of geohash characters indexed, about 38m x 19m.
+const geohashPrecision = 8
+
+// maxGeohashCells bounds the number of cells a bounding box query scans.
+const maxGeohashCells = 32
+
+const geohashAlphabet = "0123456789bcdefghjkmnpqrstuvwxyz"
+
+// GeoMatch is a crop returned by a geospatial query.
+type GeoMatch struct {
+	Crop        string          `json:"crop"`
+	GeoLocation GeoLocationType `json:"geo_location"`
+	Harvesting  bool            `json:"harvesting"`
+	DistanceKm  float64         `json:"distance_km,omitempty"`
+}
+
+// ============================================================
+// cropsInBoundingBox - find the crops located inside a bounding box
+// ============================================================
+func (t *SimpleChaincode) cropsInBoundingBox(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0              1              2              3              4
+	// "min latitude", "min longitude", "max latitude", "max longitude", "unharvested only"
+	if len(args) != 5 {
+		return shim.Error("Incorrect number of arguments. Expecting 5")
+	}
+
+	southWest, err := parseGeoLocation(args[0], args[1])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	northEast, err := parseGeoLocation(args[2], args[3])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if southWest.Latitude > northEast.Latitude || southWest.Longitude > northEast.Longitude {
+		return shim.Error("bounding box corners must be south-west then north-east")
+	}
+	unharvestedOnly, err := strconv.ParseBool(args[4])
+	if err != nil {
+		return shim.Error("Unable to parse boolean")
+	}
+
+	matches, err := getCropsInBoundingBox(stub, southWest, northEast, unharvestedOnly)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	sort.Slice(matches, func(i, j int) bool { return matches[i].Crop < matches[j].Crop })
+
+	matchesJSON, err := json.Marshal(matches)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(matchesJSON)
+}
+
+// ============================================================
+// cropsNearPoint - find the crops within a radius of a point
+// ============================================================
+func (t *SimpleChaincode) cropsNearPoint(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0           1            2             3
+	// "latitude", "longitude", "radius (km)", "unharvested only"
+	if len(args) != 4 {
+		return shim.Error("Incorrect number of arguments. Expecting 4")
+	}
+
+	center, err := parseGeoLocation(args[0], args[1])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	radiusKm, err := strconv.ParseFloat(args[2], 64)
+	if err != nil || radiusKm <= 0 {
+		return shim.Error("radius must be a positive number of kilometres")
+	}
+	unharvestedOnly, err := strconv.ParseBool(args[3])
+	if err != nil {
+		return shim.Error("Unable to parse boolean")
+	}
+
+	// search the bounding box around the circle, then keep what is inside it
+	latitudeDelta := radiusKm * 1000 / earthRadius * 180 / math.Pi
+	longitudeDelta := 180.0
+	if cosLatitude := math.Cos(toRadians(center.Latitude)); cosLatitude > 1e-9 {
+		longitudeDelta = math.Min(180, latitudeDelta/cosLatitude)
+	}
+	southWest := GeoLocationType{
+		Latitude:  math.Max(-90, center.Latitude-latitudeDelta),
+		Longitude: math.Max(-180, center.Longitude-longitudeDelta),
+	}
+	northEast := GeoLocationType{
+		Latitude:  math.Min(90, center.Latitude+latitudeDelta),
+		Longitude: math.Min(180, center.Longitude+longitudeDelta),
+	}
+
+	candidates, err := getCropsInBoundingBox(stub, southWest, northEast, unharvestedOnly)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	matches := []GeoMatch{}
+	for _, match := range candidates {
+		match.DistanceKm = haversineKm(center, match.GeoLocation)
+		if match.DistanceKm <= radiusKm {
+			matches = append(matches, match)
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].DistanceKm != matches[j].DistanceKm {
+			return matches[i].DistanceKm < matches[j].DistanceKm
+		}
+		return matches[i].Crop < matches[j].Crop
+	})
+
+	matchesJSON, err := json.Marshal(matches)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(matchesJSON)
+}
+
+// ============================================================
+// indexCropLocation - (re)build the geohash index entry of an existing crop
+// ============================================================
+func (t *SimpleChaincode) indexCropLocation(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	var info CropInfo
+	cropAsBytes, err := getCropBase(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = json.Unmarshal(cropAsBytes, &info)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	location, err := cropLocation(stub, info)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = putGeohashIndex(stub, info.Name, location)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(nil)
+}
+
+// getCropsInBoundingBox scans the geohash cells covering the box and returns
+// the crops whose location lies inside it.
+func getCropsInBoundingBox(stub shim.ChaincodeStubInterface, southWest, northEast GeoLocationType, unharvestedOnly bool) ([]GeoMatch, error) {
+	matches := []GeoMatch{}
+	for _, cell := range geohashCover(southWest, northEast) {
+		resultsIterator, err := stub.GetStateByPartialCompositeKey(geohashIndexName, strings.Split(cell, ""))
+		if err != nil {
+			return nil, err
+		}
+
+		for resultsIterator.HasNext() {
+			responseRange, err := resultsIterator.Next()
+			if err != nil {
+				resultsIterator.Close()
+				return nil, err
+			}
+			var match GeoMatch
+			err = json.Unmarshal(responseRange.Value, &match)
+			if err != nil {
+				resultsIterator.Close()
+				return nil, err
+			}
+			location := match.GeoLocation
+			if location.Latitude < southWest.Latitude || location.Latitude > northEast.Latitude ||
+				location.Longitude < southWest.Longitude || location.Longitude > northEast.Longitude {
+				continue
+			}
+
+			var status CropStatus
+			err = getCropAspect(stub, match.Crop, statusPart, &status)
+			if err != nil {
+				resultsIterator.Close()
+				return nil, err
+			}
+			if unharvestedOnly && status.Harvesting {
+				continue
+			}
+			match.Harvesting = status.Harvesting
+			matches = append(matches, match)
+		}
+		resultsIterator.Close()
+	}
+	return matches, nil
+}
+
+// putGeohashIndex writes the geohash index entry of a crop. The value holds the
+// exact location so queries can filter without reading the crop.
+func putGeohashIndex(stub shim.ChaincodeStubInterface, cropName string, location GeoLocationType) error {
+	indexKey, err := geohashIndexKey(stub, cropName, location)
+	if err != nil {
+		return err
+	}
+	valueJSONasBytes, err := json.Marshal(GeoMatch{Crop: cropName, GeoLocation: location})
+	if err != nil {
+		return err
+	}
+	return stub.PutState(indexKey, valueJSONasBytes)
+}
+
+// delGeohashIndex removes the geohash index entry of a crop.
+func delGeohashIndex(stub shim.ChaincodeStubInterface, cropName string, location GeoLocationType) error {
+	indexKey, err := geohashIndexKey(stub, cropName, location)
+	if err != nil {
+		return err
+	}
+	return stub.DelState(indexKey)
+}
+
+func geohashIndexKey(stub shim.ChaincodeStubInterface, cropName string, location GeoLocationType) (string, error) {
+	hash := geohashEncode(location.Latitude, location.Longitude, geohashPrecision)
+	return stub.CreateCompositeKey(geohashIndexName, append(strings.Split(hash, ""), cropName))
+}
+
+// cropLocation returns the location of a crop, taken from its field when it has one.
+func cropLocation(stub shim.ChaincodeStubInterface, info CropInfo) (GeoLocationType, error) {
+	if info.Field == "" {
+		return info.FarmInfo.GeoLocation, nil
+	}
+	field, err := getField(stub, info.Field)
+	if err != nil {
+		return GeoLocationType{}, err
+	}
+	return field.GeoLocation, nil
+}
+
+// geohashCover returns the geohash cells covering the bounding box, using the
+// finest precision that needs no more than maxGeohashCells cells.
+func geohashCover(southWest, northEast GeoLocationType) []string {
+	cells := []string{""}
+	for precision := 1; precision <= geohashPrecision; precision++ {
+		cellHeight, cellWidth := geohashCellSize(precision)
+		rows := math.Floor(northEast.Latitude/cellHeight) - math.Floor(southWest.Latitude/cellHeight) + 1
+		columns := math.Floor(northEast.Longitude/cellWidth) - math.Floor(southWest.Longitude/cellWidth) + 1
+		if rows*columns > maxGeohashCells {
+			break
+		}
+
+		seen := map[string]bool{}
+		cells = []string{}
+		for row := 0.0; row < rows; row++ {
+			latitude := math.Min(northEast.Latitude, southWest.Latitude+row*cellHeight)
+			for column := 0.0; column < columns; column++ {
+				longitude := math.Min(northEast.Longitude, southWest.Longitude+column*cellWidth)
+				cell := geohashEncode(latitude, longitude, precision)
+				if !seen[cell] {
+					seen[cell] = true
+					cells = append(cells, cell)
+				}
+			}
+		}
+		// the stepping above can miss the cells of the north and east edges
+		for _, corner := range [][2]float64{
+			{northEast.Latitude, southWest.Longitude},
+			{southWest.Latitude, northEast.Longitude},
+			{northEast.Latitude, northEast.Longitude},
+		} {
+			cell := geohashEncode(corner[0], corner[1], precision)
+			if !seen[cell] {
+				seen[cell] = true
+				cells = append(cells, cell)
+			}
+		}
+	}
+	sort.Strings(cells)
+	return cells
+}
+
+// geohashCellSize returns the height and width in degrees of a geohash cell.
+func geohashCellSize(precision int) (float64, float64) {
+	bits := uint(precision * 5)
+	latitudeBits := bits / 2
+	longitudeBits := bits - latitudeBits
+	return 180 / math.Exp2(float64(latitudeBits)), 360 / math.Exp2(float64(longitudeBits))
+}
+
+// geohashEncode returns the geohash of a location with the given precision.
+func geohashEncode(latitude, longitude float64, precision int) string {
+	latitudeRange := [2]float64{-90, 90}
+	longitudeRange := [2]float64{-180, 180}
+
+	var hash strings.Builder
+	bit, ch, even := 0, 0, true
+	for hash.Len() < precision {
+		if even {
+			middle := (longitudeRange[0] + longitudeRange[1]) / 2
+			if longitude >= middle {
+				ch |= 1 << uint(4-bit)
+				longitudeRange[0] = middle
+			} else {
+				longitudeRange[1] = middle
+			}
+		} else {
+			middle := (latitudeRange[0] + latitudeRange[1]) / 2
+			if latitude >= middle {
+				ch |= 1 << uint(4-bit)
+				latitudeRange[0] = middle
+			} else {
+				latitudeRange[1] = middle
+			}
+		}
+		even = !even
+		if bit < 4 {
+			bit++
+		} else {
+			hash.WriteByte(geohashAlphabet[ch])
+			bit, ch = 0, 0
+		}
+	}
+	return hash.String()
+}
+
+// haversineKm returns the great circle distance between two locations in kilometres.
+func haversineKm(a, b GeoLocationType) float64 {
+	dLatitude := toRadians(b.Latitude - a.Latitude)
+	dLongitude := toRadians(b.Longitude - a.Longitude)
+	h := math.Sin(dLatitude/2)*math.Sin(dLatitude/2) +
+		math.Cos(toRadians(a.Latitude))*math.Cos(toRadians(b.Latitude))*math.Sin(dLongitude/2)*math.Sin(dLongitude/2)
+	return 2 * earthRadius / 1000 * math.Asin(math.Min(1, math.Sqrt(h)))
+}
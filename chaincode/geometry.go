@@ -0,0 +1,216 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package chaincode
+
+import (
+	"fmt"
+	"math"
+)
+
+// earthRadius is the WGS84 equatorial radius in metres, as used by GeoJSON tooling.
+const earthRadius = 6378137.0
+
+// GeoJSONPolygon is a GeoJSON Polygon geometry. The first ring is the outer
+// boundary, any further rings are holes. Positions are [longitude, latitude].
+type GeoJSONPolygon struct {
+	Type        string        `json:"type"`
+	Coordinates [][][]float64 `json:"coordinates"`
+}
+
+// validatePolygon checks that the polygon is a well formed GeoJSON Polygon:
+// every ring is closed, has at least three distinct positions, does not
+// intersect itself or another ring, and every hole lies inside the boundary.
+func validatePolygon(polygon GeoJSONPolygon) error {
+	if polygon.Type != "Polygon" {
+		return fmt.Errorf("boundary must be a GeoJSON Polygon")
+	}
+	if len(polygon.Coordinates) == 0 {
+		return fmt.Errorf("boundary must have an outer ring")
+	}
+
+	for r, ring := range polygon.Coordinates {
+		if len(ring) < 4 {
+			return fmt.Errorf("ring %d must have at least four positions", r)
+		}
+		for _, position := range ring {
+			if len(position) < 2 {
+				return fmt.Errorf("ring %d has a position without longitude and latitude", r)
+			}
+			if position[0] < -180 || position[0] > 180 || position[1] < -90 || position[1] > 90 {
+				return fmt.Errorf("ring %d has a position out of range", r)
+			}
+		}
+		first, last := ring[0], ring[len(ring)-1]
+		if first[0] != last[0] || first[1] != last[1] {
+			return fmt.Errorf("ring %d is not closed", r)
+		}
+		distinct := map[[2]float64]bool{}
+		for _, position := range ring {
+			distinct[[2]float64{position[0], position[1]}] = true
+		}
+		if len(distinct) < 3 {
+			return fmt.Errorf("ring %d must have at least three distinct positions", r)
+		}
+		if ringSelfIntersects(ring) {
+			return fmt.Errorf("ring %d intersects itself", r)
+		}
+	}
+
+	for r := 0; r < len(polygon.Coordinates); r++ {
+		for o := r + 1; o < len(polygon.Coordinates); o++ {
+			if ringsIntersect(polygon.Coordinates[r], polygon.Coordinates[o]) {
+				return fmt.Errorf("ring %d intersects ring %d", r, o)
+			}
+		}
+	}
+	for r, hole := range polygon.Coordinates[1:] {
+		if !ringContains(polygon.Coordinates[0], hole[0][0], hole[0][1]) {
+			return fmt.Errorf("hole %d lies outside the boundary", r+1)
+		}
+	}
+	return nil
+}
+
+// polygonAreaHectares returns the area of the polygon on the sphere in
+// hectares, rounded to four decimals. Holes are subtracted from the outer ring.
+func polygonAreaHectares(polygon GeoJSONPolygon) float64 {
+	area := 0.0
+	for r, ring := range polygon.Coordinates {
+		if r == 0 {
+			area += ringArea(ring)
+		} else {
+			area -= ringArea(ring)
+		}
+	}
+	return roundDecimals(area/10000, 4)
+}
+
+// roundDecimals rounds a computed value to the fixed number of decimals the
+// chaincode stores and reports it with.
+func roundDecimals(value float64, decimals int) float64 {
+	scale := math.Pow(10, float64(decimals))
+	return math.Round(value*scale) / scale
+}
+
+// polygonContains reports whether the point lies inside the polygon boundary
+// and outside all of its holes.
+func polygonContains(polygon GeoJSONPolygon, longitude, latitude float64) bool {
+	if len(polygon.Coordinates) == 0 || !ringContains(polygon.Coordinates[0], longitude, latitude) {
+		return false
+	}
+	for _, hole := range polygon.Coordinates[1:] {
+		if ringContains(hole, longitude, latitude) {
+			return false
+		}
+	}
+	return true
+}
+
+// ringArea returns the absolute area of a closed ring in square metres using
+// the spherical excess approximation of Chamberlain and Duquette, the same
+// method as the GeoJSON reference implementations.
+func ringArea(ring [][]float64) float64 {
+	total := 0.0
+	for i := 0; i < len(ring)-1; i++ {
+		p1, p2 := ring[i], ring[i+1]
+		total += toRadians(p2[0]-p1[0]) * (2 + math.Sin(toRadians(p1[1])) + math.Sin(toRadians(p2[1])))
+	}
+	return math.Abs(total * earthRadius * earthRadius / 2)
+}
+
+// ringContains is an even-odd ray casting test in the longitude/latitude plane.
+func ringContains(ring [][]float64, x, y float64) bool {
+	inside := false
+	for i, j := 0, len(ring)-2; i < len(ring)-1; j, i = i, i+1 {
+		xi, yi := ring[i][0], ring[i][1]
+		xj, yj := ring[j][0], ring[j][1]
+		if (yi > y) != (yj > y) && x < (xj-xi)*(y-yi)/(yj-yi)+xi {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+// ringSelfIntersects reports whether two non-adjacent edges of a ring touch.
+func ringSelfIntersects(ring [][]float64) bool {
+	edges := len(ring) - 1
+	for i := 0; i < edges; i++ {
+		for j := i + 1; j < edges; j++ {
+			// adjacent edges share an endpoint, including the closing edge
+			if j == i+1 || (i == 0 && j == edges-1) {
+				continue
+			}
+			if segmentsIntersect(ring[i], ring[i+1], ring[j], ring[j+1]) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ringsIntersect reports whether any edge of a touches any edge of b.
+func ringsIntersect(a, b [][]float64) bool {
+	for i := 0; i < len(a)-1; i++ {
+		for j := 0; j < len(b)-1; j++ {
+			if segmentsIntersect(a[i], a[i+1], b[j], b[j+1]) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// segmentsIntersect reports whether segment p1-p2 touches segment q1-q2.
+func segmentsIntersect(p1, p2, q1, q2 []float64) bool {
+	d1 := orientation(q1, q2, p1)
+	d2 := orientation(q1, q2, p2)
+	d3 := orientation(p1, p2, q1)
+	d4 := orientation(p1, p2, q2)
+
+	if d1 != d2 && d3 != d4 {
+		return true
+	}
+	// collinear cases, the segments overlap when an endpoint lies on the other segment
+	return (d1 == 0 && onSegment(q1, q2, p1)) ||
+		(d2 == 0 && onSegment(q1, q2, p2)) ||
+		(d3 == 0 && onSegment(p1, p2, q1)) ||
+		(d4 == 0 && onSegment(p1, p2, q2))
+}
+
+// orientation returns 1 for a counter-clockwise turn a-b-c, -1 for a clockwise
+// turn and 0 when the points are collinear.
+func orientation(a, b, c []float64) int {
+	cross := (b[0]-a[0])*(c[1]-a[1]) - (b[1]-a[1])*(c[0]-a[0])
+	switch {
+	case cross > 0:
+		return 1
+	case cross < 0:
+		return -1
+	}
+	return 0
+}
+
+// onSegment reports whether c, collinear with a-b, lies within the bounding box of a-b.
+func onSegment(a, b, c []float64) bool {
+	return math.Min(a[0], b[0]) <= c[0] && c[0] <= math.Max(a[0], b[0]) &&
+		math.Min(a[1], b[1]) <= c[1] && c[1] <= math.Max(a[1], b[1])
+}
+
+func toRadians(degrees float64) float64 {
+	return degrees * math.Pi / 180
+}
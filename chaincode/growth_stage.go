@@ -0,0 +1,92 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package chaincode
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// Growth stages follow the four crop development stages of FAO-56. Crops
+// recorded before growth stages were tracked are in the initial stage.
+const (
+	stageInitial     = "initial"
+	stageDevelopment = "development"
+	stageMid         = "mid"
+	stageLate        = "late"
+)
+
+var growthStages = []string{stageInitial, stageDevelopment, stageMid, stageLate}
+
+// ============================================================
+// setGrowthStage - move a crop to another growth stage
+// ============================================================
+func (t *SimpleChaincode) setGrowthStage(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0       1
+	// "crop", "initial" | "development" | "mid" | "late"
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+
+	cropName := args[0]
+	stage, err := parseGrowthStage(args[1])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	fmt.Println("- start growth stage update", cropName, stage)
+
+	var conditions CropConditions
+	err = getCropAspect(stub, cropName, conditionsPart, &conditions)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	conditions.GrowthStage = stage
+
+	// the ideal ranges of the health score change with the stage, the
+	// conditions are rescored and rewritten like after a reading
+	err = putCropConditions(stub, cropName, &conditions)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	fmt.Println("- end growth stage update (successful)")
+	return shim.Success(nil)
+}
+
+// parseGrowthStage validates a growth stage name.
+func parseGrowthStage(value string) (string, error) {
+	stage := strings.ToLower(value)
+	for _, known := range growthStages {
+		if stage == known {
+			return stage, nil
+		}
+	}
+	return "", fmt.Errorf("growth stage must be one of %s", strings.Join(growthStages, ", "))
+}
+
+// cropGrowthStage returns the growth stage of the conditions of a crop.
+func cropGrowthStage(conditions CropConditions) string {
+	if conditions.GrowthStage == "" {
+		return stageInitial
+	}
+	return conditions.GrowthStage
+}
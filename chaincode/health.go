@@ -0,0 +1,340 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// healthScoreIndexName orders crops by health score. The score is zero padded
+// to three digits so the lexical key order is the numeric order.
+const healthScoreIndexName = "healthscore~score~crop"
+
+// Health score components and their weights. Condition components are scored
+// against the catalog ideal range of the growth stage of the crop and are left
+// out when the range is not set, the remaining weights are renormalised.
+const (
+	healthTemperature  = "temperature"
+	healthHumidity     = "humidity"
+	healthSoilPh       = "soil_ph"
+	healthSoilMoisture = "soil_moisture"
+	healthSoilNitrogen = "soil_nitrogen"
+	healthPathology    = "pathology"
+	healthPests        = "pests"
+)
+
+var healthWeights = map[string]float64{
+	healthTemperature:  15,
+	healthHumidity:     10,
+	healthSoilPh:       10,
+	healthSoilMoisture: 15,
+	healthSoilNitrogen: 10,
+	healthPathology:    25,
+	healthPests:        15,
+}
+
+// HealthComponentType is the score of one component of the crop health. Value
+// is the measured value, Ideal the catalog range it was scored against.
+type HealthComponentType struct {
+	Score  float64    `json:"score"`
+	Weight float64    `json:"weight"`
+	Value  float64    `json:"value"`
+	Ideal  *RangeType `json:"ideal,omitempty"`
+}
+
+// HealthScoreType is the health score of a crop from 0, failing, to 100, with
+// the components it was computed from.
+type HealthScoreType struct {
+	Score      int                            `json:"score"`
+	Stage      string                         `json:"stage"`
+	Components map[string]HealthComponentType `json:"components"`
+	ComputedAt string                         `json:"computed_at"`
+}
+
+// CropHealthScore is one entry of the crops ordered by health score.
+type CropHealthScore struct {
+	Crop  string `json:"crop"`
+	Score int    `json:"score"`
+}
+
+// ============================================================
+// healthScoreOf - read the health score of a crop and its breakdown
+// ============================================================
+func (t *SimpleChaincode) healthScoreOf(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting name of the crop to query")
+	}
+
+	var conditions CropConditions
+	err := getCropAspect(stub, args[0], conditionsPart, &conditions)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if conditions.Health.ComputedAt == "" {
+		return shim.Error("health score of crop " + args[0] + " was never computed")
+	}
+	healthJSON, err := json.Marshal(conditions.Health)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(healthJSON)
+}
+
+// ============================================================
+// refreshHealthScore - compute the health score of a crop again
+// ============================================================
+func (t *SimpleChaincode) refreshHealthScore(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	// Scores follow every write to the crop conditions. Crops created before
+	// the score, or whose catalog entry changed since, are refreshed with this.
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	var conditions CropConditions
+	err := getCropAspect(stub, args[0], conditionsPart, &conditions)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = putCropConditions(stub, args[0], &conditions)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	healthJSON, err := json.Marshal(conditions.Health)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(healthJSON)
+}
+
+// ============================================================
+// cropsByHealthScore - list the crops ordered by health score
+// ============================================================
+func (t *SimpleChaincode) cropsByHealthScore(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0              1              2
+	// "min score", "max score", "asc" | "desc"
+	// the scores may be empty to leave that side open, the order defaults to asc
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 3")
+	}
+
+	min, max := 0, 100
+	var err error
+	if args[0] != "" {
+		if min, err = strconv.Atoi(args[0]); err != nil {
+			return shim.Error("min score must be an integer")
+		}
+	}
+	if args[1] != "" {
+		if max, err = strconv.Atoi(args[1]); err != nil {
+			return shim.Error("max score must be an integer")
+		}
+	}
+	descending := false
+	switch args[2] {
+	case "", "asc":
+	case "desc":
+		descending = true
+	default:
+		return shim.Error("order must be asc or desc")
+	}
+
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(healthScoreIndexName, []string{})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	scores := []CropHealthScore{}
+	for resultsIterator.HasNext() {
+		responseRange, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		_, keyParts, err := stub.SplitCompositeKey(responseRange.Key)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		score, err := strconv.Atoi(keyParts[0])
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if score < min {
+			continue
+		}
+		if score > max {
+			break
+		}
+		scores = append(scores, CropHealthScore{Crop: keyParts[1], Score: score})
+	}
+	if descending {
+		sort.SliceStable(scores, func(i, j int) bool {
+			return scores[i].Score > scores[j].Score
+		})
+	}
+
+	scoresJSON, err := json.Marshal(scores)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(scoresJSON)
+}
+
+// putCropConditions scores the health of a crop and writes its conditions
+// part. Every write of the conditions goes through here so the score and its
+// index never lag behind. The growth stage the score depends on is part of
+// the conditions, so this never reads the status of the crop.
+func putCropConditions(stub shim.ChaincodeStubInterface, cropName string, conditions *CropConditions) error {
+	info, err := getCropInfo(stub, cropName)
+	if err != nil {
+		return err
+	}
+	err = applyHealthScore(stub, info, cropGrowthStage(*conditions), conditions)
+	if err != nil {
+		return err
+	}
+	return putCropPart(stub, cropName, conditionsPart, conditions)
+}
+
+// applyHealthScore computes the health score of the conditions of a crop in a
+// growth stage and moves its entry in the health score index.
+func applyHealthScore(stub shim.ChaincodeStubInterface, info CropInfo, stage string, conditions *CropConditions) error {
+	timestamp, err := txTimestamp(stub)
+	if err != nil {
+		return err
+	}
+	entry, found, err := lookupCatalogEntry(stub, info)
+	if err != nil {
+		return err
+	}
+	var ideal *IdealRangesType
+	if ranges, ok := entry.IdealRanges[stage]; found && ok {
+		ideal = &ranges
+	}
+
+	health := scoreCropHealth(ideal, *conditions)
+	health.Stage = stage
+	health.ComputedAt = timestamp
+
+	if conditions.Health.ComputedAt != "" && conditions.Health.Score != health.Score {
+		err = delHealthScoreIndex(stub, info.Name, conditions.Health)
+		if err != nil {
+			return err
+		}
+	}
+	indexKey, err := stub.CreateCompositeKey(healthScoreIndexName, []string{fmt.Sprintf("%03d", health.Score), info.Name})
+	if err != nil {
+		return err
+	}
+	err = stub.PutState(indexKey, []byte{0x00})
+	if err != nil {
+		return err
+	}
+	conditions.Health = health
+	return nil
+}
+
+// delHealthScoreIndex removes the health score index entry of a crop.
+func delHealthScoreIndex(stub shim.ChaincodeStubInterface, cropName string, health HealthScoreType) error {
+	if health.ComputedAt == "" {
+		return nil
+	}
+	indexKey, err := stub.CreateCompositeKey(healthScoreIndexName, []string{fmt.Sprintf("%03d", health.Score), cropName})
+	if err != nil {
+		return err
+	}
+	return stub.DelState(indexKey)
+}
+
+// scoreCropHealth combines the distance of each condition from its ideal
+// range, the pathology level and the worst pest infestation into a score.
+// ideal is nil when the catalog has no ranges for the stage of the crop.
+func scoreCropHealth(ideal *IdealRangesType, conditions CropConditions) HealthScoreType {
+	components := map[string]HealthComponentType{}
+	if ideal != nil {
+		celcius := conditions.Weather.Temperature.Celcius
+		relativeHumidity := math.Min(100, 100*actualVapourPressure(conditions.Weather.Humidity.CubicMeter, celcius)/saturationVapourPressure(celcius))
+		addRangeComponent(components, healthTemperature, celcius, ideal.Celcius)
+		addRangeComponent(components, healthHumidity, relativeHumidity, ideal.RelativeHumidity)
+		addRangeComponent(components, healthSoilPh, float64(conditions.SoilCondition.Ph), ideal.SoilPh)
+		addRangeComponent(components, healthSoilMoisture, conditions.SoilCondition.Moisture.CubicMeter, ideal.SoilMoisturePercent)
+		addRangeComponent(components, healthSoilNitrogen, conditions.SoilCondition.Nitrogen.Percentage, ideal.SoilNitrogenPercent)
+	}
+
+	// NTTU level 1 shows no symptoms, every level above it costs a quarter
+	level := float64(conditions.Pathology.Level)
+	components[healthPathology] = HealthComponentType{
+		Score:  roundDecimals(100*math.Min(1, (maxPathologyLevel-level)/(maxPathologyLevel-minPathologyLevel)), 2),
+		Weight: healthWeights[healthPathology],
+		Value:  level,
+	}
+	infested := 0.0
+	for _, pest := range conditions.Pests {
+		infested = math.Max(infested, pest.InfestedPercent)
+	}
+	components[healthPests] = HealthComponentType{
+		Score:  roundDecimals(100-infested, 2),
+		Weight: healthWeights[healthPests],
+		Value:  infested,
+	}
+
+	// sum in a fixed order so every peer computes the same score
+	names := make([]string, 0, len(components))
+	for name := range components {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var total, weights float64
+	for _, name := range names {
+		total += components[name].Score * components[name].Weight
+		weights += components[name].Weight
+	}
+	return HealthScoreType{
+		Score:      int(math.Round(total / weights)),
+		Components: components,
+	}
+}
+
+// addRangeComponent scores a value against an ideal range. Inside the range
+// scores 100, outside it the score falls linearly to 0 at one range width
+// from the nearest bound. A range of a single value uses a width of one.
+func addRangeComponent(components map[string]HealthComponentType, name string, value float64, ideal RangeType) {
+	if ideal.Min == 0 && ideal.Max == 0 {
+		return
+	}
+	width := ideal.Max - ideal.Min
+	if width == 0 {
+		width = 1
+	}
+	distance := math.Max(0, math.Max(ideal.Min-value, value-ideal.Max))
+	r := ideal
+	components[name] = HealthComponentType{
+		Score:  roundDecimals(100*math.Max(0, 1-distance/width), 2),
+		Weight: healthWeights[name],
+		Value:  roundDecimals(value, 2),
+		Ideal:  &r,
+	}
+}
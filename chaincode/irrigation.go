@@ -0,0 +1,502 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+const (
+	irrigationAdviceIndexName = "crop~date~irrigationadvice"
+	cropIrrigationIndexName   = "crop~irrigatedat~irrigation"
+)
+
+const (
+	// FAO-56 recommends 2 m/s when no wind speed is measured
+	defaultWindSpeed = 2.0
+	// standard atmosphere, used when no plausible pressure was read
+	standardPressureKPa = 101.3
+	// irrigation within this fraction of the advice meets it
+	irrigationTolerance = 0.2
+)
+
+const (
+	advicePending = "pending"
+	adviceMet     = "met"
+	adviceUnder   = "under"
+	adviceOver    = "over"
+)
+
+// CropCoefficients are the FAO-56 crop coefficients of a catalog species together
+// with the root zone depth in metres and the field capacity of the root zone
+// as volumetric water content in percent.
+type CropCoefficients struct {
+	KcInitial            float64 `json:"kc_initial"`
+	KcMid                float64 `json:"kc_mid"`
+	KcEnd                float64 `json:"kc_end"`
+	RootDepthM           float64 `json:"root_depth_m"`
+	FieldCapacityPercent float64 `json:"field_capacity_percent"`
+}
+
+// IrrigationAdvice is the recommended irrigation of a crop for one day and its
+// reconciliation with the irrigation actually applied that day. ET0 and ETc
+// are in mm/day, amounts in mm.
+type IrrigationAdvice struct {
+	Crop          string  `json:"crop"`
+	Date          string  `json:"date"`
+	Readings      int     `json:"readings"`
+	ET0           float64 `json:"et0_mm"`
+	GrowthStage   string  `json:"growth_stage"`
+	Kc            float64 `json:"kc"`
+	ETc           float64 `json:"etc_mm"`
+	SoilMoisture  float64 `json:"soil_moisture_percent"`
+	DeficitMm     float64 `json:"deficit_mm"`
+	RecommendedMm float64 `json:"recommended_mm"`
+	IrrigatedMm   float64 `json:"irrigated_mm"`
+	Status        string  `json:"status"`
+}
+
+// IrrigationEvent is an irrigation actually applied to a crop.
+type IrrigationEvent struct {
+	Crop        string  `json:"crop"`
+	IrrigatedAt string  `json:"irrigated_at"`
+	AmountMm    float64 `json:"amount_mm"`
+	TxID        string  `json:"tx_id"`
+}
+
+// dailyWeather aggregates the readings of one day for the Penman-Monteith equation.
+type dailyWeather struct {
+	tMin, tMax, tMean float64
+	// actual vapour pressure, kPa
+	ea float64
+	// solar radiation, MJ/m2/day
+	rs float64
+	// atmospheric pressure, kPa
+	pressure float64
+}
+
+// ============================================================
+// adviseIrrigation - compute the irrigation advice of a crop for a day
+// ============================================================
+func (t *SimpleChaincode) adviseIrrigation(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0       1
+	// "crop", "date"
+	// date is YYYY-MM-DD, the advice uses every reading of the crop on that day
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+
+	fmt.Println("- start advise irrigation", args[0], args[1])
+
+	crop, err := getCrop(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	day, err := time.Parse("2006-01-02", args[1])
+	if err != nil {
+		return shim.Error("date must be formatted as YYYY-MM-DD")
+	}
+	date := day.Format("2006-01-02")
+	entry, err := getCatalogEntry(stub, crop.CropInfo)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	coefficients := entry.Coefficients
+	if coefficients.KcInitial == 0 || coefficients.RootDepthM == 0 {
+		return shim.Error("catalog entry of species " + crop.Species + " has no crop coefficients")
+	}
+	location, err := cropLocation(stub, crop.CropInfo)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	readings, err := getReadings(stub, crop.Name, "", date+"T00:00:00Z", date+"T23:59:59Z")
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if len(readings) == 0 {
+		return shim.Error(fmt.Sprintf("no readings of crop %s on %s", crop.Name, date))
+	}
+
+	weather, moisture := aggregateDailyWeather(readings)
+	advice := IrrigationAdvice{
+		Crop:         crop.Name,
+		Date:         date,
+		Readings:     len(readings),
+		ET0:          roundDecimals(referenceET0(weather, location.Latitude, day.YearDay()), 2),
+		GrowthStage:  cropGrowthStage(crop.CropConditions),
+		SoilMoisture: moisture,
+	}
+	advice.Kc = stageCropCoefficient(coefficients, advice.GrowthStage)
+	advice.ETc = roundDecimals(advice.Kc*advice.ET0, 2)
+	// water needed to bring the root zone back to field capacity, negative when wetter
+	advice.DeficitMm = roundDecimals((coefficients.FieldCapacityPercent-moisture)/100*coefficients.RootDepthM*1000, 2)
+	advice.RecommendedMm = roundDecimals(math.Max(0, advice.ETc+advice.DeficitMm), 2)
+
+	events, err := getIrrigationEvents(stub, crop.Name, date+"T00:00:00Z", date+"T23:59:59Z")
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	for _, event := range events {
+		advice.IrrigatedMm += event.AmountMm
+	}
+	reconcileIrrigation(&advice)
+
+	err = putIrrigationAdvice(stub, advice)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	adviceJSONasBytes, err := json.Marshal(advice)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	fmt.Println("- end advise irrigation (successful)")
+	return shim.Success(adviceJSONasBytes)
+}
+
+// ============================================================
+// recordIrrigation - record irrigation applied to a crop and reconcile it with the advice
+// ============================================================
+func (t *SimpleChaincode) recordIrrigation(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0       1            2
+	// "crop", "amount mm", "irrigated at"
+	// irrigated at is optional and defaults to the transaction time
+	if len(args) != 2 && len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 2 or 3")
+	}
+
+	fmt.Println("- start record irrigation", args[0])
+
+	event := IrrigationEvent{
+		Crop: args[0],
+		TxID: stub.GetTxID(),
+	}
+	var err error
+	event.AmountMm, err = strconv.ParseFloat(args[1], 64)
+	if err != nil || event.AmountMm <= 0 {
+		return shim.Error("amount must be a positive number of mm")
+	}
+	now, err := txTimestamp(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	event.IrrigatedAt = now
+	if len(args) == 3 && args[2] != "" {
+		event.IrrigatedAt, err = parseReadingTime(args[2])
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if event.IrrigatedAt > now {
+			return shim.Error("irrigation cannot be recorded in advance")
+		}
+	}
+
+	var activities CropActivities
+	err = getCropAspect(stub, event.Crop, activitiesPart, &activities)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	activities.Irrigation = true
+	err = putCropPart(stub, event.Crop, activitiesPart, activities)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	eventKey, err := stub.CreateCompositeKey(cropIrrigationIndexName, []string{event.Crop, event.IrrigatedAt, event.TxID})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	eventJSONasBytes, err := json.Marshal(event)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = stub.PutState(eventKey, eventJSONasBytes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = addCropFieldEvent(stub, event.Crop, fieldEventTreatment, "irrigation "+args[1]+" mm")
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// irrigation on a day that was already advised is reconciled right away
+	advice, found, err := getIrrigationAdvice(stub, event.Crop, event.IrrigatedAt[:10])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if found {
+		advice.IrrigatedMm += event.AmountMm
+		reconcileIrrigation(&advice)
+		err = putIrrigationAdvice(stub, advice)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	fmt.Println("- end record irrigation (successful)")
+	return shim.Success(nil)
+}
+
+// ============================================================
+// irrigationAdviceOf - list the irrigation advice of a crop between two dates
+// ============================================================
+func (t *SimpleChaincode) irrigationAdviceOf(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0       1       2
+	// "crop", "from", "to"
+	// from and to are YYYY-MM-DD and may be empty for an open range
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 3")
+	}
+
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(irrigationAdviceIndexName, []string{args[0]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	advices := []IrrigationAdvice{}
+	for resultsIterator.HasNext() {
+		responseRange, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		var advice IrrigationAdvice
+		err = json.Unmarshal(responseRange.Value, &advice)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if (args[1] != "" && advice.Date < args[1]) || (args[2] != "" && advice.Date > args[2]) {
+			continue
+		}
+		advices = append(advices, advice)
+	}
+	advicesJSON, err := json.Marshal(advices)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(advicesJSON)
+}
+
+// ============================================================
+// irrigationEventsOf - list the irrigation applied to a crop inside a time window
+// ============================================================
+func (t *SimpleChaincode) irrigationEventsOf(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0       1       2
+	// "crop", "from", "to"
+	// from and to may be empty to leave that side of the query open
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 3")
+	}
+
+	from, to, err := parseTimeWindow(args[1], args[2])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if to == "" {
+		if to, err = txTimestamp(stub); err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+	events, err := getIrrigationEvents(stub, args[0], from, to)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	eventsJSON, err := json.Marshal(events)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(eventsJSON)
+}
+
+// aggregateDailyWeather reduces the readings of a day to the daily values of
+// the Penman-Monteith equation, and returns the soil moisture of the last
+// reading of the day. The units are those documented on WeatherType, the
+// daily rates of solar radiation are averaged into the daily total.
+func aggregateDailyWeather(readings []SensorReading) (dailyWeather, float64) {
+	weather := dailyWeather{tMin: math.Inf(1), tMax: math.Inf(-1)}
+	var pressures, latest int
+	for i, reading := range readings {
+		celcius := reading.Weather.Temperature.Celcius
+		weather.tMin = math.Min(weather.tMin, celcius)
+		weather.tMax = math.Max(weather.tMax, celcius)
+		weather.tMean += celcius
+		weather.ea += actualVapourPressure(reading.Weather.Humidity.CubicMeter, celcius)
+		weather.rs += reading.Weather.Radiation.Rem
+		kPa := reading.Weather.Pressure.Pascal / 1000
+		if kPa > 50 && kPa < 110 {
+			weather.pressure += kPa
+			pressures++
+		}
+		if reading.Timestamp > readings[latest].Timestamp {
+			latest = i
+		}
+	}
+	count := float64(len(readings))
+	weather.tMean /= count
+	weather.ea /= count
+	weather.rs /= count
+	if pressures > 0 {
+		weather.pressure /= float64(pressures)
+	} else {
+		weather.pressure = standardPressureKPa
+	}
+	return weather, readings[latest].SoilCondition.Moisture.CubicMeter
+}
+
+// referenceET0 is the FAO-56 Penman-Monteith reference evapotranspiration in
+// mm/day for the daily weather at the latitude on the day of the year. Soil
+// heat flux is neglected for daily steps.
+func referenceET0(weather dailyWeather, latitude float64, dayOfYear int) float64 {
+	delta := 4098 * saturationVapourPressure(weather.tMean) / math.Pow(weather.tMean+237.3, 2)
+	gamma := 0.000665 * weather.pressure
+	es := (saturationVapourPressure(weather.tMax) + saturationVapourPressure(weather.tMin)) / 2
+	ea := math.Min(weather.ea, es)
+
+	// extraterrestrial and clear-sky radiation
+	phi := toRadians(latitude)
+	dr := 1 + 0.033*math.Cos(2*math.Pi*float64(dayOfYear)/365)
+	declination := 0.409 * math.Sin(2*math.Pi*float64(dayOfYear)/365-1.39)
+	ws := math.Acos(math.Max(-1, math.Min(1, -math.Tan(phi)*math.Tan(declination))))
+	ra := 24 * 60 / math.Pi * 0.0820 * dr * (ws*math.Sin(phi)*math.Sin(declination) + math.Cos(phi)*math.Cos(declination)*math.Sin(ws))
+	elevation := (293 - 293*math.Pow(weather.pressure/101.3, 1/5.26)) / 0.0065
+	rso := (0.75 + 2e-5*elevation) * ra
+
+	// net shortwave and longwave radiation
+	rns := (1 - 0.23) * weather.rs
+	relativeRadiation := 1.0
+	if rso > 0 {
+		relativeRadiation = math.Min(1, weather.rs/rso)
+	}
+	rnl := 4.903e-9 * (math.Pow(weather.tMax+273.16, 4) + math.Pow(weather.tMin+273.16, 4)) / 2 *
+		(0.34 - 0.14*math.Sqrt(ea)) * (1.35*relativeRadiation - 0.35)
+	rn := rns - rnl
+
+	et0 := (0.408*delta*rn + gamma*900/(weather.tMean+273)*defaultWindSpeed*(es-ea)) /
+		(delta + gamma*(1+0.34*defaultWindSpeed))
+	return math.Max(0, et0)
+}
+
+// saturationVapourPressure in kPa at the temperature in degrees Celcius.
+func saturationVapourPressure(celcius float64) float64 {
+	return 0.6108 * math.Exp(17.27*celcius/(celcius+237.3))
+}
+
+// actualVapourPressure in kPa of air with the absolute humidity in g/m3 at the
+// temperature in degrees Celcius, from the ideal gas law for water vapour.
+func actualVapourPressure(gramsPerCubicMeter, celcius float64) float64 {
+	return gramsPerCubicMeter / 1000 * 461.5 * (celcius + 273.15) / 1000
+}
+
+// stageCropCoefficient interpolates the crop coefficient of a growth stage,
+// development and late stages lie between the neighbouring coefficients.
+func stageCropCoefficient(coefficients CropCoefficients, stage string) float64 {
+	switch stage {
+	case stageDevelopment:
+		return roundDecimals((coefficients.KcInitial+coefficients.KcMid)/2, 2)
+	case stageMid:
+		return coefficients.KcMid
+	case stageLate:
+		return roundDecimals((coefficients.KcMid+coefficients.KcEnd)/2, 2)
+	}
+	return coefficients.KcInitial
+}
+
+// reconcileIrrigation compares the irrigation applied on the advised day with
+// the recommended amount.
+func reconcileIrrigation(advice *IrrigationAdvice) {
+	advice.IrrigatedMm = roundDecimals(advice.IrrigatedMm, 2)
+	switch {
+	case advice.IrrigatedMm == 0 && advice.RecommendedMm > 0:
+		advice.Status = advicePending
+	case advice.IrrigatedMm < advice.RecommendedMm*(1-irrigationTolerance):
+		advice.Status = adviceUnder
+	case advice.IrrigatedMm > advice.RecommendedMm*(1+irrigationTolerance):
+		advice.Status = adviceOver
+	default:
+		advice.Status = adviceMet
+	}
+}
+
+func getIrrigationAdvice(stub shim.ChaincodeStubInterface, cropName, date string) (IrrigationAdvice, bool, error) {
+	var advice IrrigationAdvice
+	adviceKey, err := stub.CreateCompositeKey(irrigationAdviceIndexName, []string{cropName, date})
+	if err != nil {
+		return advice, false, err
+	}
+	adviceAsBytes, err := stub.GetState(adviceKey)
+	if err != nil {
+		return advice, false, fmt.Errorf("Failed to get irrigation advice: %s", err.Error())
+	} else if adviceAsBytes == nil {
+		return advice, false, nil
+	}
+	err = json.Unmarshal(adviceAsBytes, &advice)
+	return advice, err == nil, err
+}
+
+func putIrrigationAdvice(stub shim.ChaincodeStubInterface, advice IrrigationAdvice) error {
+	adviceKey, err := stub.CreateCompositeKey(irrigationAdviceIndexName, []string{advice.Crop, advice.Date})
+	if err != nil {
+		return err
+	}
+	adviceJSONasBytes, err := json.Marshal(advice)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(adviceKey, adviceJSONasBytes)
+}
+
+// getIrrigationEvents returns the irrigation of a crop between two timestamps.
+func getIrrigationEvents(stub shim.ChaincodeStubInterface, cropName, from, to string) ([]IrrigationEvent, error) {
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(cropIrrigationIndexName, []string{cropName})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	events := []IrrigationEvent{}
+	for resultsIterator.HasNext() {
+		responseRange, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		var event IrrigationEvent
+		err = json.Unmarshal(responseRange.Value, &event)
+		if err != nil {
+			return nil, err
+		}
+		if event.IrrigatedAt < from {
+			continue
+		}
+		if event.IrrigatedAt > to {
+			break
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
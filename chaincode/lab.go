@@ -0,0 +1,474 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package chaincode
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/lib/cid"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/core/chaincode/shim/ext/statebased"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+const (
+	labIndexName         = "lab"
+	labCertIndexName     = "labcert~kind~id"
+	accreditorAttribute  = "hssf.accreditor"
+	labResultKindSoil    = "soil"
+	labResultKindResidue = "residue"
+)
+
+const (
+	labAccredited = "accredited"
+	labRevoked    = "revoked"
+)
+
+// Lab is a testing laboratory organization. Only accredited labs can submit
+// soil and residue results, and only from their own MSP.
+type Lab struct {
+	ID                  string `json:"id"`
+	Name                string `json:"name"`
+	MSPID               string `json:"msp_id"`
+	AccreditationBody   string `json:"accreditation_body"`
+	AccreditationNumber string `json:"accreditation_number"`
+	AccreditedUntil     string `json:"accredited_until"`
+	Status              string `json:"status"`
+	Revoked             string `json:"revoked,omitempty"`
+}
+
+// LabCertificate records who issued a lab result and the hash of its content.
+// Like the result itself it can only be changed with an endorsement of the
+// lab's peers.
+type LabCertificate struct {
+	Kind              string `json:"kind"`
+	ResultID          string `json:"result_id"`
+	Lab               string `json:"lab"`
+	MSPID             string `json:"msp_id"`
+	Submitter         string `json:"submitter"`
+	ContentHash       string `json:"content_hash"`
+	IssuedAt          string `json:"issued_at"`
+	TxID              string `json:"tx_id"`
+	AccreditationBody string `json:"accreditation_body"`
+	AccreditedUntil   string `json:"accredited_until"`
+}
+
+// LabVerification is the answer to a buyer asking whether a result is genuine.
+type LabVerification struct {
+	Kind              string `json:"kind"`
+	ResultID          string `json:"result_id"`
+	Lab               string `json:"lab"`
+	LabName           string `json:"lab_name"`
+	MSPID             string `json:"msp_id"`
+	AccreditedAtIssue bool   `json:"accredited_at_issue"`
+	AccreditedNow     bool   `json:"accredited_now"`
+	ContentHash       string `json:"content_hash"`
+	HashMatches       bool   `json:"hash_matches"`
+	Versions          int    `json:"versions"`
+	NeverAltered      bool   `json:"never_altered"`
+	Valid             bool   `json:"valid"`
+	Verified          bool   `json:"verified"`
+}
+
+// ============================================================
+// accreditLab - register or renew an accredited lab organization
+// ============================================================
+func (t *SimpleChaincode) accreditLab(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0     1       2          3                      4                        5
+	// "id", "name", "msp id", "accreditation body", "accreditation number", "accredited until"
+	// Only identities carrying the hssf.accreditor=true attribute may accredit labs.
+	if len(args) != 6 {
+		return shim.Error("Incorrect number of arguments. Expecting 6")
+	}
+
+	fmt.Println("- start accredit lab")
+
+	err := requireAccreditor(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	for i, arg := range args {
+		if len(arg) == 0 {
+			return shim.Error(fmt.Sprintf("argument %d must be a non-empty string", i+1))
+		}
+	}
+	accreditedUntil, err := time.Parse("2006-01-02", args[5])
+	if err != nil {
+		return shim.Error("accredited until must be formatted as YYYY-MM-DD")
+	}
+
+	lab := Lab{
+		ID:                  args[0],
+		Name:                args[1],
+		MSPID:               args[2],
+		AccreditationBody:   args[3],
+		AccreditationNumber: args[4],
+		AccreditedUntil:     accreditedUntil.Format("2006-01-02"),
+		Status:              labAccredited,
+	}
+	err = putAsset(stub, labIndexName, lab.ID, lab)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	fmt.Println("- end accredit lab (successful)")
+	return shim.Success(nil)
+}
+
+// ============================================================
+// revokeLab - withdraw the accreditation of a lab
+// ============================================================
+func (t *SimpleChaincode) revokeLab(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0
+	// "id"
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	err := requireAccreditor(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	lab, err := getLab(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	timestamp, err := txTimestamp(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	lab.Status = labRevoked
+	lab.Revoked = timestamp
+	err = putAsset(stub, labIndexName, lab.ID, lab)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(nil)
+}
+
+// ============================================================
+// readLab - read a lab from chaincode state
+// ============================================================
+func (t *SimpleChaincode) readLab(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting id of the lab to query")
+	}
+
+	lab, err := getLab(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	labJSONasBytes, err := json.Marshal(lab)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(labJSONasBytes)
+}
+
+// ============================================================
+// verifyLabResult - show that a result was issued by an accredited lab and never altered
+// ============================================================
+func (t *SimpleChaincode) verifyLabResult(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0                    1
+	// "soil" | "residue", "result id"
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+
+	kind, resultID := args[0], args[1]
+	objectType, err := labResultObjectType(kind)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	var certificate LabCertificate
+	err = getLabCertificate(stub, kind, resultID, &certificate)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	lab, err := getLab(stub, certificate.Lab)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	timestamp, err := txTimestamp(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	verification := LabVerification{
+		Kind:              kind,
+		ResultID:          resultID,
+		Lab:               lab.ID,
+		LabName:           lab.Name,
+		MSPID:             certificate.MSPID,
+		AccreditedAtIssue: certificate.IssuedAt[:10] <= certificate.AccreditedUntil,
+		AccreditedNow:     labAccreditedAt(lab, timestamp),
+		ContentHash:       certificate.ContentHash,
+		NeverAltered:      true,
+	}
+
+	// every version the result ever had must carry the certified content
+	resultKey, err := stub.CreateCompositeKey(objectType, []string{resultID})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	resultsIterator, err := stub.GetHistoryForKey(resultKey)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+	for resultsIterator.HasNext() {
+		modification, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		verification.Versions++
+		if modification.IsDelete {
+			verification.NeverAltered = false
+			continue
+		}
+		contentHash, err := labResultHash(kind, modification.Value)
+		if err != nil || contentHash != certificate.ContentHash {
+			verification.NeverAltered = false
+		}
+	}
+
+	resultAsBytes, err := stub.GetState(resultKey)
+	if err != nil {
+		return shim.Error(err.Error())
+	} else if resultAsBytes != nil {
+		contentHash, err := labResultHash(kind, resultAsBytes)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		verification.HashMatches = contentHash == certificate.ContentHash
+		var validity struct {
+			Valid bool `json:"valid"`
+		}
+		err = json.Unmarshal(resultAsBytes, &validity)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		verification.Valid = validity.Valid
+	}
+	verification.Verified = verification.AccreditedAtIssue && verification.HashMatches && verification.NeverAltered
+
+	verificationJSON, err := json.Marshal(verification)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(verificationJSON)
+}
+
+// requireSubmittingLab checks that the invoker belongs to the MSP of the lab
+// and that the lab is accredited at the transaction time.
+func requireSubmittingLab(stub shim.ChaincodeStubInterface, labID string) (Lab, error) {
+	lab, err := requireLabMSP(stub, labID)
+	if err != nil {
+		return lab, err
+	}
+	timestamp, err := txTimestamp(stub)
+	if err != nil {
+		return lab, err
+	}
+	if !labAccreditedAt(lab, timestamp) {
+		return lab, fmt.Errorf("lab %s is not accredited", lab.ID)
+	}
+	return lab, nil
+}
+
+// requireLabMSP checks that the invoker belongs to the MSP of the lab. A lab
+// that lost its accreditation can still withdraw its earlier results.
+func requireLabMSP(stub shim.ChaincodeStubInterface, labID string) (Lab, error) {
+	lab, err := getLab(stub, labID)
+	if err != nil {
+		return lab, err
+	}
+	mspID, err := cid.GetMSPID(stub)
+	if err != nil {
+		return lab, fmt.Errorf("Failed to get invoker MSP: %s", err.Error())
+	}
+	if mspID != lab.MSPID {
+		return lab, fmt.Errorf("results of lab %s can only be submitted from MSP %s", lab.ID, lab.MSPID)
+	}
+	return lab, nil
+}
+
+// certifyLabResult writes the certificate of a lab result and restricts both
+// the result and the certificate keys to endorsements of the lab's peers, so
+// neither can be changed without the lab.
+func certifyLabResult(stub shim.ChaincodeStubInterface, lab Lab, kind, resultID string, resultJSONasBytes []byte) error {
+	objectType, err := labResultObjectType(kind)
+	if err != nil {
+		return err
+	}
+	contentHash, err := labResultHash(kind, resultJSONasBytes)
+	if err != nil {
+		return err
+	}
+	submitter, err := cid.GetID(stub)
+	if err != nil {
+		return fmt.Errorf("Failed to get invoker identity: %s", err.Error())
+	}
+	issuedAt, err := txTimestamp(stub)
+	if err != nil {
+		return err
+	}
+
+	certificate := LabCertificate{
+		Kind:              kind,
+		ResultID:          resultID,
+		Lab:               lab.ID,
+		MSPID:             lab.MSPID,
+		Submitter:         submitter,
+		ContentHash:       contentHash,
+		IssuedAt:          issuedAt,
+		TxID:              stub.GetTxID(),
+		AccreditationBody: lab.AccreditationBody,
+		AccreditedUntil:   lab.AccreditedUntil,
+	}
+	certificateKey, err := stub.CreateCompositeKey(labCertIndexName, []string{kind, resultID})
+	if err != nil {
+		return err
+	}
+	certificateJSONasBytes, err := json.Marshal(certificate)
+	if err != nil {
+		return err
+	}
+	err = stub.PutState(certificateKey, certificateJSONasBytes)
+	if err != nil {
+		return err
+	}
+
+	resultKey, err := stub.CreateCompositeKey(objectType, []string{resultID})
+	if err != nil {
+		return err
+	}
+	endorsementPolicy, err := statebased.NewStateEP(nil)
+	if err != nil {
+		return err
+	}
+	err = endorsementPolicy.AddOrgs(statebased.RoleTypePeer, lab.MSPID)
+	if err != nil {
+		return err
+	}
+	policy, err := endorsementPolicy.Policy()
+	if err != nil {
+		return err
+	}
+	for _, key := range []string{resultKey, certificateKey} {
+		err = stub.SetStateValidationParameter(key, policy)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// labResultHash hashes the certified content of a result. The validity of a
+// result is excluded, withdrawing a result does not change what was measured.
+func labResultHash(kind string, resultJSONasBytes []byte) (string, error) {
+	var content interface{}
+	switch kind {
+	case labResultKindSoil:
+		var test SoilTest
+		if err := json.Unmarshal(resultJSONasBytes, &test); err != nil {
+			return "", err
+		}
+		test.Valid, test.Invalidated = false, ""
+		content = test
+	case labResultKindResidue:
+		var test ResidueTest
+		if err := json.Unmarshal(resultJSONasBytes, &test); err != nil {
+			return "", err
+		}
+		test.Valid, test.Invalidated = false, ""
+		content = test
+	default:
+		return "", fmt.Errorf("unknown lab result kind: %s", kind)
+	}
+
+	contentJSONasBytes, err := json.Marshal(content)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(contentJSONasBytes)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// labResultObjectType returns the composite key object type results of a kind are stored under.
+func labResultObjectType(kind string) (string, error) {
+	switch kind {
+	case labResultKindSoil:
+		return soilTestIndexName, nil
+	case labResultKindResidue:
+		return residueTestIndexName, nil
+	}
+	return "", fmt.Errorf("lab result kind must be %s or %s", labResultKindSoil, labResultKindResidue)
+}
+
+// labAccreditedAt reports whether the lab is accredited at the timestamp.
+func labAccreditedAt(lab Lab, timestamp string) bool {
+	return lab.Status == labAccredited && timestamp[:10] <= lab.AccreditedUntil
+}
+
+// requireAccreditor checks that the invoker may accredit labs.
+func requireAccreditor(stub shim.ChaincodeStubInterface) error {
+	return requireAttribute(stub, accreditorAttribute, "only accreditors may manage labs")
+}
+
+// requireAttribute checks that the invoker certificate carries the attribute
+// with the value true, and fails with the message otherwise.
+func requireAttribute(stub shim.ChaincodeStubInterface, attribute, message string) error {
+	value, found, err := cid.GetAttributeValue(stub, attribute)
+	if err != nil {
+		return fmt.Errorf("Failed to get invoker attributes: %s", err.Error())
+	}
+	if !found || value != "true" {
+		return errors.New(message)
+	}
+	return nil
+}
+
+func getLab(stub shim.ChaincodeStubInterface, labID string) (Lab, error) {
+	var lab Lab
+	err := getAsset(stub, labIndexName, labID, &lab)
+	return lab, err
+}
+
+func getLabCertificate(stub shim.ChaincodeStubInterface, kind, resultID string, certificate *LabCertificate) error {
+	certificateKey, err := stub.CreateCompositeKey(labCertIndexName, []string{kind, resultID})
+	if err != nil {
+		return err
+	}
+	certificateAsBytes, err := stub.GetState(certificateKey)
+	if err != nil {
+		return fmt.Errorf("Failed to get lab certificate: %s", err.Error())
+	} else if certificateAsBytes == nil {
+		return fmt.Errorf("no lab certificate for %s result %s", kind, resultID)
+	}
+	return json.Unmarshal(certificateAsBytes, certificate)
+}
@@ -0,0 +1,572 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package chaincode
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/lib/cid"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// Models are keyed mlmodel~name~version so every version of a model can be
+// listed. Recommendations are indexed by the model version that made them and
+// by the crop they are for.
+const (
+	modelIndexName               = "mlmodel"
+	recommendationIndexName      = "recommendation"
+	modelRecommendationIndexName = "model~version~recommendation"
+	cropRecommendationIndexName  = "crop~recommendedat~recommendation"
+	recommendationPending        = "pending"
+	recommendationAccepted       = "accepted"
+	recommendationRejected       = "rejected"
+)
+
+// recommendationActions are the actions a model may recommend.
+var recommendationActions = []string{"irrigate", "fertilize", "spray"}
+
+// MLModel is a registered version of a machine learning model. The artifact
+// hash is the hex encoded SHA-256 of the model file so the artifact behind
+// every recommendation can be checked, the training window the time span of
+// the data it was trained on.
+type MLModel struct {
+	Name         string `json:"name"`
+	Version      string `json:"version"`
+	ArtifactHash string `json:"artifact_hash"`
+	ArtifactURI  string `json:"artifact_uri"`
+	TrainingFrom string `json:"training_from"`
+	TrainingTo   string `json:"training_to"`
+	Author       string `json:"author"`
+	AuthorMSP    string `json:"author_msp"`
+	RegisteredAt string `json:"registered_at"`
+	Retired      bool   `json:"retired"`
+}
+
+// Recommendation is an action recommended for a crop by a model version and
+// the decision of the farmer on it. SelfDecided flags a decision taken from
+// the MSP that authored the model, which rates its own model.
+type Recommendation struct {
+	ID            string  `json:"id"`
+	Crop          string  `json:"crop"`
+	Model         string  `json:"model"`
+	ModelVersion  string  `json:"model_version"`
+	Action        string  `json:"action"`
+	Detail        string  `json:"detail"`
+	Confidence    float64 `json:"confidence"`
+	RecommendedAt string  `json:"recommended_at"`
+	Status        string  `json:"status"`
+	DecidedAt     string  `json:"decided_at,omitempty"`
+	DecidedBy     string  `json:"decided_by,omitempty"`
+	Note          string  `json:"note,omitempty"`
+	SelfDecided   bool    `json:"self_decided,omitempty"`
+	TxID          string  `json:"tx_id"`
+}
+
+// ActionPerformance counts the recommendations of one action by decision.
+// SelfDecided counts the decisions among them taken from the model author MSP.
+type ActionPerformance struct {
+	Recommended    int     `json:"recommended"`
+	Accepted       int     `json:"accepted"`
+	Rejected       int     `json:"rejected"`
+	Pending        int     `json:"pending"`
+	SelfDecided    int     `json:"self_decided"`
+	AcceptanceRate float64 `json:"acceptance_rate"`
+	MeanConfidence float64 `json:"mean_confidence"`
+}
+
+// ModelPerformance is the audit of the recommendations of a model version.
+type ModelPerformance struct {
+	Model   string                       `json:"model"`
+	Version string                       `json:"version"`
+	Total   ActionPerformance            `json:"total"`
+	Actions map[string]ActionPerformance `json:"actions"`
+}
+
+// ============================================================
+// registerModel - register a version of a machine learning model
+// ============================================================
+func (t *SimpleChaincode) registerModel(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0       1          2                  3               4                5
+	// "name", "version", "artifact sha256", "artifact uri", "training from", "training to"
+	// the author organisation is the MSP of the invoker
+	if len(args) != 6 {
+		return shim.Error("Incorrect number of arguments. Expecting 6")
+	}
+
+	fmt.Println("- start register model")
+
+	model := MLModel{
+		Name:         strings.ToLower(args[0]),
+		Version:      args[1],
+		ArtifactHash: strings.ToLower(args[2]),
+		ArtifactURI:  args[3],
+	}
+	if len(model.Name) == 0 || len(model.Version) == 0 || len(model.ArtifactURI) == 0 {
+		return shim.Error("model name, version and artifact uri must be non-empty strings")
+	}
+	hash, err := hex.DecodeString(model.ArtifactHash)
+	if err != nil || len(hash) != 32 {
+		return shim.Error("artifact hash must be a hex encoded SHA-256 hash")
+	}
+	model.TrainingFrom, model.TrainingTo, err = parseTimeWindow(args[4], args[5])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if model.TrainingFrom == "" || model.TrainingTo == "" || model.TrainingFrom > model.TrainingTo {
+		return shim.Error("training window must have a start before its end")
+	}
+	_, err = getModel(stub, model.Name, model.Version)
+	if err == nil {
+		return shim.Error("model version is already registered, register a new version: " + model.Name + " " + model.Version)
+	}
+
+	model.Author, err = cid.GetID(stub)
+	if err != nil {
+		return shim.Error("Failed to get author identity: " + err.Error())
+	}
+	model.AuthorMSP, err = cid.GetMSPID(stub)
+	if err != nil {
+		return shim.Error("Failed to get author MSP: " + err.Error())
+	}
+	model.RegisteredAt, err = txTimestamp(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = putModel(stub, model)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	fmt.Println("- end register model (successful)")
+	return shim.Success(nil)
+}
+
+// ============================================================
+// retireModel - stop a model version from making new recommendations
+// ============================================================
+func (t *SimpleChaincode) retireModel(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0       1
+	// "name", "version"
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+
+	model, err := requireModelAuthor(stub, strings.ToLower(args[0]), args[1])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	model.Retired = true
+	err = putModel(stub, model)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(nil)
+}
+
+// ============================================================
+// readModel - read a model version, every version when the version is empty
+// ============================================================
+func (t *SimpleChaincode) readModel(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0       1
+	// "name", "version"
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+
+	name := strings.ToLower(args[0])
+	if args[1] != "" {
+		model, err := getModel(stub, name, args[1])
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		modelJSONasBytes, err := json.Marshal(model)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		return shim.Success(modelJSONasBytes)
+	}
+
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(modelIndexName, []string{name})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	models := []MLModel{}
+	for resultsIterator.HasNext() {
+		responseRange, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		var model MLModel
+		err = json.Unmarshal(responseRange.Value, &model)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		models = append(models, model)
+	}
+	modelsJSON, err := json.Marshal(models)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(modelsJSON)
+}
+
+// ============================================================
+// recordRecommendation - record an action a model version recommends for a crop
+// ============================================================
+func (t *SimpleChaincode) recordRecommendation(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0     1       2        3          4                                    5         6
+	// "id", "crop", "model", "version", "irrigate" | "fertilize" | "spray", "detail", "confidence"
+	// Recommendations are submitted from the MSP that registered the model,
+	// confidence is the probability the model gives its recommendation, 0-1.
+	if len(args) != 7 {
+		return shim.Error("Incorrect number of arguments. Expecting 7")
+	}
+
+	fmt.Println("- start record recommendation")
+
+	recommendation := Recommendation{
+		ID:     args[0],
+		Crop:   args[1],
+		Action: strings.ToLower(args[4]),
+		Detail: args[5],
+		Status: recommendationPending,
+		TxID:   stub.GetTxID(),
+	}
+	if len(recommendation.ID) == 0 {
+		return shim.Error("recommendation id must be a non-empty string")
+	}
+	err := getAsset(stub, recommendationIndexName, recommendation.ID, &Recommendation{})
+	if err == nil {
+		return shim.Error("This recommendation already exists: " + recommendation.ID)
+	}
+	if !containsString(recommendationActions, recommendation.Action) {
+		return shim.Error("action must be one of " + strings.Join(recommendationActions, ", "))
+	}
+	recommendation.Confidence, err = strconv.ParseFloat(args[6], 64)
+	if err != nil || recommendation.Confidence < 0 || recommendation.Confidence > 1 {
+		return shim.Error("confidence must be a number from 0 to 1")
+	}
+	_, err = getCropBase(stub, recommendation.Crop)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	model, err := requireModelAuthor(stub, strings.ToLower(args[2]), args[3])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if model.Retired {
+		return shim.Error("model " + model.Name + " " + model.Version + " is retired")
+	}
+	recommendation.Model = model.Name
+	recommendation.ModelVersion = model.Version
+	recommendation.RecommendedAt, err = txTimestamp(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = putAsset(stub, recommendationIndexName, recommendation.ID, recommendation)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	indexKey, err := stub.CreateCompositeKey(modelRecommendationIndexName, []string{model.Name, model.Version, recommendation.ID})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = stub.PutState(indexKey, []byte{0x00})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	indexKey, err = stub.CreateCompositeKey(cropRecommendationIndexName, []string{recommendation.Crop, recommendation.RecommendedAt, recommendation.ID})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = stub.PutState(indexKey, []byte{0x00})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	fmt.Println("- end record recommendation (successful)")
+	return shim.Success(nil)
+}
+
+// ============================================================
+// decideRecommendation - record whether the farmer accepted a recommendation
+// ============================================================
+func (t *SimpleChaincode) decideRecommendation(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0     1                          2
+	// "id", "accepted" | "rejected", "note"
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 3")
+	}
+
+	var recommendation Recommendation
+	err := getAsset(stub, recommendationIndexName, args[0], &recommendation)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if recommendation.Status != recommendationPending {
+		return shim.Error("recommendation " + recommendation.ID + " is already " + recommendation.Status)
+	}
+	status := strings.ToLower(args[1])
+	if status != recommendationAccepted && status != recommendationRejected {
+		return shim.Error("decision must be accepted or rejected")
+	}
+	recommendation.SelfDecided, err = requireCropDecider(stub, recommendation)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	recommendation.Status = status
+	recommendation.Note = args[2]
+	recommendation.DecidedBy, err = cid.GetID(stub)
+	if err != nil {
+		return shim.Error("Failed to get invoker identity: " + err.Error())
+	}
+	recommendation.DecidedAt, err = txTimestamp(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = putAsset(stub, recommendationIndexName, recommendation.ID, recommendation)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(nil)
+}
+
+// ============================================================
+// recommendationsOf - list the recommendations for a crop or of a model version
+// ============================================================
+func (t *SimpleChaincode) recommendationsOf(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0                  1       2
+	// "crop" | "model", "name", "version"
+	// the version is only used for models and may be empty for every version
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 3")
+	}
+
+	var recommendations []Recommendation
+	var err error
+	switch args[0] {
+	case "crop":
+		recommendations, err = getRecommendations(stub, cropRecommendationIndexName, []string{args[1]})
+	case "model":
+		attributes := []string{strings.ToLower(args[1])}
+		if args[2] != "" {
+			attributes = append(attributes, args[2])
+		}
+		recommendations, err = getRecommendations(stub, modelRecommendationIndexName, attributes)
+	default:
+		return shim.Error("recommendations can be listed for a crop or a model")
+	}
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	recommendationsJSON, err := json.Marshal(recommendations)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(recommendationsJSON)
+}
+
+// ============================================================
+// modelPerformance - audit how the recommendations of a model version were decided
+// ============================================================
+func (t *SimpleChaincode) modelPerformance(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0       1
+	// "name", "version"
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+
+	model, err := getModel(stub, strings.ToLower(args[0]), args[1])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	recommendations, err := getRecommendations(stub, modelRecommendationIndexName, []string{model.Name, model.Version})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	performance := ModelPerformance{
+		Model:   model.Name,
+		Version: model.Version,
+		Actions: map[string]ActionPerformance{},
+	}
+	for _, recommendation := range recommendations {
+		action := performance.Actions[recommendation.Action]
+		countRecommendation(&action, recommendation)
+		performance.Actions[recommendation.Action] = action
+		countRecommendation(&performance.Total, recommendation)
+	}
+	for action, counts := range performance.Actions {
+		performance.Actions[action] = rateRecommendations(counts)
+	}
+	performance.Total = rateRecommendations(performance.Total)
+
+	performanceJSON, err := json.Marshal(performance)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(performanceJSON)
+}
+
+// countRecommendation adds a recommendation to the counts of its decision.
+// The confidence is summed and turned into a mean by rateRecommendations.
+func countRecommendation(counts *ActionPerformance, recommendation Recommendation) {
+	counts.Recommended++
+	counts.MeanConfidence += recommendation.Confidence
+	switch recommendation.Status {
+	case recommendationAccepted:
+		counts.Accepted++
+	case recommendationRejected:
+		counts.Rejected++
+	default:
+		counts.Pending++
+	}
+	if recommendation.SelfDecided {
+		counts.SelfDecided++
+	}
+}
+
+// rateRecommendations derives the acceptance rate of the decided
+// recommendations and the mean confidence from the counts.
+func rateRecommendations(counts ActionPerformance) ActionPerformance {
+	if decided := counts.Accepted + counts.Rejected; decided > 0 {
+		counts.AcceptanceRate = roundDecimals(float64(counts.Accepted)/float64(decided), 2)
+	}
+	if counts.Recommended > 0 {
+		counts.MeanConfidence = roundDecimals(counts.MeanConfidence/float64(counts.Recommended), 2)
+	}
+	return counts
+}
+
+// getRecommendations returns the recommendations referenced by an index,
+// selected by the leading key attributes.
+func getRecommendations(stub shim.ChaincodeStubInterface, indexName string, attributes []string) ([]Recommendation, error) {
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(indexName, attributes)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	recommendations := []Recommendation{}
+	for resultsIterator.HasNext() {
+		responseRange, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		_, keyParts, err := stub.SplitCompositeKey(responseRange.Key)
+		if err != nil {
+			return nil, err
+		}
+		var recommendation Recommendation
+		err = getAsset(stub, recommendationIndexName, keyParts[len(keyParts)-1], &recommendation)
+		if err != nil {
+			return nil, err
+		}
+		recommendations = append(recommendations, recommendation)
+	}
+	return recommendations, nil
+}
+
+// getModel reads a registered model version.
+func getModel(stub shim.ChaincodeStubInterface, name, version string) (MLModel, error) {
+	var model MLModel
+	modelKey, err := stub.CreateCompositeKey(modelIndexName, []string{name, version})
+	if err != nil {
+		return model, err
+	}
+	modelAsBytes, err := stub.GetState(modelKey)
+	if err != nil {
+		return model, fmt.Errorf("Failed to get model: %s", err.Error())
+	} else if modelAsBytes == nil {
+		return model, fmt.Errorf("model does not exist: %s %s", name, version)
+	}
+	err = json.Unmarshal(modelAsBytes, &model)
+	return model, err
+}
+
+// putModel writes a model version under its mlmodel~name~version key.
+func putModel(stub shim.ChaincodeStubInterface, model MLModel) error {
+	modelKey, err := stub.CreateCompositeKey(modelIndexName, []string{model.Name, model.Version})
+	if err != nil {
+		return err
+	}
+	modelJSONasBytes, err := json.Marshal(model)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(modelKey, modelJSONasBytes)
+}
+
+// requireCropDecider checks the invoker belongs to the MSP that created the
+// crop of a recommendation and reports whether that MSP also authored the
+// model, so the decision is flagged as self-decided.
+func requireCropDecider(stub shim.ChaincodeStubInterface, recommendation Recommendation) (bool, error) {
+	info, err := getCropInfo(stub, recommendation.Crop)
+	if err != nil {
+		return false, err
+	}
+	mspID, err := cid.GetMSPID(stub)
+	if err != nil {
+		return false, fmt.Errorf("Failed to get invoker MSP: %s", err.Error())
+	}
+	if info.OwnerMSP == "" {
+		return false, fmt.Errorf("crop %s has no owner MSP recorded, its recommendations cannot be decided", info.Name)
+	}
+	if mspID != info.OwnerMSP {
+		return false, fmt.Errorf("recommendations for crop %s can only be decided from MSP %s", info.Name, info.OwnerMSP)
+	}
+	model, err := getModel(stub, recommendation.Model, recommendation.ModelVersion)
+	if err != nil {
+		return false, err
+	}
+	return mspID == model.AuthorMSP, nil
+}
+
+// requireModelAuthor reads a model version and checks the invoker belongs to
+// the organisation that registered it.
+func requireModelAuthor(stub shim.ChaincodeStubInterface, name, version string) (MLModel, error) {
+	model, err := getModel(stub, name, version)
+	if err != nil {
+		return model, err
+	}
+	mspID, err := cid.GetMSPID(stub)
+	if err != nil {
+		return model, fmt.Errorf("Failed to get invoker MSP: %s", err.Error())
+	}
+	if mspID != model.AuthorMSP {
+		return model, fmt.Errorf("model %s %s can only be used from MSP %s", model.Name, model.Version, model.AuthorMSP)
+	}
+	return model, nil
+}
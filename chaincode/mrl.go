@@ -0,0 +1,298 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+const mrlIndexName = "mrl"
+
+const (
+	mrlReasonExceeded    = "exceeds limit"
+	mrlReasonUntested    = "applied but not tested since"
+	mrlReasonNeverTested = "applied but never tested, last applied"
+)
+
+// MRLTable holds the maximum residue limits of a destination market in mg/kg.
+// Limits are keyed by crop species and then active substance. Substances not
+// listed for a species fall back to the default limit, e.g. 0.01 mg/kg in the EU.
+type MRLTable struct {
+	Market       string                        `json:"market"`
+	Name         string                        `json:"name"`
+	DefaultLimit float64                       `json:"default_limit_mg_kg"`
+	Limits       map[string]map[string]float64 `json:"limits_mg_kg"`
+	Updated      string                        `json:"updated"`
+}
+
+// MRLFinding is a substance that keeps a crop from a market. Residue and Test
+// are those of the latest test of the substance, omitted when it was never tested.
+type MRLFinding struct {
+	Substance string   `json:"substance"`
+	Residue   *float64 `json:"residue_mg_kg,omitempty"`
+	Limit     float64  `json:"limit_mg_kg"`
+	Reason    string   `json:"reason"`
+	Test      string   `json:"test,omitempty"`
+}
+
+// MarketCompliance is the verdict for one destination market.
+type MarketCompliance struct {
+	Market    string       `json:"market"`
+	Pass      bool         `json:"pass"`
+	Offending []MRLFinding `json:"offending"`
+}
+
+// MRLReport is the compliance report of a crop for its destination markets.
+type MRLReport struct {
+	Crop      string             `json:"crop"`
+	Species   string             `json:"species"`
+	Evaluated string             `json:"evaluated"`
+	Applied   []string           `json:"applied_substances"`
+	Tests     []string           `json:"residue_tests"`
+	Markets   []MarketCompliance `json:"markets"`
+}
+
+// ============================================================
+// setMRLTable - store the maximum residue limits of a market
+// ============================================================
+func (t *SimpleChaincode) setMRLTable(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0         1       2                  3
+	// "market", "name", "default limit", "limits json"
+	// limits maps crop species to substance to mg/kg. Only identities carrying
+	// the hssf.regulator=true attribute may set limits.
+	if len(args) != 4 {
+		return shim.Error("Incorrect number of arguments. Expecting 4")
+	}
+
+	fmt.Println("- start set mrl table")
+
+	err := requireRegulator(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	table := MRLTable{
+		Market: strings.ToLower(args[0]),
+		Name:   args[1],
+	}
+	if len(table.Market) == 0 {
+		return shim.Error("1st argument must be a non-empty string")
+	}
+	table.DefaultLimit, err = strconv.ParseFloat(args[2], 64)
+	if err != nil || table.DefaultLimit < 0 {
+		return shim.Error("default limit must be a non-negative number")
+	}
+	var limits map[string]map[string]float64
+	err = json.Unmarshal([]byte(args[3]), &limits)
+	if err != nil {
+		return shim.Error("limits must be a JSON object of species to substance to mg/kg")
+	}
+	table.Limits = map[string]map[string]float64{}
+	for species, substances := range limits {
+		table.Limits[strings.ToLower(species)] = map[string]float64{}
+		for substance, limit := range substances {
+			if limit < 0 {
+				return shim.Error("limits must be non-negative: " + substance)
+			}
+			table.Limits[strings.ToLower(species)][strings.ToLower(substance)] = limit
+		}
+	}
+	table.Updated, err = txTimestamp(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = putAsset(stub, mrlIndexName, table.Market, table)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	fmt.Println("- end set mrl table (successful)")
+	return shim.Success(nil)
+}
+
+// ============================================================
+// readMRLTable - read the maximum residue limits of a market
+// ============================================================
+func (t *SimpleChaincode) readMRLTable(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting market to query")
+	}
+
+	var table MRLTable
+	err := getAsset(stub, mrlIndexName, strings.ToLower(args[0]), &table)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	tableJSONasBytes, err := json.Marshal(table)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(tableJSONasBytes)
+}
+
+// ============================================================
+// evaluateMRLCompliance - check a crop against the residue limits of destination markets
+// ============================================================
+func (t *SimpleChaincode) evaluateMRLCompliance(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0       1
+	// "crop", "markets"
+	// markets is a comma separated list, e.g. eu,us,domestic
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+
+	info, err := getCropInfo(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	applications, err := getPesticideApplications(stub, info.Name)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	tests, err := getResidueTests(stub, info.Name)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	var tables []MRLTable
+	for _, market := range strings.Split(args[1], ",") {
+		var table MRLTable
+		err = getAsset(stub, mrlIndexName, strings.ToLower(strings.TrimSpace(market)), &table)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		tables = append(tables, table)
+	}
+
+	report := evaluateMRL(info.Species, applications, tests, tables)
+	report.Crop = info.Name
+	report.Evaluated, err = txTimestamp(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	reportJSON, err := json.Marshal(report)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(reportJSON)
+}
+
+// evaluateMRL combines the application history and the valid residue tests of
+// a crop into a verdict per market. The residue of a substance is taken from
+// the most recent valid test that analysed it. A substance that was applied
+// after that test, or never tested at all, cannot be shown to comply.
+func evaluateMRL(species string, applications []PesticideApplication, tests []ResidueTest, tables []MRLTable) MRLReport {
+	report := MRLReport{
+		Species: species,
+		Applied: []string{},
+		Tests:   []string{},
+		Markets: []MarketCompliance{},
+	}
+
+	lastApplied := map[string]string{}
+	for _, application := range applications {
+		for _, ingredient := range application.ActiveIngredients {
+			substance := strings.ToLower(ingredient.Substance)
+			if _, ok := lastApplied[substance]; !ok {
+				report.Applied = append(report.Applied, substance)
+			}
+			if application.AppliedAt > lastApplied[substance] {
+				lastApplied[substance] = application.AppliedAt
+			}
+		}
+	}
+	sort.Strings(report.Applied)
+
+	type measurement struct {
+		residue   float64
+		test      string
+		sampledAt string
+	}
+	measured := map[string]measurement{}
+	for _, test := range tests {
+		if !test.Valid {
+			continue
+		}
+		report.Tests = append(report.Tests, test.ID)
+		// tests come in sampling order, later tests replace earlier ones
+		for substance, residue := range test.Residues {
+			measured[strings.ToLower(substance)] = measurement{residue, test.ID, test.SampledAt}
+		}
+	}
+	var substances []string
+	for substance := range measured {
+		substances = append(substances, substance)
+	}
+	for substance := range lastApplied {
+		if _, ok := measured[substance]; !ok {
+			substances = append(substances, substance)
+		}
+	}
+	sort.Strings(substances)
+
+	for _, table := range tables {
+		compliance := MarketCompliance{
+			Market:    table.Market,
+			Offending: []MRLFinding{},
+		}
+		for _, substance := range substances {
+			limit, ok := table.Limits[species][substance]
+			if !ok {
+				limit = table.DefaultLimit
+			}
+			latest, tested := measured[substance]
+			appliedAt, applied := lastApplied[substance]
+			residue := latest.residue
+			if applied && !tested {
+				compliance.Offending = append(compliance.Offending, MRLFinding{
+					Substance: substance,
+					Limit:     limit,
+					Reason:    mrlReasonNeverTested + " " + appliedAt,
+				})
+			} else if applied && latest.sampledAt < appliedAt {
+				compliance.Offending = append(compliance.Offending, MRLFinding{
+					Substance: substance,
+					Residue:   &residue,
+					Limit:     limit,
+					Reason:    mrlReasonUntested + " " + appliedAt,
+					Test:      latest.test,
+				})
+			} else if latest.residue > limit {
+				compliance.Offending = append(compliance.Offending, MRLFinding{
+					Substance: substance,
+					Residue:   &residue,
+					Limit:     limit,
+					Reason:    mrlReasonExceeded,
+					Test:      latest.test,
+				})
+			}
+		}
+		compliance.Pass = len(compliance.Offending) == 0
+		report.Markets = append(report.Markets, compliance)
+	}
+	return report
+}
@@ -0,0 +1,411 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package chaincode
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/lib/cid"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+const (
+	diseaseObservationIndexName      = "diseaseobs"
+	cropDiseaseObservationIndexName  = "crop~observedat~diseaseobs"
+	fieldDiseaseObservationIndexName = "field~observedat~diseaseobs"
+)
+
+// fieldEventDisease is the field history kind of a disease observation.
+const fieldEventDisease = "disease"
+
+// NTTU pathology levels grade the severity of a disease from 1, no visible
+// symptoms, to 5, severe damage.
+const (
+	minPathologyLevel = 1
+	maxPathologyLevel = 5
+)
+
+// DiseaseObservation is one scouting observation of a pathogen on a crop.
+// The image is a reference to the photo taken as evidence, the image hash its
+// hex encoded SHA-256 so the photo can be checked against the ledger.
+type DiseaseObservation struct {
+	ID                  string          `json:"id"`
+	Crop                string          `json:"crop"`
+	Field               string          `json:"field,omitempty"`
+	Location            GeoLocationType `json:"location"`
+	Pathogen            string          `json:"pathogen"`
+	Level               int             `json:"level"`
+	AffectedAreaPercent float64         `json:"affected_area_percent"`
+	ObservedAt          string          `json:"observed_at"`
+	Observer            string          `json:"observer"`
+	ObserverMSP         string          `json:"observer_msp"`
+	Image               string          `json:"image"`
+	ImageHash           string          `json:"image_hash,omitempty"`
+	TxID                string          `json:"tx_id"`
+}
+
+// PathogenLevelType is the latest observed level of one pathogen on a crop.
+type PathogenLevelType struct {
+	Level       int    `json:"level"`
+	ObservedAt  string `json:"observed_at"`
+	Observation string `json:"observation"`
+}
+
+// PathologyType is the current pathology of a crop. Its level is the highest
+// latest level of any pathogen, 0 while the crop was never scouted.
+type PathologyType struct {
+	Level     int                          `json:"level"`
+	Pathogens map[string]PathogenLevelType `json:"pathogens,omitempty"`
+}
+
+// ============================================================
+// recordDiseaseObservation - record a disease observation on a crop
+// ============================================================
+func (t *SimpleChaincode) recordDiseaseObservation(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0     1       2           3        4                  5              6           7            8        9
+	// "id", "crop", "pathogen", "level", "affected area %", "observed at", "latitude", "longitude", "image", "image sha256"
+	// level is the NTTU level 1-5. latitude and longitude may be empty to use
+	// the location of the crop, the image hash may be empty.
+	if len(args) != 10 {
+		return shim.Error("Incorrect number of arguments. Expecting 10")
+	}
+
+	fmt.Println("- start record disease observation")
+
+	observation, err := parseDiseaseObservation(stub, args)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = putAsset(stub, diseaseObservationIndexName, observation.ID, observation)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	indexKey, err := stub.CreateCompositeKey(cropDiseaseObservationIndexName, []string{observation.Crop, observation.ObservedAt, observation.ID})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = stub.PutState(indexKey, []byte{0x00})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if observation.Field != "" {
+		indexKey, err = stub.CreateCompositeKey(fieldDiseaseObservationIndexName, []string{observation.Field, observation.ObservedAt, observation.ID})
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		err = stub.PutState(indexKey, []byte{0x00})
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		err = addFieldEvent(stub, observation.Field, fieldEventDisease, observation.ID,
+			fmt.Sprintf("%s level %d", observation.Pathogen, observation.Level))
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	var conditions CropConditions
+	err = getCropAspect(stub, observation.Crop, conditionsPart, &conditions)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if applyDiseaseObservation(&conditions.Pathology, observation) {
+		err = putCropConditions(stub, observation.Crop, &conditions)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	fmt.Println("- end record disease observation (successful)")
+	return shim.Success(nil)
+}
+
+// ============================================================
+// readDiseaseObservation - read a disease observation from chaincode state
+// ============================================================
+func (t *SimpleChaincode) readDiseaseObservation(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting id of the observation to query")
+	}
+
+	var observation DiseaseObservation
+	err := getAsset(stub, diseaseObservationIndexName, args[0], &observation)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	observationJSONasBytes, err := json.Marshal(observation)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(observationJSONasBytes)
+}
+
+// ============================================================
+// diseaseHistory - list the disease observations of a crop or field inside a time window
+// ============================================================
+func (t *SimpleChaincode) diseaseHistory(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0                  1       2       3
+	// "crop" | "field", "name", "from", "to"
+	// from and to may be empty to leave that side of the query open
+	if len(args) != 4 {
+		return shim.Error("Incorrect number of arguments. Expecting 4")
+	}
+
+	var indexName string
+	switch args[0] {
+	case "crop":
+		indexName = cropDiseaseObservationIndexName
+	case "field":
+		indexName = fieldDiseaseObservationIndexName
+	default:
+		return shim.Error("disease history can be listed for a crop or a field")
+	}
+	from, to, err := parseTimeWindow(args[2], args[3])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	observations, err := getDiseaseObservations(stub, indexName, args[1], from, to)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	observationsJSON, err := json.Marshal(observations)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(observationsJSON)
+}
+
+// ============================================================
+// diseaseInRegion - list the disease observations of crops inside a bounding box
+// ============================================================
+func (t *SimpleChaincode) diseaseInRegion(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0              1               2              3               4       5
+	// "min latitude", "min longitude", "max latitude", "max longitude", "from", "to"
+	if len(args) != 6 {
+		return shim.Error("Incorrect number of arguments. Expecting 6")
+	}
+
+	southWest, err := parseGeoLocation(args[0], args[1])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	northEast, err := parseGeoLocation(args[2], args[3])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if southWest.Latitude > northEast.Latitude || southWest.Longitude > northEast.Longitude {
+		return shim.Error("bounding box corners must be south-west then north-east")
+	}
+	from, to, err := parseTimeWindow(args[4], args[5])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	crops, err := getCropsInBoundingBox(stub, southWest, northEast, false)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	observations := []DiseaseObservation{}
+	for _, crop := range crops {
+		cropObservations, err := getDiseaseObservations(stub, cropDiseaseObservationIndexName, crop.Crop, from, to)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		observations = append(observations, cropObservations...)
+	}
+	sort.Slice(observations, func(i, j int) bool {
+		if observations[i].ObservedAt != observations[j].ObservedAt {
+			return observations[i].ObservedAt < observations[j].ObservedAt
+		}
+		return observations[i].ID < observations[j].ID
+	})
+
+	observationsJSON, err := json.Marshal(observations)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(observationsJSON)
+}
+
+// parseDiseaseObservation validates the recordDiseaseObservation arguments.
+func parseDiseaseObservation(stub shim.ChaincodeStubInterface, args []string) (DiseaseObservation, error) {
+	observation := DiseaseObservation{
+		ID:        args[0],
+		Crop:      args[1],
+		Pathogen:  strings.ToLower(args[2]),
+		Image:     args[8],
+		ImageHash: strings.ToLower(args[9]),
+		TxID:      stub.GetTxID(),
+	}
+	if len(observation.ID) == 0 || len(observation.Pathogen) == 0 || len(observation.Image) == 0 {
+		return observation, fmt.Errorf("observation id, pathogen and image must be non-empty strings")
+	}
+	err := getAsset(stub, diseaseObservationIndexName, observation.ID, &DiseaseObservation{})
+	if err == nil {
+		return observation, fmt.Errorf("This disease observation already exists: %s", observation.ID)
+	}
+	if observation.ImageHash != "" {
+		hash, err := hex.DecodeString(observation.ImageHash)
+		if err != nil || len(hash) != 32 {
+			return observation, fmt.Errorf("image hash must be a hex encoded SHA-256 hash")
+		}
+	}
+
+	observation.Level, err = strconv.Atoi(args[3])
+	if err != nil || observation.Level < minPathologyLevel || observation.Level > maxPathologyLevel {
+		return observation, fmt.Errorf("level must be an NTTU level from %d to %d", minPathologyLevel, maxPathologyLevel)
+	}
+	observation.AffectedAreaPercent, err = strconv.ParseFloat(args[4], 64)
+	if err != nil || observation.AffectedAreaPercent < 0 || observation.AffectedAreaPercent > 100 {
+		return observation, fmt.Errorf("affected area must be a percentage")
+	}
+	observation.ObservedAt, err = parseReadingTime(args[5])
+	if err != nil {
+		return observation, err
+	}
+	now, err := txTimestamp(stub)
+	if err != nil {
+		return observation, err
+	}
+	if observation.ObservedAt > now {
+		return observation, fmt.Errorf("disease observations cannot be recorded in advance")
+	}
+
+	info, err := getCropInfo(stub, observation.Crop)
+	if err != nil {
+		return observation, err
+	}
+	observation.Field = info.Field
+	if args[6] == "" && args[7] == "" {
+		observation.Location, err = cropLocation(stub, info)
+	} else {
+		observation.Location, err = parseGeoLocation(args[6], args[7])
+	}
+	if err != nil {
+		return observation, err
+	}
+	if observation.Field != "" {
+		field, err := getField(stub, observation.Field)
+		if err != nil {
+			return observation, err
+		}
+		if field.Boundary != nil {
+			inside, _ := fieldContains(field, observation.Location)
+			if !inside {
+				return observation, fmt.Errorf("observation location lies outside field %s", field.ID)
+			}
+		}
+	}
+
+	observation.Observer, err = cid.GetID(stub)
+	if err != nil {
+		return observation, fmt.Errorf("Failed to get observer identity: %s", err.Error())
+	}
+	observation.ObserverMSP, err = cid.GetMSPID(stub)
+	if err != nil {
+		return observation, fmt.Errorf("Failed to get observer MSP: %s", err.Error())
+	}
+	return observation, nil
+}
+
+// applyDiseaseObservation updates the pathology of a crop with an observation.
+// Observations may arrive out of order, only one at least as new as the
+// latest of its pathogen replaces it. It reports whether the pathology changed.
+func applyDiseaseObservation(pathology *PathologyType, observation DiseaseObservation) bool {
+	latest, ok := pathology.Pathogens[observation.Pathogen]
+	if ok && observation.ObservedAt < latest.ObservedAt {
+		return false
+	}
+	if pathology.Pathogens == nil {
+		pathology.Pathogens = map[string]PathogenLevelType{}
+	}
+	pathology.Pathogens[observation.Pathogen] = PathogenLevelType{
+		Level:       observation.Level,
+		ObservedAt:  observation.ObservedAt,
+		Observation: observation.ID,
+	}
+	pathology.Level = 0
+	for _, pathogen := range pathology.Pathogens {
+		if pathogen.Level > pathology.Level {
+			pathology.Level = pathogen.Level
+		}
+	}
+	return true
+}
+
+// getDiseaseObservations returns the observations of a crop or field index in
+// observation order. Empty from/to bounds are open.
+func getDiseaseObservations(stub shim.ChaincodeStubInterface, indexName, name, from, to string) ([]DiseaseObservation, error) {
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(indexName, []string{name})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	observations := []DiseaseObservation{}
+	for resultsIterator.HasNext() {
+		responseRange, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		_, keyParts, err := stub.SplitCompositeKey(responseRange.Key)
+		if err != nil {
+			return nil, err
+		}
+		if from != "" && keyParts[1] < from {
+			continue
+		}
+		if to != "" && keyParts[1] > to {
+			break
+		}
+		var observation DiseaseObservation
+		err = getAsset(stub, diseaseObservationIndexName, keyParts[2], &observation)
+		if err != nil {
+			return nil, err
+		}
+		observations = append(observations, observation)
+	}
+	return observations, nil
+}
+
+// parseTimeWindow parses optional RFC3339 from and to bounds into readingTimeLayout.
+func parseTimeWindow(fromArg, toArg string) (string, string, error) {
+	var from, to string
+	var err error
+	if fromArg != "" {
+		if from, err = parseReadingTime(fromArg); err != nil {
+			return "", "", err
+		}
+	}
+	if toArg != "" {
+		if to, err = parseReadingTime(toArg); err != nil {
+			return "", "", err
+		}
+	}
+	return from, to, nil
+}
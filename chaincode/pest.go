@@ -0,0 +1,240 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/lib/cid"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+const (
+	pestObservationIndexName     = "pestobs"
+	cropPestObservationIndexName = "crop~observedat~pestobs"
+)
+
+// fieldEventPest is the field history kind of a pest observation.
+const fieldEventPest = "pest"
+
+// PestObservation is one scouting observation of a pest on a crop, graded by
+// the percentage of inspected plants found infested.
+type PestObservation struct {
+	ID              string  `json:"id"`
+	Crop            string  `json:"crop"`
+	Field           string  `json:"field,omitempty"`
+	Pest            string  `json:"pest"`
+	InfestedPercent float64 `json:"infested_percent"`
+	ObservedAt      string  `json:"observed_at"`
+	Observer        string  `json:"observer"`
+	ObserverMSP     string  `json:"observer_msp"`
+	TxID            string  `json:"tx_id"`
+}
+
+// PestLevelType is the latest observed infestation of one pest on a crop.
+type PestLevelType struct {
+	InfestedPercent float64 `json:"infested_percent"`
+	ObservedAt      string  `json:"observed_at"`
+	Observation     string  `json:"observation"`
+}
+
+// ============================================================
+// recordPestObservation - record a pest observation on a crop
+// ============================================================
+func (t *SimpleChaincode) recordPestObservation(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0     1       2       3                    4
+	// "id", "crop", "pest", "infested plants %", "observed at"
+	if len(args) != 5 {
+		return shim.Error("Incorrect number of arguments. Expecting 5")
+	}
+
+	fmt.Println("- start record pest observation")
+
+	observation, err := parsePestObservation(stub, args)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = putAsset(stub, pestObservationIndexName, observation.ID, observation)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	indexKey, err := stub.CreateCompositeKey(cropPestObservationIndexName, []string{observation.Crop, observation.ObservedAt, observation.ID})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = stub.PutState(indexKey, []byte{0x00})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if observation.Field != "" {
+		err = addFieldEvent(stub, observation.Field, fieldEventPest, observation.ID,
+			fmt.Sprintf("%s %g%% infested", observation.Pest, observation.InfestedPercent))
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	var conditions CropConditions
+	err = getCropAspect(stub, observation.Crop, conditionsPart, &conditions)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if applyPestObservation(&conditions, observation) {
+		err = putCropConditions(stub, observation.Crop, &conditions)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	fmt.Println("- end record pest observation (successful)")
+	return shim.Success(nil)
+}
+
+// ============================================================
+// pestObservationsOf - list the pest observations of a crop inside a time window
+// ============================================================
+func (t *SimpleChaincode) pestObservationsOf(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0       1       2
+	// "crop", "from", "to"
+	// from and to may be empty to leave that side of the query open
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 3")
+	}
+
+	from, to, err := parseTimeWindow(args[1], args[2])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	observations, err := getPestObservations(stub, args[0], from, to)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	observationsJSON, err := json.Marshal(observations)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(observationsJSON)
+}
+
+// parsePestObservation validates the recordPestObservation arguments.
+func parsePestObservation(stub shim.ChaincodeStubInterface, args []string) (PestObservation, error) {
+	observation := PestObservation{
+		ID:   args[0],
+		Crop: args[1],
+		Pest: strings.ToLower(args[2]),
+		TxID: stub.GetTxID(),
+	}
+	if len(observation.ID) == 0 || len(observation.Pest) == 0 {
+		return observation, fmt.Errorf("observation id and pest must be non-empty strings")
+	}
+	err := getAsset(stub, pestObservationIndexName, observation.ID, &PestObservation{})
+	if err == nil {
+		return observation, fmt.Errorf("This pest observation already exists: %s", observation.ID)
+	}
+	observation.InfestedPercent, err = strconv.ParseFloat(args[3], 64)
+	if err != nil || observation.InfestedPercent < 0 || observation.InfestedPercent > 100 {
+		return observation, fmt.Errorf("infested plants must be a percentage")
+	}
+	observation.ObservedAt, err = parseReadingTime(args[4])
+	if err != nil {
+		return observation, err
+	}
+	now, err := txTimestamp(stub)
+	if err != nil {
+		return observation, err
+	}
+	if observation.ObservedAt > now {
+		return observation, fmt.Errorf("pest observations cannot be recorded in advance")
+	}
+
+	info, err := getCropInfo(stub, observation.Crop)
+	if err != nil {
+		return observation, err
+	}
+	observation.Field = info.Field
+
+	observation.Observer, err = cid.GetID(stub)
+	if err != nil {
+		return observation, fmt.Errorf("Failed to get observer identity: %s", err.Error())
+	}
+	observation.ObserverMSP, err = cid.GetMSPID(stub)
+	if err != nil {
+		return observation, fmt.Errorf("Failed to get observer MSP: %s", err.Error())
+	}
+	return observation, nil
+}
+
+// applyPestObservation updates the pests of a crop with an observation. Only
+// an observation at least as new as the latest of its pest replaces it. It
+// reports whether the conditions changed.
+func applyPestObservation(conditions *CropConditions, observation PestObservation) bool {
+	latest, ok := conditions.Pests[observation.Pest]
+	if ok && observation.ObservedAt < latest.ObservedAt {
+		return false
+	}
+	if conditions.Pests == nil {
+		conditions.Pests = map[string]PestLevelType{}
+	}
+	conditions.Pests[observation.Pest] = PestLevelType{
+		InfestedPercent: observation.InfestedPercent,
+		ObservedAt:      observation.ObservedAt,
+		Observation:     observation.ID,
+	}
+	return true
+}
+
+// getPestObservations returns the pest observations of a crop in observation
+// order. Empty from/to bounds are open.
+func getPestObservations(stub shim.ChaincodeStubInterface, cropName, from, to string) ([]PestObservation, error) {
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(cropPestObservationIndexName, []string{cropName})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	observations := []PestObservation{}
+	for resultsIterator.HasNext() {
+		responseRange, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		_, keyParts, err := stub.SplitCompositeKey(responseRange.Key)
+		if err != nil {
+			return nil, err
+		}
+		if from != "" && keyParts[1] < from {
+			continue
+		}
+		if to != "" && keyParts[1] > to {
+			break
+		}
+		var observation PestObservation
+		err = getAsset(stub, pestObservationIndexName, keyParts[2], &observation)
+		if err != nil {
+			return nil, err
+		}
+		observations = append(observations, observation)
+	}
+	return observations, nil
+}
@@ -0,0 +1,346 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+const (
+	pesticideIndexName        = "pesticide"
+	cropPesticideAppIndexName = "crop~appliedat~pesticideapp"
+	regulatorAttribute        = "hssf.regulator"
+	pesticideUnitLitre        = "l"
+	pesticideUnitKilogram     = "kg"
+)
+
+// ActiveIngredientType is an active substance of a pesticide product, in
+// grams per litre or kilogram of product.
+type ActiveIngredientType struct {
+	Substance    string  `json:"substance"`
+	GramsPerUnit float64 `json:"grams_per_unit"`
+}
+
+// PesticideUseType is the approved use of a product on a crop species. The
+// maximum dose is in product units per hectare and application.
+type PesticideUseType struct {
+	MaxDosePerHectare      float64 `json:"max_dose_per_hectare"`
+	PreHarvestIntervalDays int     `json:"pre_harvest_interval_days"`
+}
+
+// PesticideProduct is a registered plant protection product. Uses are keyed by
+// crop species, a product can only be applied to the species listed.
+type PesticideProduct struct {
+	ID                string                      `json:"id"`
+	Name              string                      `json:"name"`
+	Manufacturer      string                      `json:"manufacturer"`
+	Unit              string                      `json:"unit"`
+	ActiveIngredients []ActiveIngredientType      `json:"active_ingredients"`
+	Uses              map[string]PesticideUseType `json:"uses"`
+	Approved          bool                        `json:"approved"`
+	Withdrawn         string                      `json:"withdrawn,omitempty"`
+}
+
+// PesticideApplication records one application of a registered product on a
+// crop. The crop cannot be harvested before SafeHarvestAt, which counts the
+// pre-harvest interval from when the application was recorded.
+type PesticideApplication struct {
+	Crop                   string                 `json:"crop"`
+	Product                string                 `json:"product"`
+	ActiveIngredients      []ActiveIngredientType `json:"active_ingredients"`
+	DosePerHectare         float64                `json:"dose_per_hectare"`
+	Unit                   string                 `json:"unit"`
+	AppliedAt              string                 `json:"applied_at"`
+	PreHarvestIntervalDays int                    `json:"pre_harvest_interval_days"`
+	SafeHarvestAt          string                 `json:"safe_harvest_at"`
+	TxID                   string                 `json:"tx_id"`
+}
+
+// ============================================================
+// registerPesticide - register or update an approved pesticide product
+// ============================================================
+func (t *SimpleChaincode) registerPesticide(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0     1       2               3       4                         5
+	// "id", "name", "manufacturer", "unit", "active ingredients json", "uses json"
+	// unit is l or kg, uses maps each crop species to its maximum dose and
+	// pre-harvest interval. Only identities carrying the hssf.regulator=true
+	// attribute may register products.
+	if len(args) != 6 {
+		return shim.Error("Incorrect number of arguments. Expecting 6")
+	}
+
+	fmt.Println("- start register pesticide")
+
+	err := requireRegulator(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	product := PesticideProduct{
+		ID:           args[0],
+		Name:         args[1],
+		Manufacturer: args[2],
+		Unit:         strings.ToLower(args[3]),
+		Approved:     true,
+	}
+	if len(product.ID) == 0 || len(product.Name) == 0 {
+		return shim.Error("pesticide id and name must be non-empty strings")
+	}
+	if product.Unit != pesticideUnitLitre && product.Unit != pesticideUnitKilogram {
+		return shim.Error("unit must be l or kg")
+	}
+	err = json.Unmarshal([]byte(args[4]), &product.ActiveIngredients)
+	if err != nil || len(product.ActiveIngredients) == 0 {
+		return shim.Error("active ingredients must be a non-empty JSON array of substance and grams_per_unit")
+	}
+	for _, ingredient := range product.ActiveIngredients {
+		if len(ingredient.Substance) == 0 || ingredient.GramsPerUnit <= 0 {
+			return shim.Error("active ingredients need a substance and a positive content")
+		}
+	}
+	err = json.Unmarshal([]byte(args[5]), &product.Uses)
+	if err != nil || len(product.Uses) == 0 {
+		return shim.Error("uses must be a non-empty JSON object of species to max_dose_per_hectare and pre_harvest_interval_days")
+	}
+	uses := map[string]PesticideUseType{}
+	for species, use := range product.Uses {
+		if use.MaxDosePerHectare <= 0 || use.PreHarvestIntervalDays < 0 {
+			return shim.Error("uses need a positive maximum dose and a non-negative pre-harvest interval: " + species)
+		}
+		species = strings.ToLower(species)
+		err = getAsset(stub, speciesIndexName, species, &SpeciesEntry{})
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		uses[species] = use
+	}
+	product.Uses = uses
+
+	err = putAsset(stub, pesticideIndexName, product.ID, product)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	fmt.Println("- end register pesticide (successful)")
+	return shim.Success(nil)
+}
+
+// ============================================================
+// withdrawPesticide - withdraw the approval of a pesticide product
+// ============================================================
+func (t *SimpleChaincode) withdrawPesticide(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0
+	// "id"
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	err := requireRegulator(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	product, err := getPesticide(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	timestamp, err := txTimestamp(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	product.Approved = false
+	product.Withdrawn = timestamp
+	err = putAsset(stub, pesticideIndexName, product.ID, product)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(nil)
+}
+
+// ============================================================
+// readPesticide - read a pesticide product from chaincode state
+// ============================================================
+func (t *SimpleChaincode) readPesticide(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting id of the pesticide to query")
+	}
+
+	product, err := getPesticide(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	productJSONasBytes, err := json.Marshal(product)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(productJSONasBytes)
+}
+
+// ============================================================
+// pesticideApplicationsOf - list the pesticide applications of a crop in time order
+// ============================================================
+func (t *SimpleChaincode) pesticideApplicationsOf(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting name of the crop to query")
+	}
+
+	applications, err := getPesticideApplications(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	applicationsJSON, err := json.Marshal(applications)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(applicationsJSON)
+}
+
+// parsePesticideApplication validates an application of a registered product
+// on a crop against the approved use for the crop species.
+func parsePesticideApplication(stub shim.ChaincodeStubInterface, cropName, productID, dose, appliedAt string) (PesticideApplication, error) {
+	application := PesticideApplication{
+		Crop:    cropName,
+		Product: productID,
+		TxID:    stub.GetTxID(),
+	}
+
+	info, err := getCropInfo(stub, cropName)
+	if err != nil {
+		return application, err
+	}
+	product, err := getPesticide(stub, productID)
+	if err != nil {
+		return application, err
+	}
+	if !product.Approved {
+		return application, fmt.Errorf("pesticide %s is not approved", product.ID)
+	}
+	use, ok := product.Uses[info.Species]
+	if !ok {
+		return application, fmt.Errorf("pesticide %s is not approved for %s", product.ID, info.Species)
+	}
+
+	application.DosePerHectare, err = strconv.ParseFloat(dose, 64)
+	if err != nil || application.DosePerHectare <= 0 {
+		return application, fmt.Errorf("dose per hectare must be a positive number")
+	}
+	if application.DosePerHectare > use.MaxDosePerHectare {
+		return application, fmt.Errorf("dose of %g %s/ha exceeds the maximum of %g %s/ha for %s",
+			application.DosePerHectare, product.Unit, use.MaxDosePerHectare, product.Unit, info.Species)
+	}
+
+	now, err := txTimestamp(stub)
+	if err != nil {
+		return application, err
+	}
+	application.AppliedAt = now
+	if appliedAt != "" {
+		application.AppliedAt, err = parseReadingTime(appliedAt)
+		if err != nil {
+			return application, err
+		}
+		if application.AppliedAt > now {
+			return application, fmt.Errorf("applications cannot be recorded in advance")
+		}
+	}
+	// the interval counts from the transaction, which is never before the
+	// application, so backdating an application cannot shorten it
+	recorded, _ := time.Parse(readingTimeLayout, now)
+
+	application.ActiveIngredients = product.ActiveIngredients
+	application.Unit = product.Unit
+	application.PreHarvestIntervalDays = use.PreHarvestIntervalDays
+	application.SafeHarvestAt = recorded.AddDate(0, 0, use.PreHarvestIntervalDays).Format(readingTimeLayout)
+	return application, nil
+}
+
+// putPesticideApplication stores an application under the crop, ordered by application time.
+func putPesticideApplication(stub shim.ChaincodeStubInterface, application PesticideApplication) error {
+	applicationKey, err := stub.CreateCompositeKey(cropPesticideAppIndexName, []string{application.Crop, application.AppliedAt, application.TxID})
+	if err != nil {
+		return err
+	}
+	applicationJSONasBytes, err := json.Marshal(application)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(applicationKey, applicationJSONasBytes)
+}
+
+// getPesticideApplications returns the pesticide applications of a crop in time order.
+func getPesticideApplications(stub shim.ChaincodeStubInterface, cropName string) ([]PesticideApplication, error) {
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(cropPesticideAppIndexName, []string{cropName})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	applications := []PesticideApplication{}
+	for resultsIterator.HasNext() {
+		responseRange, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		var application PesticideApplication
+		err = json.Unmarshal(responseRange.Value, &application)
+		if err != nil {
+			return nil, err
+		}
+		applications = append(applications, application)
+	}
+	return applications, nil
+}
+
+// checkPreHarvestIntervals fails while the pre-harvest interval of any
+// application on the crop is still running.
+func checkPreHarvestIntervals(stub shim.ChaincodeStubInterface, cropName string) error {
+	applications, err := getPesticideApplications(stub, cropName)
+	if err != nil {
+		return err
+	}
+	now, err := txTimestamp(stub)
+	if err != nil {
+		return err
+	}
+	for _, application := range applications {
+		if application.SafeHarvestAt > now {
+			return fmt.Errorf("pre-harvest interval of %s applied at %s runs until %s",
+				application.Product, application.AppliedAt, application.SafeHarvestAt)
+		}
+	}
+	return nil
+}
+
+// requireRegulator checks that the invoker may manage the pesticide registry.
+func requireRegulator(stub shim.ChaincodeStubInterface) error {
+	return requireAttribute(stub, regulatorAttribute, "only regulators may manage the pesticide registry")
+}
+
+func getPesticide(stub shim.ChaincodeStubInterface, productID string) (PesticideProduct, error) {
+	var product PesticideProduct
+	err := getAsset(stub, pesticideIndexName, productID, &product)
+	return product, err
+}
@@ -0,0 +1,238 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// days of recent heat accumulation used to project stage and harvest dates
+const phenologyProjectionDays = 14
+
+// PhenologyModel holds the growing degree day parameters of a catalog species. Stages
+// maps BBCH codes, e.g. "09" emergence or "61" beginning of flowering, to the
+// growing degree days accumulated since planting at which they are reached.
+type PhenologyModel struct {
+	BaseCelcius float64            `json:"base_celcius"`
+	CapCelcius  float64            `json:"cap_celcius"`
+	Stages      map[string]float64 `json:"bbch_stages_gdd"`
+	HarvestGDD  float64            `json:"harvest_gdd"`
+}
+
+// Phenology is the heat accumulation of a crop since planting and the
+// predicted BBCH stage and harvest date.
+type Phenology struct {
+	Crop               string  `json:"crop"`
+	Species            string  `json:"species"`
+	PlantedAt          string  `json:"planted_at"`
+	AccumulatedGDD     float64 `json:"accumulated_gdd"`
+	DaysWithReadings   int     `json:"days_with_readings"`
+	DaysWithoutReading int     `json:"days_without_readings"`
+	BBCHStage          string  `json:"bbch_stage"`
+	NextBBCHStage      string  `json:"next_bbch_stage,omitempty"`
+	NextStageEstimate  string  `json:"next_stage_estimate,omitempty"`
+	EstimatedHarvest   string  `json:"estimated_harvest,omitempty"`
+}
+
+// ============================================================
+// setPlantingDate - set the date heat accumulation of a crop starts from
+// ============================================================
+func (t *SimpleChaincode) setPlantingDate(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0       1
+	// "crop", "planted at"
+	// planted at is YYYY-MM-DD
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+
+	planted, err := time.Parse("2006-01-02", args[1])
+	if err != nil {
+		return shim.Error("planted at must be formatted as YYYY-MM-DD")
+	}
+	now, err := txTimestamp(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if planted.Format("2006-01-02") > now[:10] {
+		return shim.Error("planting date cannot be recorded in advance")
+	}
+	info, err := getCropInfo(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	info.PlantedAt = planted.Format("2006-01-02")
+
+	err = putCropInfo(stub, info)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(nil)
+}
+
+// ============================================================
+// phenologyOf - growing degree days, BBCH stage and harvest date of a crop
+// ============================================================
+func (t *SimpleChaincode) phenologyOf(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting name of the crop to query")
+	}
+
+	info, err := getCropInfo(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if info.PlantedAt == "" {
+		return shim.Error("planting date of crop " + info.Name + " is not set")
+	}
+	entry, err := getCatalogEntry(stub, info)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if entry.Phenology.HarvestGDD == 0 {
+		return shim.Error("catalog entry of species " + info.Species + " has no phenology model")
+	}
+	now, err := txTimestamp(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	readings, err := getReadings(stub, info.Name, "", info.PlantedAt+"T00:00:00Z", now)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	phenology := predictPhenology(entry.Phenology, info.PlantedAt, now, readings)
+	phenology.Crop = info.Name
+	phenology.Species = info.Species
+	phenologyJSON, err := json.Marshal(phenology)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(phenologyJSON)
+}
+
+// predictPhenology accumulates the daily growing degree days from planting up
+// to now and projects the next BBCH stage and the harvest date with the mean
+// daily heat of the last days with readings.
+func predictPhenology(model PhenologyModel, plantedAt, now string, readings []SensorReading) Phenology {
+	phenology := Phenology{PlantedAt: plantedAt}
+
+	type dayRange struct{ min, max float64 }
+	days := map[string]*dayRange{}
+	for _, reading := range readings {
+		day := reading.Timestamp[:10]
+		celcius := reading.Weather.Temperature.Celcius
+		if days[day] == nil {
+			days[day] = &dayRange{celcius, celcius}
+		}
+		days[day].min = math.Min(days[day].min, celcius)
+		days[day].max = math.Max(days[day].max, celcius)
+	}
+	var dates []string
+	for day := range days {
+		dates = append(dates, day)
+	}
+	sort.Strings(dates)
+
+	var daily []float64
+	for _, day := range dates {
+		gdd := growingDegreeDays(days[day].min, days[day].max, model.BaseCelcius, model.CapCelcius)
+		phenology.AccumulatedGDD += gdd
+		daily = append(daily, gdd)
+	}
+	phenology.AccumulatedGDD = roundDecimals(phenology.AccumulatedGDD, 2)
+	phenology.DaysWithReadings = len(dates)
+	planted, _ := time.Parse("2006-01-02", plantedAt)
+	today, _ := time.Parse(readingTimeLayout, now)
+	elapsed := int(today.Sub(planted).Hours()/24) + 1
+	phenology.DaysWithoutReading = int(math.Max(0, float64(elapsed-len(dates))))
+
+	var codes []string
+	for code := range model.Stages {
+		codes = append(codes, code)
+	}
+	// stages reached at the same GDD are ordered by their BBCH code, so the
+	// stage does not depend on map order
+	sort.Slice(codes, func(i, j int) bool {
+		if model.Stages[codes[i]] != model.Stages[codes[j]] {
+			return model.Stages[codes[i]] < model.Stages[codes[j]]
+		}
+		return codes[i] < codes[j]
+	})
+	for _, code := range codes {
+		if model.Stages[code] <= phenology.AccumulatedGDD {
+			phenology.BBCHStage = code
+		} else if phenology.NextBBCHStage == "" {
+			phenology.NextBBCHStage = code
+		}
+	}
+	if phenology.BBCHStage == "" {
+		// before the first configured stage the crop is still being sown
+		phenology.BBCHStage = "00"
+	}
+
+	recent := daily
+	if len(recent) > phenologyProjectionDays {
+		recent = recent[len(recent)-phenologyProjectionDays:]
+	}
+	mean := 0.0
+	for _, gdd := range recent {
+		mean += gdd
+	}
+	if len(recent) > 0 {
+		mean /= float64(len(recent))
+	}
+	project := func(target float64) string {
+		remaining := target - phenology.AccumulatedGDD
+		if remaining <= 0 {
+			return today.Format("2006-01-02")
+		}
+		if mean <= 0 {
+			return ""
+		}
+		return today.AddDate(0, 0, int(math.Ceil(remaining/mean))).Format("2006-01-02")
+	}
+	if phenology.NextBBCHStage != "" {
+		phenology.NextStageEstimate = project(model.Stages[phenology.NextBBCHStage])
+	}
+	phenology.EstimatedHarvest = project(model.HarvestGDD)
+	return phenology
+}
+
+// growingDegreeDays of one day, with the daily extremes limited to the cap
+// temperature and the minimum raised to the base temperature.
+func growingDegreeDays(minCelcius, maxCelcius, baseCelcius, capCelcius float64) float64 {
+	maxCelcius = math.Min(maxCelcius, capCelcius)
+	minCelcius = math.Max(math.Min(minCelcius, capCelcius), baseCelcius)
+	return math.Max(0, (maxCelcius+minCelcius)/2-baseCelcius)
+}
+
+// validateBBCHCode checks a two digit BBCH code.
+func validateBBCHCode(code string) error {
+	if len(code) != 2 || code[0] < '0' || code[0] > '9' || code[1] < '0' || code[1] > '9' {
+		return fmt.Errorf("BBCH codes have two digits: %q", code)
+	}
+	return nil
+}
@@ -0,0 +1,228 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+const (
+	residueTestIndexName     = "residuetest"
+	cropResidueTestIndexName = "crop~sampledat~residuetest"
+)
+
+// ResidueTest is the lab result of a pesticide residue analysis of a crop
+// sample. Residues are keyed by active substance in mg/kg.
+type ResidueTest struct {
+	ID          string             `json:"id"`
+	Crop        string             `json:"crop"`
+	SampledAt   string             `json:"sampled_at"`
+	Lab         string             `json:"lab"`
+	Residues    map[string]float64 `json:"residues_mg_kg"`
+	Valid       bool               `json:"valid"`
+	Invalidated string             `json:"invalidated,omitempty"`
+}
+
+// ============================================================
+// submitResidueTest - store the lab result of a residue analysis
+// ============================================================
+func (t *SimpleChaincode) submitResidueTest(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0     1       2             3      4
+	// "id", "crop", "sampled at", "lab", "residues json"
+	// The lab is the id of an accredited lab and the invoker must belong to its MSP.
+	if len(args) != 5 {
+		return shim.Error("Incorrect number of arguments. Expecting 5")
+	}
+
+	fmt.Println("- start submit residue test")
+
+	test := ResidueTest{
+		ID:       args[0],
+		Crop:     args[1],
+		Lab:      args[3],
+		Residues: map[string]float64{},
+		Valid:    true,
+	}
+	if len(test.ID) == 0 {
+		return shim.Error("1st argument must be a non-empty string")
+	}
+	err := getAsset(stub, residueTestIndexName, test.ID, &ResidueTest{})
+	if err == nil {
+		return shim.Error("This residue test already exists: " + test.ID)
+	}
+	_, err = getCropBase(stub, test.Crop)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	test.SampledAt, err = parseReadingTime(args[2])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	now, err := txTimestamp(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if test.SampledAt > now {
+		return shim.Error("residue test cannot be recorded in advance")
+	}
+	err = json.Unmarshal([]byte(args[4]), &test.Residues)
+	if err != nil {
+		return shim.Error("residues must be a JSON object of active substance to mg/kg")
+	}
+	for substance, residue := range test.Residues {
+		if len(substance) == 0 || residue < 0 {
+			return shim.Error("residues must be non-negative values of named substances")
+		}
+	}
+	lab, err := requireSubmittingLab(stub, test.Lab)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = putAsset(stub, residueTestIndexName, test.ID, test)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	indexKey, err := stub.CreateCompositeKey(cropResidueTestIndexName, []string{test.Crop, test.SampledAt, test.ID})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = stub.PutState(indexKey, []byte{0x00})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	testJSONasBytes, err := json.Marshal(test)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = certifyLabResult(stub, lab, labResultKindResidue, test.ID, testJSONasBytes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	fmt.Println("- end submit residue test (successful)")
+	return shim.Success(nil)
+}
+
+// ============================================================
+// invalidateResidueTest - withdraw a residue test, e.g. after a lab error
+// ============================================================
+func (t *SimpleChaincode) invalidateResidueTest(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0     1
+	// "id", "reason"
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+
+	var test ResidueTest
+	err := getAsset(stub, residueTestIndexName, args[0], &test)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if !test.Valid {
+		return shim.Error("residue test is already invalid: " + test.ID)
+	}
+	if len(args[1]) == 0 {
+		return shim.Error("reason must be a non-empty string")
+	}
+	_, err = requireLabMSP(stub, test.Lab)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	test.Valid = false
+	test.Invalidated = args[1]
+	err = putAsset(stub, residueTestIndexName, test.ID, test)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(nil)
+}
+
+// ============================================================
+// readResidueTest - read a residue test from chaincode state
+// ============================================================
+func (t *SimpleChaincode) readResidueTest(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting id of the residue test to query")
+	}
+
+	var test ResidueTest
+	err := getAsset(stub, residueTestIndexName, args[0], &test)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	testJSONasBytes, err := json.Marshal(test)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(testJSONasBytes)
+}
+
+// ============================================================
+// residueTestsOf - list the residue tests of a crop in sampling order
+// ============================================================
+func (t *SimpleChaincode) residueTestsOf(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting name of the crop to query")
+	}
+
+	tests, err := getResidueTests(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	testsJSON, err := json.Marshal(tests)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(testsJSON)
+}
+
+// getResidueTests returns the residue tests of a crop in sampling order.
+func getResidueTests(stub shim.ChaincodeStubInterface, cropName string) ([]ResidueTest, error) {
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(cropResidueTestIndexName, []string{cropName})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	tests := []ResidueTest{}
+	for resultsIterator.HasNext() {
+		responseRange, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		_, keyParts, err := stub.SplitCompositeKey(responseRange.Key)
+		if err != nil {
+			return nil, err
+		}
+		var test ResidueTest
+		err = getAsset(stub, residueTestIndexName, keyParts[2], &test)
+		if err != nil {
+			return nil, err
+		}
+		tests = append(tests, test)
+	}
+	return tests, nil
+}
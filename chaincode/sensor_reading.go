@@ -0,0 +1,259 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// readingIndexName is the composite key object type for sensor readings.
+// Readings are keyed reading~crop~sensor~timestamp so that all readings of a
+// crop, or of one sensor on a crop, can be fetched in time order. Manual
+// readings, which updateCrop stored before readings had to be signed, carry
+// the transaction ID as a fourth attribute.
+const readingIndexName = "reading~crop~sensor~timestamp"
+
+// readingTimeLayout is the fixed width UTC layout used for reading timestamps.
+// Keeping every timestamp the same width makes the lexical key order match the
+// chronological order.
+const readingTimeLayout = "2006-01-02T15:04:05Z"
+
+// SensorReading is one timestamped reading of a single sensor for a crop.
+type SensorReading struct {
+	Crop          string            `json:"crop"`
+	Sensor        string            `json:"sensor"`
+	Timestamp     string            `json:"timestamp"`
+	Weather       WeatherType       `json:"weather"`
+	SoilCondition SoilConditionType `json:"soil_condition"`
+	Nonce         string            `json:"nonce,omitempty"`
+	Signature     string            `json:"signature,omitempty"`
+}
+
+// LatestReadingType summarises the most recent reading stored for a crop.
+type LatestReadingType struct {
+	Sensor    string `json:"sensor"`
+	Timestamp string `json:"timestamp"`
+}
+
+// ============================================================
+// queryReadings - return the readings of a crop inside a time window
+// ============================================================
+func (t *SimpleChaincode) queryReadings(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0       1         2       3
+	// "crop", "sensor", "from", "to"
+	// sensor, from and to may be empty to leave that side of the query open.
+	if len(args) != 4 {
+		return shim.Error("Incorrect number of arguments. Expecting 4")
+	}
+
+	var from, to string
+	var err error
+	if args[2] != "" {
+		if from, err = parseReadingTime(args[2]); err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+	if args[3] != "" {
+		if to, err = parseReadingTime(args[3]); err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	readings, err := getReadings(stub, args[0], args[1], from, to)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	readingsJSON, err := json.Marshal(readings)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(readingsJSON)
+}
+
+// putReading stores the reading under its reading~crop~sensor~timestamp key.
+func putReading(stub shim.ChaincodeStubInterface, reading SensorReading) error {
+	readingKey, err := stub.CreateCompositeKey(readingIndexName, []string{reading.Crop, reading.Sensor, reading.Timestamp})
+	if err != nil {
+		return err
+	}
+	existing, err := stub.GetState(readingKey)
+	if err != nil {
+		return fmt.Errorf("Failed to get reading: %s", err.Error())
+	} else if existing != nil {
+		return fmt.Errorf("Reading already exists for sensor %s at %s", reading.Sensor, reading.Timestamp)
+	}
+
+	readingJSONasBytes, err := json.Marshal(reading)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(readingKey, readingJSONasBytes)
+}
+
+// applyLatestReading copies the reading into the crop's latest reading summary.
+// Readings may arrive out of order, so only a reading at least as new as the
+// current summary replaces it. It reports whether the conditions were changed.
+func applyLatestReading(conditions *CropConditions, reading SensorReading) bool {
+	if reading.Timestamp < conditions.LatestReading.Timestamp {
+		return false
+	}
+	conditions.Weather = reading.Weather
+	if conditions.SoilTest.ID != "" {
+		// the rest of the soil condition is derived from the latest lab soil test
+		conditions.SoilCondition.Moisture = reading.SoilCondition.Moisture
+	} else {
+		conditions.SoilCondition = reading.SoilCondition
+	}
+	conditions.LatestReading = LatestReadingType{
+		Sensor:    reading.Sensor,
+		Timestamp: reading.Timestamp,
+	}
+	return true
+}
+
+// getLatestReading returns the reading a crop's latest reading summary points
+// to, or nil when the crop has no reading.
+func getLatestReading(stub shim.ChaincodeStubInterface, cropName string, latest LatestReadingType) (*SensorReading, error) {
+	if latest.Timestamp == "" {
+		return nil, nil
+	}
+	// manual readings have the transaction ID as one more key attribute
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(readingIndexName, []string{cropName, latest.Sensor, latest.Timestamp})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	var reading *SensorReading
+	for resultsIterator.HasNext() {
+		responseRange, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		reading = &SensorReading{}
+		err = json.Unmarshal(responseRange.Value, reading)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return reading, nil
+}
+
+// getReadings returns the readings of a crop ordered by sensor and time.
+// An empty sensor selects every sensor, empty from/to bounds are open.
+func getReadings(stub shim.ChaincodeStubInterface, cropName, sensor, from, to string) ([]SensorReading, error) {
+	attributes := []string{cropName}
+	if sensor != "" {
+		attributes = append(attributes, sensor)
+	}
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(readingIndexName, attributes)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	readings := []SensorReading{}
+	for resultsIterator.HasNext() {
+		responseRange, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		_, keyParts, err := stub.SplitCompositeKey(responseRange.Key)
+		if err != nil {
+			return nil, err
+		}
+		timestamp := keyParts[2]
+		if from != "" && timestamp < from {
+			continue
+		}
+		if to != "" && timestamp > to {
+			// keys of a single sensor are in time order, nothing later can match
+			if sensor != "" {
+				break
+			}
+			continue
+		}
+
+		var reading SensorReading
+		err = json.Unmarshal(responseRange.Value, &reading)
+		if err != nil {
+			return nil, err
+		}
+		readings = append(readings, reading)
+	}
+	return readings, nil
+}
+
+// parseReadingTime parses an RFC3339 timestamp and returns it in readingTimeLayout.
+func parseReadingTime(value string) (string, error) {
+	parsed, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return "", fmt.Errorf("timestamp must be RFC3339: %s", value)
+	}
+	return parsed.UTC().Format(readingTimeLayout), nil
+}
+
+// txTimestamp returns the transaction timestamp in readingTimeLayout.
+func txTimestamp(stub shim.ChaincodeStubInterface) (string, error) {
+	ts, err := stub.GetTxTimestamp()
+	if err != nil {
+		return "", err
+	}
+	return time.Unix(ts.Seconds, int64(ts.Nanos)).UTC().Format(readingTimeLayout), nil
+}
+
+// parseConditions parses the weather and soil values in the order
+// celcius, pascal, humidity, radiation, moisture, ph, nitrogen, phosphorus.
+func parseConditions(args []string) (WeatherType, SoilConditionType, error) {
+	var weather WeatherType
+	var soil SoilConditionType
+	var values [8]float64
+
+	for i, arg := range args {
+		if i == 5 {
+			continue
+		}
+		value, err := strconv.ParseFloat(arg, 64)
+		if err != nil {
+			return weather, soil, fmt.Errorf("Unable to parse reading value %q", arg)
+		}
+		values[i] = value
+	}
+	ph, err := strconv.Atoi(args[5])
+	if err != nil {
+		return weather, soil, fmt.Errorf("Unable to parse ph %q", args[5])
+	}
+
+	weather.Temperature.Celcius = values[0]
+	weather.Pressure.Pascal = values[1]
+	weather.Humidity.CubicMeter = values[2]
+	weather.Radiation.Rem = values[3]
+	soil.Moisture.CubicMeter = values[4]
+	soil.Ph = ph
+	soil.Nitrogen.Percentage = values[6]
+	soil.Phosphorus.Percentage = values[7]
+	return weather, soil, nil
+}
@@ -0,0 +1,210 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package chaincode
+
+import (
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"encoding/asn1"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// deviceNonceIndexName records every nonce a device has used so a signed
+// payload cannot be submitted twice.
+const deviceNonceIndexName = "device~nonce"
+
+// deviceLastReadingIndexName keeps the timestamp of the last signed reading of
+// a device, later payloads must carry a newer timestamp.
+const deviceLastReadingIndexName = "device~lastreading"
+
+// ecdsaSignature is the ASN.1 structure of a DER encoded ECDSA signature.
+type ecdsaSignature struct {
+	R, S *big.Int
+}
+
+// ============================================================
+// submitSignedReading - store a reading signed by the device that produced it
+// ============================================================
+func (t *SimpleChaincode) submitSignedReading(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0       1         2            3        4-11                                                                              12
+	// "crop", "device", "timestamp", "nonce", "celcius", "pascal", "humidity", "radiation", "moisture", "ph", "nitrogen", "phosphorus", "signature"
+	//
+	// The signature is a base64 DER encoded ECDSA signature over the SHA-256 of
+	// canonicalReading, made with the key the device was registered with.
+	if len(args) != 13 {
+		return shim.Error("Incorrect number of arguments. Expecting 13")
+	}
+
+	fmt.Println("- start submit signed reading")
+
+	cropName := args[0]
+	nonce := args[3]
+	if len(nonce) == 0 {
+		return shim.Error("nonce must be a non-empty string")
+	}
+	device, err := checkDeviceForCrop(stub, args[1], cropName)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	timestamp, err := parseReadingTime(args[2])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	now, err := txTimestamp(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if timestamp > now {
+		// a future reading would stay the latest reading of the crop
+		return shim.Error("reading cannot be recorded in advance")
+	}
+	weather, soil, err := parseConditions(args[4:12])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	reading := SensorReading{
+		Crop:          cropName,
+		Sensor:        device.ID,
+		Timestamp:     timestamp,
+		Weather:       weather,
+		SoilCondition: soil,
+		Nonce:         nonce,
+		Signature:     args[12],
+	}
+	err = verifyReadingSignature(device, reading)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// ==== Reject replayed payloads ====
+	nonceKey, err := stub.CreateCompositeKey(deviceNonceIndexName, []string{device.ID, nonce})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	nonceAsBytes, err := stub.GetState(nonceKey)
+	if err != nil {
+		return shim.Error("Failed to get nonce: " + err.Error())
+	} else if nonceAsBytes != nil {
+		return shim.Error("nonce already used by device " + device.ID + ": " + nonce)
+	}
+	lastReadingKey, err := stub.CreateCompositeKey(deviceLastReadingIndexName, []string{device.ID})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	lastReadingAsBytes, err := stub.GetState(lastReadingKey)
+	if err != nil {
+		return shim.Error("Failed to get last reading: " + err.Error())
+	} else if lastReadingAsBytes != nil && timestamp <= string(lastReadingAsBytes) {
+		return shim.Error("reading timestamp " + timestamp + " is not newer than the last reading of device " + device.ID)
+	}
+
+	var conditions CropConditions
+	err = getCropAspect(stub, cropName, conditionsPart, &conditions)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = putReading(stub, reading)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = stub.PutState(nonceKey, []byte{0x00})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = stub.PutState(lastReadingKey, []byte(timestamp))
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = applyFrostRisk(stub, &conditions, reading)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if applyLatestReading(&conditions, reading) {
+		err = putCropConditions(stub, cropName, &conditions)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	fmt.Println("- end submit signed reading (successful)")
+	return shim.Success(nil)
+}
+
+// canonicalReading is the byte encoding a device signs. The fields are joined
+// with '|' in the order crop, device, timestamp, nonce, celcius, pascal,
+// humidity, radiation, moisture, ph, nitrogen, phosphorus. The timestamp uses
+// readingTimeLayout and numbers are written in their shortest decimal form,
+// e.g. 35 rather than 35.0.
+func canonicalReading(reading SensorReading) []byte {
+	formatFloat := func(value float64) string {
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	}
+	fields := []string{
+		reading.Crop,
+		reading.Sensor,
+		reading.Timestamp,
+		reading.Nonce,
+		formatFloat(reading.Weather.Temperature.Celcius),
+		formatFloat(reading.Weather.Pressure.Pascal),
+		formatFloat(reading.Weather.Humidity.CubicMeter),
+		formatFloat(reading.Weather.Radiation.Rem),
+		formatFloat(reading.SoilCondition.Moisture.CubicMeter),
+		strconv.Itoa(reading.SoilCondition.Ph),
+		formatFloat(reading.SoilCondition.Nitrogen.Percentage),
+		formatFloat(reading.SoilCondition.Phosphorus.Percentage),
+	}
+	return []byte(strings.Join(fields, "|"))
+}
+
+// verifyReadingSignature checks the reading signature against the public key
+// the device was registered with.
+func verifyReadingSignature(device Device, reading SensorReading) error {
+	publicKey, err := parsePublicKey(device.PublicKey)
+	if err != nil {
+		return err
+	}
+	ecdsaKey, ok := publicKey.(*ecdsa.PublicKey)
+	if !ok {
+		return fmt.Errorf("device %s is not registered with an ECDSA key", device.ID)
+	}
+
+	der, err := base64.StdEncoding.DecodeString(reading.Signature)
+	if err != nil {
+		return fmt.Errorf("signature must be base64 encoded")
+	}
+	var signature ecdsaSignature
+	rest, err := asn1.Unmarshal(der, &signature)
+	if err != nil || len(rest) != 0 || signature.R == nil || signature.S == nil {
+		return fmt.Errorf("signature must be a DER encoded ECDSA signature")
+	}
+
+	digest := sha256.Sum256(canonicalReading(reading))
+	if !ecdsa.Verify(ecdsaKey, digest[:], signature.R, signature.S) {
+		return fmt.Errorf("invalid signature for reading of device %s", device.ID)
+	}
+	return nil
+}
@@ -0,0 +1,472 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+const (
+	soilTestIndexName      = "soiltest"
+	cropSoilTestIndexName  = "crop~sampledat~soiltest"
+	fieldSoilTestIndexName = "field~sampledat~soiltest"
+)
+
+// fieldEventSoilTest is the field history kind of a soil test sampled on the field.
+const fieldEventSoilTest = "soiltest"
+
+// PotassiumType is the potassium content of the soil.
+type PotassiumType struct {
+	Percentage float64 `json:"percentage"`
+}
+
+// NutrientPanelType is the full nutrient panel of a soil sample. Micronutrients
+// are keyed by element name, e.g. zinc, iron, manganese, copper, boron, in mg/kg.
+type NutrientPanelType struct {
+	Ph                     float64            `json:"ph"`
+	Nitrogen               NitrogenType       `json:"nitrogen"`
+	Phosphorus             PhosphorusType     `json:"phosphorus"`
+	Potassium              PotassiumType      `json:"potassium"`
+	OrganicMatterPercent   float64            `json:"organic_matter_percent"`
+	ElectricalConductivity float64            `json:"electrical_conductivity_ds_m"`
+	CationExchangeCapacity float64            `json:"cation_exchange_capacity_cmol_kg"`
+	Micronutrients         map[string]float64 `json:"micronutrients_mg_kg"`
+}
+
+// SoilTest is the lab result of one geolocated soil sample.
+type SoilTest struct {
+	ID          string            `json:"id"`
+	Field       string            `json:"field,omitempty"`
+	Crop        string            `json:"crop,omitempty"`
+	Location    GeoLocationType   `json:"location"`
+	SampledAt   string            `json:"sampled_at"`
+	DepthCm     float64           `json:"depth_cm"`
+	Lab         string            `json:"lab"`
+	Nutrients   NutrientPanelType `json:"nutrients"`
+	Valid       bool              `json:"valid"`
+	Invalidated string            `json:"invalidated,omitempty"`
+}
+
+// SoilTestSummaryType references the soil test a crop soil condition is derived from.
+type SoilTestSummaryType struct {
+	ID        string `json:"id,omitempty"`
+	SampledAt string `json:"sampled_at,omitempty"`
+	Lab       string `json:"lab,omitempty"`
+}
+
+// ============================================================
+// submitSoilTest - store the lab result of a soil sample
+// ============================================================
+func (t *SimpleChaincode) submitSoilTest(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0     1        2       3           4            5             6            7
+	// "id", "field", "crop", "latitude", "longitude", "sampled at", "depth cm", "lab",
+	//   8     9           10            11           12                13       14      15
+	// "ph", "nitrogen", "phosphorus", "potassium", "organic matter", "ec",    "cec",  "micronutrients json"
+	// field or crop may be empty, but not both. A crop on a field is tested on its field.
+	// The lab is the id of an accredited lab and the invoker must belong to its MSP.
+	if len(args) != 16 {
+		return shim.Error("Incorrect number of arguments. Expecting 16")
+	}
+
+	fmt.Println("- start submit soil test")
+
+	test, err := parseSoilTest(stub, args)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	lab, err := requireSubmittingLab(stub, test.Lab)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = putSoilTest(stub, test)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	testJSONasBytes, err := json.Marshal(test)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = certifyLabResult(stub, lab, labResultKindSoil, test.ID, testJSONasBytes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	fmt.Println("- end submit soil test (successful)")
+	return shim.Success(nil)
+}
+
+// ============================================================
+// invalidateSoilTest - withdraw a soil test, e.g. after a lab error
+// ============================================================
+func (t *SimpleChaincode) invalidateSoilTest(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0     1
+	// "id", "reason"
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+
+	fmt.Println("- start invalidate soil test", args[0])
+
+	var test SoilTest
+	err := getAsset(stub, soilTestIndexName, args[0], &test)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if !test.Valid {
+		return shim.Error("soil test is already invalid: " + test.ID)
+	}
+	_, err = requireLabMSP(stub, test.Lab)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if len(args[1]) == 0 {
+		return shim.Error("reason must be a non-empty string")
+	}
+
+	test.Valid = false
+	test.Invalidated = args[1]
+	err = putAsset(stub, soilTestIndexName, test.ID, test)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = refreshSoilTestCrops(stub, test)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	fmt.Println("- end invalidate soil test (successful)")
+	return shim.Success(nil)
+}
+
+// ============================================================
+// readSoilTest - read a soil test from chaincode state
+// ============================================================
+func (t *SimpleChaincode) readSoilTest(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting id of the soil test to query")
+	}
+
+	var test SoilTest
+	err := getAsset(stub, soilTestIndexName, args[0], &test)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	testJSONasBytes, err := json.Marshal(test)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(testJSONasBytes)
+}
+
+// ============================================================
+// soilTestsOf - list the soil tests of a crop or a field in sampling order
+// ============================================================
+func (t *SimpleChaincode) soilTestsOf(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0                  1
+	// "crop" | "field", "name"
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+
+	var indexName string
+	switch args[0] {
+	case "crop":
+		indexName = cropSoilTestIndexName
+	case "field":
+		indexName = fieldSoilTestIndexName
+	default:
+		return shim.Error("soil tests can be listed for a crop or a field")
+	}
+
+	tests, err := getSoilTests(stub, indexName, args[1])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	testsJSON, err := json.Marshal(tests)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(testsJSON)
+}
+
+// parseSoilTest validates the submitSoilTest arguments into a SoilTest.
+func parseSoilTest(stub shim.ChaincodeStubInterface, args []string) (SoilTest, error) {
+	test := SoilTest{
+		ID:    args[0],
+		Field: args[1],
+		Crop:  args[2],
+		Lab:   args[7],
+		Valid: true,
+	}
+	if len(test.ID) == 0 || len(test.Lab) == 0 {
+		return test, fmt.Errorf("soil test id and lab must be non-empty strings")
+	}
+	err := getAsset(stub, soilTestIndexName, test.ID, &SoilTest{})
+	if err == nil {
+		return test, fmt.Errorf("This soil test already exists: %s", test.ID)
+	}
+
+	if test.Crop != "" {
+		var info CropInfo
+		cropAsBytes, err := getCropBase(stub, test.Crop)
+		if err != nil {
+			return test, err
+		}
+		if err = json.Unmarshal(cropAsBytes, &info); err != nil {
+			return test, err
+		}
+		if test.Field == "" {
+			test.Field = info.Field
+		} else if info.Field != test.Field {
+			return test, fmt.Errorf("crop %s is not on field %s", test.Crop, test.Field)
+		}
+	}
+	if test.Crop == "" && test.Field == "" {
+		return test, fmt.Errorf("a soil test needs a crop or a field")
+	}
+
+	test.Location, err = parseGeoLocation(args[3], args[4])
+	if err != nil {
+		return test, err
+	}
+	if test.Field != "" {
+		field, err := getField(stub, test.Field)
+		if err != nil {
+			return test, err
+		}
+		if field.Boundary != nil {
+			inside, _ := fieldContains(field, test.Location)
+			if !inside {
+				return test, fmt.Errorf("sample location lies outside field %s", field.ID)
+			}
+		}
+	}
+	test.SampledAt, err = parseReadingTime(args[5])
+	if err != nil {
+		return test, err
+	}
+	now, err := txTimestamp(stub)
+	if err != nil {
+		return test, err
+	}
+	if test.SampledAt > now {
+		return test, fmt.Errorf("soil test cannot be recorded in advance")
+	}
+
+	var values [8]float64
+	for i, arg := range []string{args[6], args[8], args[9], args[10], args[11], args[12], args[13], args[14]} {
+		values[i], err = strconv.ParseFloat(arg, 64)
+		if err != nil || values[i] < 0 {
+			return test, fmt.Errorf("soil test values must be non-negative numbers: %q", arg)
+		}
+	}
+	if values[1] > 14 {
+		return test, fmt.Errorf("ph must be between 0 and 14")
+	}
+	test.DepthCm = values[0]
+	test.Nutrients = NutrientPanelType{
+		Ph:                     values[1],
+		Nitrogen:               NitrogenType{Percentage: values[2]},
+		Phosphorus:             PhosphorusType{Percentage: values[3]},
+		Potassium:              PotassiumType{Percentage: values[4]},
+		OrganicMatterPercent:   values[5],
+		ElectricalConductivity: values[6],
+		CationExchangeCapacity: values[7],
+		Micronutrients:         map[string]float64{},
+	}
+	if args[15] != "" {
+		err = json.Unmarshal([]byte(args[15]), &test.Nutrients.Micronutrients)
+		if err != nil {
+			return test, fmt.Errorf("micronutrients must be a JSON object of element to mg/kg")
+		}
+	}
+	return test, nil
+}
+
+// putSoilTest stores a new soil test, indexes it by crop and field and
+// refreshes the soil condition of the crops it applies to.
+func putSoilTest(stub shim.ChaincodeStubInterface, test SoilTest) error {
+	err := putAsset(stub, soilTestIndexName, test.ID, test)
+	if err != nil {
+		return err
+	}
+
+	if test.Field != "" {
+		indexKey, err := stub.CreateCompositeKey(fieldSoilTestIndexName, []string{test.Field, test.SampledAt, test.ID})
+		if err != nil {
+			return err
+		}
+		if err = stub.PutState(indexKey, []byte{0x00}); err != nil {
+			return err
+		}
+		if err = addFieldEvent(stub, test.Field, fieldEventSoilTest, test.ID, test.Lab); err != nil {
+			return err
+		}
+	}
+	if test.Crop != "" {
+		indexKey, err := stub.CreateCompositeKey(cropSoilTestIndexName, []string{test.Crop, test.SampledAt, test.ID})
+		if err != nil {
+			return err
+		}
+		if err = stub.PutState(indexKey, []byte{0x00}); err != nil {
+			return err
+		}
+	}
+	return refreshSoilTestCrops(stub, test)
+}
+
+// refreshSoilTestCrops derives the soil condition again of the tested crop
+// and of the growing crops on the tested field, a test of the field is the
+// latest test of each crop on it that has no valid test of its own.
+func refreshSoilTestCrops(stub shim.ChaincodeStubInterface, test SoilTest) error {
+	var crops []CropInfo
+	if test.Field != "" {
+		fieldCrops, err := getFieldCrops(stub, test.Field)
+		if err != nil {
+			return err
+		}
+		crops = fieldCrops
+	}
+	if test.Crop != "" {
+		found := false
+		for _, info := range crops {
+			found = found || info.Name == test.Crop
+		}
+		if !found {
+			info, err := getCropInfo(stub, test.Crop)
+			if err != nil {
+				return err
+			}
+			crops = append(crops, info)
+		}
+	}
+	for _, info := range crops {
+		err := refreshCropSoilCondition(stub, info)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getSoilTests returns the soil tests of a crop or field index in sampling order.
+func getSoilTests(stub shim.ChaincodeStubInterface, indexName, name string) ([]SoilTest, error) {
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(indexName, []string{name})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	tests := []SoilTest{}
+	for resultsIterator.HasNext() {
+		responseRange, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		_, keyParts, err := stub.SplitCompositeKey(responseRange.Key)
+		if err != nil {
+			return nil, err
+		}
+		var test SoilTest
+		err = getAsset(stub, soilTestIndexName, keyParts[2], &test)
+		if err != nil {
+			return nil, err
+		}
+		tests = append(tests, test)
+	}
+	return tests, nil
+}
+
+// getLatestValidSoilTest returns the most recent valid soil test of a crop,
+// or of its field when the crop has none, and nil when neither was tested.
+func getLatestValidSoilTest(stub shim.ChaincodeStubInterface, info CropInfo) (*SoilTest, error) {
+	indexes := [][2]string{{cropSoilTestIndexName, info.Name}}
+	if info.Field != "" {
+		indexes = append(indexes, [2]string{fieldSoilTestIndexName, info.Field})
+	}
+	for _, index := range indexes {
+		tests, err := getSoilTests(stub, index[0], index[1])
+		if err != nil {
+			return nil, err
+		}
+		for i := len(tests) - 1; i >= 0; i-- {
+			if tests[i].Valid {
+				return &tests[i], nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+// refreshCropSoilCondition derives the soil condition of a crop again from its
+// most recent valid soil test, or that of its field when it has none.
+func refreshCropSoilCondition(stub shim.ChaincodeStubInterface, info CropInfo) error {
+	latest, err := getLatestValidSoilTest(stub, info)
+	if err != nil {
+		return err
+	}
+
+	var conditions CropConditions
+	err = getCropAspect(stub, info.Name, conditionsPart, &conditions)
+	if err != nil {
+		return err
+	}
+	if latest == nil && conditions.SoilTest.ID != "" {
+		// the lab values went with the last valid test, fall back to the sensors
+		soil := SoilConditionType{Moisture: conditions.SoilCondition.Moisture}
+		reading, err := getLatestReading(stub, info.Name, conditions.LatestReading)
+		if err != nil {
+			return err
+		}
+		if reading != nil {
+			soil = reading.SoilCondition
+		}
+		conditions.SoilCondition = soil
+	}
+	applySoilTest(&conditions, latest)
+	return putCropConditions(stub, info.Name, &conditions)
+}
+
+// applySoilTest derives the crop soil condition from a soil test. Moisture
+// keeps coming from sensor readings. A nil test detaches the crop from lab
+// results so readings set the whole soil condition again, the caller restores
+// the soil condition of the latest reading.
+func applySoilTest(conditions *CropConditions, test *SoilTest) {
+	if test == nil {
+		conditions.SoilTest = SoilTestSummaryType{}
+		return
+	}
+	conditions.SoilCondition.Ph = int(math.Round(test.Nutrients.Ph))
+	conditions.SoilCondition.Nitrogen = test.Nutrients.Nitrogen
+	conditions.SoilCondition.Phosphorus = test.Nutrients.Phosphorus
+	conditions.SoilCondition.Potassium = test.Nutrients.Potassium
+	conditions.SoilTest = SoilTestSummaryType{
+		ID:        test.ID,
+		SampledAt: test.SampledAt,
+		Lab:       test.Lab,
+	}
+}
@@ -0,0 +1,601 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+const (
+	yieldSeasonIndexName       = "yieldseason~species~region~crop"
+	cropYieldForecastIndexName = "crop~forecastat~yieldforecast"
+)
+
+// yieldRegionPrecision is the number of geohash characters of a yield region,
+// about 156km x 156km.
+const yieldRegionPrecision = 3
+
+// yieldConfidence is the confidence level of the forecast interval.
+const yieldConfidence = 0.95
+
+// Season features the yield regression is fitted on. Features are season means
+// or maxima rather than totals so a growing crop is comparable with finished
+// seasons.
+const (
+	featureMeanDailyGDD      = "mean_daily_gdd"
+	featureMeanSoilMoisture  = "mean_soil_moisture_percent"
+	featureMaxPathology      = "max_pathology_level"
+	featureMaxInfestedPlants = "max_infested_percent"
+)
+
+var yieldFeatures = []string{featureMeanDailyGDD, featureMeanSoilMoisture, featureMaxPathology, featureMaxInfestedPlants}
+
+// YieldSeason is the condition summary and actual yield of a harvested crop.
+// The yield is the quantity harvested in kilograms over the area of the field
+// of the crop.
+type YieldSeason struct {
+	Crop          string             `json:"crop"`
+	Species       string             `json:"species"`
+	Cultivar      string             `json:"cultivar,omitempty"`
+	Region        string             `json:"region"`
+	PlantedAt     string             `json:"planted_at,omitempty"`
+	HarvestedAt   string             `json:"harvested_at"`
+	Features      map[string]float64 `json:"features"`
+	YieldTonnesHa float64            `json:"yield_t_ha"`
+}
+
+// YieldForecast is a forecast of the yield of a growing crop. Scope is region
+// when the regression was fitted on the seasons of the crop region, species
+// when there were too few and every season of the species was used. Once the
+// crop is harvested its actual yield and the forecast error are filled in.
+type YieldForecast struct {
+	Crop             string             `json:"crop"`
+	Species          string             `json:"species"`
+	Region           string             `json:"region"`
+	Scope            string             `json:"scope"`
+	ForecastAt       string             `json:"forecast_at"`
+	Seasons          int                `json:"seasons"`
+	Features         map[string]float64 `json:"features"`
+	Coefficients     map[string]float64 `json:"coefficients"`
+	ForecastTonnesHa float64            `json:"forecast_t_ha"`
+	LowerTonnesHa    float64            `json:"lower_t_ha"`
+	UpperTonnesHa    float64            `json:"upper_t_ha"`
+	Confidence       float64            `json:"confidence"`
+	ActualTonnesHa   *float64           `json:"actual_t_ha,omitempty"`
+	ErrorTonnesHa    *float64           `json:"error_t_ha,omitempty"`
+	TxID             string             `json:"tx_id"`
+}
+
+// ============================================================
+// forecastYield - forecast the yield of a growing crop from past seasons
+// ============================================================
+func (t *SimpleChaincode) forecastYield(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	fmt.Println("- start forecast yield")
+
+	info, err := getCropInfo(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	var status CropStatus
+	err = getCropAspect(stub, info.Name, statusPart, &status)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if status.Harvesting {
+		return shim.Error("crop " + info.Name + " is harvested, its yield is known")
+	}
+	now, err := txTimestamp(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	features, region, err := seasonFeatures(stub, info, now)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if features == nil {
+		return shim.Error("crop " + info.Name + " has no readings this season")
+	}
+
+	forecast := YieldForecast{
+		Crop:       info.Name,
+		Species:    info.Species,
+		Region:     region,
+		Scope:      "region",
+		ForecastAt: now,
+		Features:   features,
+		Confidence: yieldConfidence,
+		TxID:       stub.GetTxID(),
+	}
+	seasons, err := getYieldSeasons(stub, info.Species, region)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if len(seasons) < len(yieldFeatures)+2 {
+		forecast.Scope = "species"
+		seasons, err = getYieldSeasons(stub, info.Species, "")
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+	err = fitYieldForecast(&forecast, seasons)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	forecastKey, err := stub.CreateCompositeKey(cropYieldForecastIndexName, []string{forecast.Crop, forecast.ForecastAt})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	forecastJSONasBytes, err := json.Marshal(forecast)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = stub.PutState(forecastKey, forecastJSONasBytes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	fmt.Println("- end forecast yield (successful)")
+	return shim.Success(forecastJSONasBytes)
+}
+
+// ============================================================
+// yieldForecastsOf - list the yield forecasts of a crop
+// ============================================================
+func (t *SimpleChaincode) yieldForecastsOf(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting name of the crop to query")
+	}
+
+	forecasts, err := getYieldForecasts(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	forecastsJSON, err := json.Marshal(forecasts)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(forecastsJSON)
+}
+
+// ============================================================
+// yieldSeasonsOf - list the harvested seasons of a species, optionally in one region
+// ============================================================
+func (t *SimpleChaincode) yieldSeasonsOf(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0          1
+	// "species", "region"
+	// region is the 3 character geohash of a yield region, empty for every region
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+
+	seasons, err := getYieldSeasons(stub, args[0], args[1])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	seasonsJSON, err := json.Marshal(seasons)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(seasonsJSON)
+}
+
+// ============================================================
+// recordYieldSeason - keep the season of a harvested crop and complete its forecasts
+// ============================================================
+// The season is recorded in its own transaction once the harvest committed,
+// so harvesting does not read the reading history of the crop. Only crops on
+// a field with an area have a comparable yield, and a season is recorded once.
+func (t *SimpleChaincode) recordYieldSeason(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	fmt.Println("- start record yield season")
+
+	info, err := getCropInfo(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	var status CropStatus
+	err = getCropAspect(stub, info.Name, statusPart, &status)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if !status.Harvesting {
+		return shim.Error("crop " + info.Name + " is not harvested yet")
+	}
+	if status.HarvestedKg == nil {
+		return shim.Error("crop " + info.Name + " has no harvested quantity recorded")
+	}
+	if info.Field == "" {
+		return shim.Error("crop " + info.Name + " has no field, its yield is not comparable")
+	}
+	field, err := getField(stub, info.Field)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if field.AreaHectares <= 0 {
+		return shim.Error("field " + field.ID + " has no area, the yield of crop " + info.Name + " is not comparable")
+	}
+	harvestedAt := status.HarvestedAt
+	if harvestedAt == "" {
+		// crops harvested before the harvest time was kept
+		harvestedAt, err = txTimestamp(stub)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+	features, region, err := seasonFeatures(stub, info, harvestedAt)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if features == nil {
+		return shim.Error("crop " + info.Name + " has no readings this season")
+	}
+
+	season := YieldSeason{
+		Crop:          info.Name,
+		Species:       info.Species,
+		Cultivar:      info.Cultivar,
+		Region:        region,
+		PlantedAt:     info.PlantedAt,
+		HarvestedAt:   harvestedAt,
+		Features:      features,
+		YieldTonnesHa: roundDecimals(*status.HarvestedKg/1000/field.AreaHectares, 2),
+	}
+	seasonKey, err := stub.CreateCompositeKey(yieldSeasonIndexName, []string{season.Species, season.Region, season.Crop})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	seasonAsBytes, err := stub.GetState(seasonKey)
+	if err != nil {
+		return shim.Error("Failed to get yield season: " + err.Error())
+	} else if seasonAsBytes != nil {
+		return shim.Error("The yield season of this crop is already recorded: " + info.Name)
+	}
+	seasonJSONasBytes, err := json.Marshal(season)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = stub.PutState(seasonKey, seasonJSONasBytes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	forecasts, err := getYieldForecasts(stub, info.Name)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	for _, forecast := range forecasts {
+		actual := season.YieldTonnesHa
+		difference := roundDecimals(forecast.ForecastTonnesHa-actual, 2)
+		forecast.ActualTonnesHa = &actual
+		forecast.ErrorTonnesHa = &difference
+		forecastKey, err := stub.CreateCompositeKey(cropYieldForecastIndexName, []string{forecast.Crop, forecast.ForecastAt})
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		forecastJSONasBytes, err := json.Marshal(forecast)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		err = stub.PutState(forecastKey, forecastJSONasBytes)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	fmt.Println("- end record yield season (successful)")
+	return shim.Success(seasonJSONasBytes)
+}
+
+// seasonFeatures summarises the conditions of a crop from planting, or its
+// first reading, up to now and returns them with the region of the crop. The
+// features are nil when the crop has no readings this season.
+// Growing degree days use the catalog phenology model, the mean daily
+// temperature above 0 when the species has none.
+func seasonFeatures(stub shim.ChaincodeStubInterface, info CropInfo, now string) (map[string]float64, string, error) {
+	location, err := cropLocation(stub, info)
+	if err != nil {
+		return nil, "", err
+	}
+	region := geohashEncode(location.Latitude, location.Longitude, yieldRegionPrecision)
+
+	model := PhenologyModel{CapCelcius: math.Inf(1)}
+	entry, found, err := lookupCatalogEntry(stub, info)
+	if err != nil {
+		return nil, "", err
+	}
+	if found && entry.Phenology.CapCelcius > entry.Phenology.BaseCelcius {
+		model = entry.Phenology
+	}
+
+	from := ""
+	if info.PlantedAt != "" {
+		from = info.PlantedAt + "T00:00:00Z"
+	}
+	readings, err := getReadings(stub, info.Name, "", from, now)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(readings) == 0 {
+		return nil, region, nil
+	}
+	diseases, err := getDiseaseObservations(stub, cropDiseaseObservationIndexName, info.Name, from, now)
+	if err != nil {
+		return nil, "", err
+	}
+	pests, err := getPestObservations(stub, info.Name, from, now)
+	if err != nil {
+		return nil, "", err
+	}
+
+	type dayRange struct{ min, max float64 }
+	days := map[string]*dayRange{}
+	moisture := 0.0
+	for _, reading := range readings {
+		day := reading.Timestamp[:10]
+		celcius := reading.Weather.Temperature.Celcius
+		if days[day] == nil {
+			days[day] = &dayRange{celcius, celcius}
+		}
+		days[day].min = math.Min(days[day].min, celcius)
+		days[day].max = math.Max(days[day].max, celcius)
+		moisture += reading.SoilCondition.Moisture.CubicMeter
+	}
+	var dates []string
+	for day := range days {
+		dates = append(dates, day)
+	}
+	sort.Strings(dates)
+	gdd := 0.0
+	for _, day := range dates {
+		gdd += growingDegreeDays(days[day].min, days[day].max, model.BaseCelcius, model.CapCelcius)
+	}
+
+	pathology, infested := 0, 0.0
+	for _, observation := range diseases {
+		if observation.Level > pathology {
+			pathology = observation.Level
+		}
+	}
+	for _, observation := range pests {
+		infested = math.Max(infested, observation.InfestedPercent)
+	}
+
+	features := map[string]float64{
+		featureMeanDailyGDD:      roundDecimals(gdd/float64(len(dates)), 2),
+		featureMeanSoilMoisture:  roundDecimals(moisture/float64(len(readings)), 2),
+		featureMaxPathology:      float64(pathology),
+		featureMaxInfestedPlants: infested,
+	}
+	return features, region, nil
+}
+
+// fitYieldForecast fits an ordinary least squares regression of yield on the
+// season features and fills in the forecast for the crop features with its
+// prediction interval. Features that do not vary over the seasons carry no
+// information and are left out of the fit.
+func fitYieldForecast(forecast *YieldForecast, seasons []YieldSeason) error {
+	var used []string
+	for _, feature := range yieldFeatures {
+		for _, season := range seasons {
+			if season.Features[feature] != seasons[0].Features[feature] {
+				used = append(used, feature)
+				break
+			}
+		}
+	}
+	n, p := len(seasons), len(used)+1
+	if n < p+1 {
+		return fmt.Errorf("%d harvested seasons of %s are not enough to forecast its yield, need %d", n, forecast.Species, p+1)
+	}
+
+	row := func(features map[string]float64) []float64 {
+		x := []float64{1}
+		for _, feature := range used {
+			x = append(x, features[feature])
+		}
+		return x
+	}
+	xtx := make([][]float64, p)
+	for i := range xtx {
+		xtx[i] = make([]float64, p)
+	}
+	xty := make([]float64, p)
+	for _, season := range seasons {
+		x := row(season.Features)
+		for i := 0; i < p; i++ {
+			for j := 0; j < p; j++ {
+				xtx[i][j] += x[i] * x[j]
+			}
+			xty[i] += x[i] * season.YieldTonnesHa
+		}
+	}
+	inverse, err := invertMatrix(xtx)
+	if err != nil {
+		return fmt.Errorf("season features of %s are collinear, the yield regression cannot be fitted", forecast.Species)
+	}
+	beta := make([]float64, p)
+	for i := 0; i < p; i++ {
+		for j := 0; j < p; j++ {
+			beta[i] += inverse[i][j] * xty[j]
+		}
+	}
+
+	residuals := 0.0
+	for _, season := range seasons {
+		residual := season.YieldTonnesHa - dot(beta, row(season.Features))
+		residuals += residual * residual
+	}
+	df := n - p
+	sigma := math.Sqrt(residuals / float64(df))
+
+	x0 := row(forecast.Features)
+	leverage := 0.0
+	for i := 0; i < p; i++ {
+		leverage += x0[i] * dot(inverse[i], x0)
+	}
+	prediction := math.Max(0, dot(beta, x0))
+	margin := studentT975(df) * sigma * math.Sqrt(1+leverage)
+
+	forecast.Seasons = n
+	forecast.Coefficients = map[string]float64{"intercept": beta[0]}
+	for i, feature := range used {
+		forecast.Coefficients[feature] = beta[i+1]
+	}
+	forecast.ForecastTonnesHa = roundDecimals(prediction, 2)
+	forecast.LowerTonnesHa = roundDecimals(math.Max(0, prediction-margin), 2)
+	forecast.UpperTonnesHa = roundDecimals(prediction+margin, 2)
+	return nil
+}
+
+// invertMatrix inverts a square matrix by Gauss-Jordan elimination with
+// partial pivoting.
+func invertMatrix(matrix [][]float64) ([][]float64, error) {
+	size := len(matrix)
+	augmented := make([][]float64, size)
+	for i := range matrix {
+		augmented[i] = make([]float64, 2*size)
+		copy(augmented[i], matrix[i])
+		augmented[i][size+i] = 1
+	}
+	for column := 0; column < size; column++ {
+		pivot := column
+		for r := column + 1; r < size; r++ {
+			if math.Abs(augmented[r][column]) > math.Abs(augmented[pivot][column]) {
+				pivot = r
+			}
+		}
+		if math.Abs(augmented[pivot][column]) < 1e-12 {
+			return nil, fmt.Errorf("matrix is singular")
+		}
+		augmented[column], augmented[pivot] = augmented[pivot], augmented[column]
+		scale := augmented[column][column]
+		for j := range augmented[column] {
+			augmented[column][j] /= scale
+		}
+		for r := 0; r < size; r++ {
+			if r == column || augmented[r][column] == 0 {
+				continue
+			}
+			factor := augmented[r][column]
+			for j := range augmented[r] {
+				augmented[r][j] -= factor * augmented[column][j]
+			}
+		}
+	}
+	inverse := make([][]float64, size)
+	for i := range augmented {
+		inverse[i] = augmented[i][size:]
+	}
+	return inverse, nil
+}
+
+// dot is the dot product of two vectors of the same length.
+func dot(a, b []float64) float64 {
+	sum := 0.0
+	for i := range a {
+		sum += a[i] * b[i]
+	}
+	return sum
+}
+
+// studentT975 is the 97.5% quantile of the Student t distribution with df
+// degrees of freedom, the two sided 95% critical value.
+func studentT975(df int) float64 {
+	table := []float64{12.706, 4.303, 3.182, 2.776, 2.571, 2.447, 2.365, 2.306, 2.262, 2.228,
+		2.201, 2.179, 2.160, 2.145, 2.131, 2.120, 2.110, 2.101, 2.093, 2.086,
+		2.080, 2.074, 2.069, 2.064, 2.060, 2.056, 2.052, 2.048, 2.045, 2.042}
+	switch {
+	case df <= len(table):
+		return table[df-1]
+	case df <= 40:
+		return 2.021
+	case df <= 60:
+		return 2.000
+	case df <= 120:
+		return 1.980
+	}
+	return 1.960
+}
+
+// getYieldSeasons returns the harvested seasons of a species, of every region
+// when region is empty.
+func getYieldSeasons(stub shim.ChaincodeStubInterface, species, region string) ([]YieldSeason, error) {
+	attributes := []string{species}
+	if region != "" {
+		attributes = append(attributes, region)
+	}
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(yieldSeasonIndexName, attributes)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	seasons := []YieldSeason{}
+	for resultsIterator.HasNext() {
+		responseRange, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		var season YieldSeason
+		err = json.Unmarshal(responseRange.Value, &season)
+		if err != nil {
+			return nil, err
+		}
+		seasons = append(seasons, season)
+	}
+	return seasons, nil
+}
+
+// getYieldForecasts returns the yield forecasts of a crop in forecast order.
+func getYieldForecasts(stub shim.ChaincodeStubInterface, cropName string) ([]YieldForecast, error) {
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(cropYieldForecastIndexName, []string{cropName})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	forecasts := []YieldForecast{}
+	for resultsIterator.HasNext() {
+		responseRange, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		var forecast YieldForecast
+		err = json.Unmarshal(responseRange.Value, &forecast)
+		if err != nil {
+			return nil, err
+		}
+		forecasts = append(forecasts, forecast)
+	}
+	return forecasts, nil
+}
@@ -0,0 +1,141 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+// Command export writes the crop, reading and activity history of crops as a
+// time aligned CSV or Parquet table for model training. History is queried
+// from the chaincode, or read from the SQLite mirror of the indexer with
+// -mirror.
+//
+//	export -config connection.yaml -crops rice,wheat -from 2026-04-01T00:00:00Z -interval 1h -format parquet -out season.parquet
+//	export -mirror farming.db -crops rice -interval 24h -out rice.csv
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric-sdk-go/pkg/client/channel"
+	"github.com/hyperledger/fabric-sdk-go/pkg/core/config"
+	"github.com/hyperledger/fabric-sdk-go/pkg/fabsdk"
+	"github.com/manilpuri9/hyperledger-fabric-precision-farming/client/export"
+	"github.com/manilpuri9/hyperledger-fabric-precision-farming/client/indexer"
+)
+
+// sdkQuerier evaluates chaincode functions through a Fabric SDK channel client.
+type sdkQuerier struct {
+	client    *channel.Client
+	chaincode string
+}
+
+func (q sdkQuerier) Query(function string, args ...string) ([]byte, error) {
+	request := channel.Request{ChaincodeID: q.chaincode, Fcn: function}
+	for _, arg := range args {
+		request.Args = append(request.Args, []byte(arg))
+	}
+	response, err := q.client.Query(request)
+	if err != nil {
+		return nil, err
+	}
+	return response.Payload, nil
+}
+
+func main() {
+	configPath := flag.String("config", "connection.yaml", "Fabric SDK connection profile")
+	channelID := flag.String("channel", "myc", "channel of the chaincode")
+	chaincode := flag.String("chaincode", "mycc", "chaincode name")
+	user := flag.String("user", "User1", "user of the organisation to query as")
+	org := flag.String("org", "Org1", "organisation of the user")
+	crops := flag.String("crops", "", "comma separated crop names")
+	from := flag.String("from", "", "RFC3339 start of the export, the first reading when empty")
+	to := flag.String("to", "", "RFC3339 end of the export, the last reading when empty")
+	interval := flag.Duration("interval", time.Hour, "length of the interval of a row")
+	format := flag.String("format", "csv", "csv or parquet")
+	out := flag.String("out", "", "output file, standard output for csv when empty")
+	mirror := flag.String("mirror", "", "SQLite mirror of the indexer to read instead of the chaincode")
+	flag.Parse()
+
+	if *crops == "" {
+		log.Fatal("export: -crops is required")
+	}
+	options := export.Options{Interval: *interval}
+	var err error
+	if *from != "" {
+		if options.From, err = time.Parse(time.RFC3339, *from); err != nil {
+			log.Fatalf("export: -from: %v", err)
+		}
+	}
+	if *to != "" {
+		if options.To, err = time.Parse(time.RFC3339, *to); err != nil {
+			log.Fatalf("export: -to: %v", err)
+		}
+	}
+	if *format != "csv" && *format != "parquet" {
+		log.Fatalf("export: unknown format %q", *format)
+	}
+	if *format == "parquet" && *out == "" {
+		log.Fatal("export: -out is required for parquet")
+	}
+
+	var source export.Source
+	if *mirror != "" {
+		ix, err := indexer.Open(*mirror)
+		if err != nil {
+			log.Fatalf("export: %v", err)
+		}
+		defer ix.Close()
+		source = indexer.MirrorSource{DB: ix.DB()}
+	} else {
+		sdk, err := fabsdk.New(config.FromFile(*configPath))
+		if err != nil {
+			log.Fatalf("export: %v", err)
+		}
+		defer sdk.Close()
+		client, err := channel.New(sdk.ChannelContext(*channelID, fabsdk.WithUser(*user), fabsdk.WithOrg(*org)))
+		if err != nil {
+			log.Fatalf("export: %v", err)
+		}
+		source = export.ChaincodeSource{Querier: sdkQuerier{client: client, chaincode: *chaincode}}
+	}
+
+	table, err := export.Build(source, strings.Split(*crops, ","), options)
+	if err != nil {
+		log.Fatalf("export: %v", err)
+	}
+	if *format == "parquet" {
+		err = export.WriteParquet(*out, table)
+	} else {
+		var w io.Writer = os.Stdout
+		if *out != "" {
+			file, err := os.Create(*out)
+			if err != nil {
+				log.Fatalf("export: %v", err)
+			}
+			defer file.Close()
+			w = file
+		}
+		err = export.WriteCSV(w, table)
+	}
+	if err != nil {
+		log.Fatalf("export: %v", err)
+	}
+	fmt.Fprintf(os.Stderr, "exported %d rows\n", len(table.Rows))
+}
@@ -0,0 +1,62 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+// Command gateway serves the crop chaincode as a REST API with JSON bodies,
+// see package gateway for the endpoints. It invokes the chaincode through
+// the Fabric SDK, command mockgateway serves an in-process mock ledger.
+//
+//	gateway -config connection.yaml -addr :8080
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+
+	"github.com/hyperledger/fabric-sdk-go/pkg/client/channel"
+	"github.com/hyperledger/fabric-sdk-go/pkg/core/config"
+	"github.com/hyperledger/fabric-sdk-go/pkg/fabsdk"
+	"github.com/manilpuri9/hyperledger-fabric-precision-farming/client/gateway"
+	"github.com/manilpuri9/hyperledger-fabric-precision-farming/client/gateway/fabricsdk"
+)
+
+func main() {
+	addr := flag.String("addr", ":8080", "address to listen on")
+	configPath := flag.String("config", "connection.yaml", "Fabric SDK connection profile")
+	channelID := flag.String("channel", "myc", "channel of the chaincode")
+	chaincode := flag.String("chaincode", "mycc", "chaincode name")
+	user := flag.String("user", "User1", "user of the organisation to invoke as")
+	org := flag.String("org", "Org1", "organisation of the user")
+	flag.Parse()
+
+	sdk, err := fabsdk.New(config.FromFile(*configPath))
+	if err != nil {
+		log.Fatalf("gateway: %v", err)
+	}
+	defer sdk.Close()
+	client, err := channel.New(sdk.ChannelContext(*channelID, fabsdk.WithUser(*user), fabsdk.WithOrg(*org)))
+	if err != nil {
+		log.Fatalf("gateway: %v", err)
+	}
+	transport := fabricsdk.Transport{Client: client, Chaincode: *chaincode}
+	log.Printf("gateway: serving %s on %s on %s", *chaincode, *channelID, *addr)
+
+	err = http.ListenAndServe(*addr, gateway.Server{Transport: transport})
+	if err != nil {
+		log.Fatalf("gateway: %v", err)
+	}
+}
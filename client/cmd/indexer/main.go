@@ -0,0 +1,91 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+// Command indexer mirrors the state of the crop chaincode into SQLite. It
+// resumes from the checkpoint kept in the database, -rebuild starts again
+// from the genesis block.
+//
+//	indexer -config connection.yaml -db farming.db
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/hyperledger/fabric-sdk-go/pkg/core/config"
+	"github.com/hyperledger/fabric-sdk-go/pkg/fabsdk"
+	"github.com/manilpuri9/hyperledger-fabric-precision-farming/client/indexer"
+)
+
+func main() {
+	configPath := flag.String("config", "connection.yaml", "Fabric SDK connection profile")
+	channelID := flag.String("channel", "myc", "channel of the chaincode")
+	chaincode := flag.String("chaincode", "mycc", "chaincode name")
+	user := flag.String("user", "User1", "user of the organisation to read blocks as")
+	org := flag.String("org", "Org1", "organisation of the user")
+	dbPath := flag.String("db", "farming.db", "SQLite mirror database")
+	rebuild := flag.Bool("rebuild", false, "empty the mirror and apply the ledger from the genesis block")
+	flag.Parse()
+
+	ix, err := indexer.Open(*dbPath)
+	if err != nil {
+		log.Fatalf("indexer: %v", err)
+	}
+	defer ix.Close()
+	if *rebuild {
+		if err = ix.Rebuild(); err != nil {
+			log.Fatalf("indexer: rebuild: %v", err)
+		}
+	}
+	next, err := ix.Checkpoint()
+	if err != nil {
+		log.Fatalf("indexer: %v", err)
+	}
+
+	sdk, err := fabsdk.New(config.FromFile(*configPath))
+	if err != nil {
+		log.Fatalf("indexer: %v", err)
+	}
+	defer sdk.Close()
+	source := indexer.FabricBlockSource{
+		Channel:   sdk.ChannelContext(*channelID, fabsdk.WithUser(*user), fabsdk.WithOrg(*org)),
+		Chaincode: *chaincode,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		cancel()
+	}()
+
+	log.Printf("indexer: mirroring %s on %s from block %d", *chaincode, *channelID, next)
+	err = ix.Run(ctx, source)
+	if err != nil && err != context.Canceled {
+		log.Printf("indexer: %v", err)
+		ix.Close()
+		sdk.Close()
+		os.Exit(1)
+	}
+	next, _ = ix.Checkpoint()
+	log.Printf("indexer: stopped, next block %d", next)
+}
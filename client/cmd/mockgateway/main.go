@@ -0,0 +1,65 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+// Command mockgateway serves the REST API of command gateway against the
+// crop chaincode running in-process on a mock ledger, for local testing.
+// The ledger starts empty but for the catalog species named with -species,
+// and is invoked as a member of -msp that may edit the catalog.
+//
+//	mockgateway -addr localhost:8080 -species rice,wheat
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/manilpuri9/hyperledger-fabric-precision-farming/client/gateway"
+	"github.com/manilpuri9/hyperledger-fabric-precision-farming/client/gateway/mockledger"
+)
+
+func main() {
+	addr := flag.String("addr", "localhost:8080", "address to listen on")
+	mspID := flag.String("msp", "Org1MSP", "MSP of the identity invoking the chaincode")
+	species := flag.String("species", "rice", "comma separated catalog species to start with")
+	flag.Parse()
+
+	ledger, err := mockledger.New(*mspID, map[string]string{"hssf.agronomist": "true"})
+	if err != nil {
+		log.Fatalf("mockgateway: %v", err)
+	}
+	for _, id := range strings.Split(*species, ",") {
+		if id == "" {
+			continue
+		}
+		entry, err := json.Marshal(map[string]string{"id": id, "name": id})
+		if err == nil {
+			_, _, err = ledger.Submit("putSpecies", string(entry))
+		}
+		if err != nil {
+			log.Fatalf("mockgateway: %v", err)
+		}
+	}
+	log.Printf("mockgateway: serving a mock ledger on %s", *addr)
+
+	err = http.ListenAndServe(*addr, gateway.Server{Transport: ledger})
+	if err != nil {
+		log.Fatalf("mockgateway: %v", err)
+	}
+}
@@ -0,0 +1,77 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package export
+
+import "encoding/json"
+
+// Querier evaluates a chaincode function without submitting a transaction
+// and returns its payload.
+type Querier interface {
+	Query(function string, args ...string) ([]byte, error)
+}
+
+// ChaincodeSource reads crop history through the query functions of the
+// chaincode.
+type ChaincodeSource struct {
+	Querier Querier
+}
+
+// Crop reads the base record of a crop with readCrop.
+func (s ChaincodeSource) Crop(name string) (CropInfo, error) {
+	var info CropInfo
+	err := s.query(&info, "readCrop", name)
+	return info, err
+}
+
+// Readings reads the readings of every sensor of a crop with queryReadings.
+func (s ChaincodeSource) Readings(crop, from, to string) ([]Reading, error) {
+	var readings []Reading
+	err := s.query(&readings, "queryReadings", crop, "", from, to)
+	return readings, err
+}
+
+// Irrigations reads the irrigation applied to a crop with irrigationEventsOf.
+func (s ChaincodeSource) Irrigations(crop, from, to string) ([]Irrigation, error) {
+	var irrigations []Irrigation
+	err := s.query(&irrigations, "irrigationEventsOf", crop, from, to)
+	return irrigations, err
+}
+
+// Fertilizations reads the fertilizer applications of a crop with
+// fertilizerApplicationsOf.
+func (s ChaincodeSource) Fertilizations(crop string) ([]Fertilization, error) {
+	var fertilizations []Fertilization
+	err := s.query(&fertilizations, "fertilizerApplicationsOf", crop)
+	return fertilizations, err
+}
+
+// PesticideApplications reads the pesticide applications of a crop with
+// pesticideApplicationsOf.
+func (s ChaincodeSource) PesticideApplications(crop string) ([]PesticideApplication, error) {
+	var applications []PesticideApplication
+	err := s.query(&applications, "pesticideApplicationsOf", crop)
+	return applications, err
+}
+
+func (s ChaincodeSource) query(value interface{}, function string, args ...string) error {
+	payload, err := s.Querier.Query(function, args...)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(payload, value)
+}
@@ -0,0 +1,159 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package export
+
+import (
+	"reflect"
+	"strings"
+	"unicode"
+)
+
+// leaf is a scalar field of a struct reached through the field index path.
+type leaf struct {
+	name       string
+	index      []int
+	columnType ColumnType
+}
+
+// leaves lists the scalar fields of a struct type in declaration order,
+// named by their JSON tags joined with '_' and prefixed with prefix.
+// Embedded structs add no name of their own, slices and maps are skipped.
+func leaves(t reflect.Type, prefix string) []leaf {
+	var result []leaf
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		name := columnName(field)
+		if name == "" {
+			continue
+		}
+		switch field.Type.Kind() {
+		case reflect.Struct:
+			nested := prefix + name + "_"
+			if field.Anonymous {
+				nested = prefix
+			}
+			for _, l := range leaves(field.Type, nested) {
+				l.index = append([]int{i}, l.index...)
+				result = append(result, l)
+			}
+		case reflect.String, reflect.Bool:
+			result = append(result, leaf{prefix + name, []int{i}, String})
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			result = append(result, leaf{prefix + name, []int{i}, Int64})
+		case reflect.Float32, reflect.Float64:
+			result = append(result, leaf{prefix + name, []int{i}, Double})
+		}
+	}
+	return result
+}
+
+// numericLeaves keeps the leaves holding numbers.
+func numericLeaves(all []leaf) []leaf {
+	var result []leaf
+	for _, l := range all {
+		if l.columnType != String {
+			result = append(result, l)
+		}
+	}
+	return result
+}
+
+// columnName is the JSON name of a field made safe for a column: lower case
+// with every other character than letters and digits replaced by '_'. A
+// field without a JSON name is named by the snake case of its Go name, a
+// field tagged "-" has no column.
+func columnName(field reflect.StructField) string {
+	name := strings.Split(field.Tag.Get("json"), ",")[0]
+	if name == "-" {
+		return ""
+	}
+	if name == "" {
+		var snake []rune
+		for i, r := range field.Name {
+			if unicode.IsUpper(r) && i > 0 {
+				snake = append(snake, '_')
+			}
+			snake = append(snake, r)
+		}
+		name = string(snake)
+	}
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return '_'
+	}, name)
+}
+
+// values reads the leaves of a struct value as column values.
+func values(v reflect.Value, all []leaf) []interface{} {
+	result := make([]interface{}, len(all))
+	for i, l := range all {
+		field := v.FieldByIndex(l.index)
+		switch l.columnType {
+		case String:
+			if field.Kind() == reflect.Bool {
+				if field.Bool() {
+					result[i] = "true"
+				} else {
+					result[i] = "false"
+				}
+			} else {
+				result[i] = field.String()
+			}
+		case Int64:
+			result[i] = field.Int()
+		case Double:
+			result[i] = field.Float()
+		}
+	}
+	return result
+}
+
+// means averages the numeric leaves over the values, nil without values.
+func means(all []reflect.Value, numeric []leaf) []interface{} {
+	if len(all) == 0 {
+		return make([]interface{}, len(numeric))
+	}
+	result := sums(all, numeric)
+	for i := range result {
+		result[i] = result[i].(float64) / float64(len(all))
+	}
+	return result
+}
+
+// sums adds up the numeric leaves over the values.
+func sums(all []reflect.Value, numeric []leaf) []interface{} {
+	result := make([]interface{}, len(numeric))
+	for i, l := range numeric {
+		total := 0.0
+		for _, v := range all {
+			field := v.FieldByIndex(l.index)
+			if l.columnType == Int64 {
+				total += float64(field.Int())
+			} else {
+				total += field.Float()
+			}
+		}
+		result[i] = total
+	}
+	return result
+}
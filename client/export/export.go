@@ -0,0 +1,196 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+// Package export flattens the crop, sensor reading and activity history kept
+// by the precision farming chaincode into time aligned tables for model
+// training, with one row per crop per interval.
+//
+// Column names are derived from the JSON tags of the chaincode structs:
+// nested structs are joined with '_', so the temperature of a reading becomes
+// weather_temperature_celcius. Units are the units the chaincode stores:
+// temperature in degrees Celcius, pressure in Pascal, absolute humidity in
+// g/m3, radiation in MJ/m2/day, soil moisture in volumetric percent, soil
+// nutrients in percent, irrigation in mm and fertilizer in kg/ha. Timestamps
+// are UTC RFC3339.
+//
+// History is read through a Source, either the chaincode itself or an
+// off-chain mirror of it.
+package export
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// TimeLayout is the timestamp layout the chaincode stores readings with.
+const TimeLayout = "2006-01-02T15:04:05Z"
+
+// Source reads the history of crops. Time bounds are TimeLayout timestamps,
+// empty for an open bound.
+type Source interface {
+	Crop(name string) (CropInfo, error)
+	Readings(crop, from, to string) ([]Reading, error)
+	Irrigations(crop, from, to string) ([]Irrigation, error)
+	Fertilizations(crop string) ([]Fertilization, error)
+	PesticideApplications(crop string) ([]PesticideApplication, error)
+}
+
+// ColumnType is the type of the values of a column.
+type ColumnType int
+
+const (
+	String ColumnType = iota
+	Int64
+	Double
+)
+
+// Column is one column of a table.
+type Column struct {
+	Name string
+	Type ColumnType
+}
+
+// Table is a flattened export. Row values are string, int64 or float64
+// matching the column type, or nil when the interval has no value.
+type Table struct {
+	Columns []Column
+	Rows    [][]interface{}
+}
+
+// Options select the time span and the interval of the rows. A zero From or
+// To is taken from the first or last reading of each crop.
+type Options struct {
+	From     time.Time
+	To       time.Time
+	Interval time.Duration
+}
+
+// Build exports the history of the crops into one table.
+func Build(source Source, crops []string, options Options) (Table, error) {
+	if options.Interval <= 0 {
+		return Table{}, fmt.Errorf("interval must be positive")
+	}
+	infoLeaves := leaves(reflect.TypeOf(CropInfo{}), "")
+	readingLeaves := numericLeaves(leaves(reflect.TypeOf(readingConditions{}), ""))
+	irrigationLeaves := numericLeaves(leaves(reflect.TypeOf(Irrigation{}), "irrigation_"))
+	fertilizationLeaves := numericLeaves(leaves(reflect.TypeOf(Fertilization{}), "fertilizer_"))
+
+	var table Table
+	for _, l := range infoLeaves {
+		table.Columns = append(table.Columns, Column{l.name, l.columnType})
+	}
+	table.Columns = append(table.Columns, Column{"interval_start", String}, Column{"interval_end", String}, Column{"readings", Int64})
+	for _, group := range [][]leaf{readingLeaves, irrigationLeaves, fertilizationLeaves} {
+		for _, l := range group {
+			table.Columns = append(table.Columns, Column{l.name, Double})
+		}
+	}
+	table.Columns = append(table.Columns, Column{"pesticide_applications", Int64})
+
+	for _, name := range crops {
+		info, err := source.Crop(name)
+		if err != nil {
+			return table, fmt.Errorf("crop %s: %v", name, err)
+		}
+		var from, to string
+		if !options.From.IsZero() {
+			from = options.From.UTC().Format(TimeLayout)
+		}
+		if !options.To.IsZero() {
+			to = options.To.UTC().Format(TimeLayout)
+		}
+		readings, err := source.Readings(name, from, to)
+		if err != nil {
+			return table, fmt.Errorf("readings of %s: %v", name, err)
+		}
+		sort.Slice(readings, func(i, j int) bool { return readings[i].Timestamp < readings[j].Timestamp })
+		start, end := options.From, options.To
+		if len(readings) > 0 {
+			if start.IsZero() {
+				start, _ = time.Parse(TimeLayout, readings[0].Timestamp)
+			}
+			if end.IsZero() {
+				end, _ = time.Parse(TimeLayout, readings[len(readings)-1].Timestamp)
+			}
+		}
+		if start.IsZero() || end.IsZero() {
+			// no readings and an open window, nothing to align the crop on
+			continue
+		}
+		start = start.UTC().Truncate(options.Interval)
+		end = end.UTC()
+		from, to = start.Format(TimeLayout), end.Format(TimeLayout)
+
+		irrigations, err := source.Irrigations(name, from, to)
+		if err != nil {
+			return table, fmt.Errorf("irrigation of %s: %v", name, err)
+		}
+		fertilizations, err := source.Fertilizations(name)
+		if err != nil {
+			return table, fmt.Errorf("fertilizer applications of %s: %v", name, err)
+		}
+		pesticides, err := source.PesticideApplications(name)
+		if err != nil {
+			return table, fmt.Errorf("pesticide applications of %s: %v", name, err)
+		}
+
+		infoValues := values(reflect.ValueOf(info), infoLeaves)
+		for bucket := start; !bucket.After(end); bucket = bucket.Add(options.Interval) {
+			bucketFrom := bucket.Format(TimeLayout)
+			bucketTo := bucket.Add(options.Interval).Format(TimeLayout)
+			inBucket := func(timestamp string) bool {
+				return timestamp >= bucketFrom && timestamp < bucketTo
+			}
+
+			row := append([]interface{}{}, infoValues...)
+			var bucketReadings []reflect.Value
+			for _, reading := range readings {
+				if inBucket(reading.Timestamp) {
+					bucketReadings = append(bucketReadings, reflect.ValueOf(reading.conditions()))
+				}
+			}
+			row = append(row, bucketFrom, bucketTo, int64(len(bucketReadings)))
+			row = append(row, means(bucketReadings, readingLeaves)...)
+
+			var bucketIrrigations, bucketFertilizations []reflect.Value
+			for _, irrigation := range irrigations {
+				if inBucket(irrigation.IrrigatedAt) {
+					bucketIrrigations = append(bucketIrrigations, reflect.ValueOf(irrigation))
+				}
+			}
+			for _, fertilization := range fertilizations {
+				if inBucket(fertilization.AppliedAt) {
+					bucketFertilizations = append(bucketFertilizations, reflect.ValueOf(fertilization))
+				}
+			}
+			row = append(row, sums(bucketIrrigations, irrigationLeaves)...)
+			row = append(row, sums(bucketFertilizations, fertilizationLeaves)...)
+
+			applications := int64(0)
+			for _, pesticide := range pesticides {
+				if inBucket(pesticide.AppliedAt) {
+					applications++
+				}
+			}
+			row = append(row, applications)
+			table.Rows = append(table.Rows, row)
+		}
+	}
+	return table, nil
+}
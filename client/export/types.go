@@ -0,0 +1,132 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package export
+
+// The types below mirror the JSON documents of the chaincode. Their tags are
+// the tags of the chaincode structs and name the exported columns. Fields
+// the chaincode encodes under their Go name are left untagged here too, their
+// column is the snake case of the name.
+
+// GeoLocationType is a position in decimal degrees.
+type GeoLocationType struct {
+	Latitude  float64
+	Longitude float64 `json:"longitude"`
+}
+
+// FarmInfoType is the location and soil type of a crop.
+type FarmInfoType struct {
+	GeoLocation GeoLocationType
+	SoilType    string `json:"soil_type"`
+}
+
+// CropInfo is the base record of a crop.
+type CropInfo struct {
+	Name      string       `json:"name"`
+	Owner     string       `json:"owner"`
+	Quantity  int          `json:"quantity"`
+	FarmInfo  FarmInfoType `json:"farm_info"`
+	Field     string       `json:"field,omitempty"`
+	Species   string       `json:"species,omitempty"`
+	Cultivar  string       `json:"cultivar,omitempty"`
+	PlantedAt string       `json:"planted_at,omitempty"`
+}
+
+// WeatherType is the weather part of a reading. Humidity.CubicMeter is the
+// absolute humidity in g/m3 and Radiation.Rem the solar radiation as a daily
+// rate in MJ/m2/day, as the chaincode documents them.
+type WeatherType struct {
+	Temperature struct {
+		Celcius float64 `json:"celcius"`
+	} `json:"temperature"`
+	Pressure struct {
+		Pascal float64 `json:"pascal"`
+	} `json:"pressure"`
+	Humidity struct {
+		CubicMeter float64 `json:"cubic_meter"`
+	} `json:"humidity"`
+	Radiation struct {
+		Rem float64 `json:"rem"`
+	} `json:"radiation"`
+}
+
+// SoilConditionType is the soil part of a reading.
+type SoilConditionType struct {
+	Moisture struct {
+		CubicMeter float64 `json:"cubic meter"`
+	} `json:"moisture"`
+	Ph       int `json:"ph"`
+	Nitrogen struct {
+		Percentage float64 `json:"percentage"`
+	} `json:"nitrogen"`
+	Phosphorus struct {
+		Percentage float64 `json:"percentage"`
+	} `json:"phosphorus"`
+	Potassium struct {
+		Percentage float64 `json:"percentage"`
+	} `json:"potassium"`
+}
+
+// Reading is one timestamped sensor reading of a crop.
+type Reading struct {
+	Crop          string            `json:"crop"`
+	Sensor        string            `json:"sensor"`
+	Timestamp     string            `json:"timestamp"`
+	Weather       WeatherType       `json:"weather"`
+	SoilCondition SoilConditionType `json:"soil_condition"`
+}
+
+// readingConditions holds the measured values of a reading in column order.
+type readingConditions struct {
+	Weather       WeatherType
+	SoilCondition SoilConditionType
+}
+
+func (r Reading) conditions() readingConditions {
+	return readingConditions{r.Weather, r.SoilCondition}
+}
+
+// Irrigation is an irrigation applied to a crop.
+type Irrigation struct {
+	Crop        string  `json:"crop"`
+	IrrigatedAt string  `json:"irrigated_at"`
+	AmountMm    float64 `json:"amount_mm"`
+	TxID        string  `json:"tx_id"`
+}
+
+// Fertilization is a fertilizer application on a crop.
+type Fertilization struct {
+	Crop           string  `json:"crop"`
+	Product        string  `json:"product"`
+	RateKgHa       float64 `json:"rate_kg_ha"`
+	NitrogenKgHa   float64 `json:"nitrogen_kg_ha"`
+	PhosphorusKgHa float64 `json:"phosphorus_kg_ha"`
+	PotassiumKgHa  float64 `json:"potassium_kg_ha"`
+	AppliedAt      string  `json:"applied_at"`
+	TxID           string  `json:"tx_id"`
+}
+
+// PesticideApplication is a pesticide application on a crop. Doses are
+// counted rather than summed, products are dosed in l or kg.
+type PesticideApplication struct {
+	Crop           string  `json:"crop"`
+	Product        string  `json:"product"`
+	DosePerHectare float64 `json:"dose_per_hectare"`
+	Unit           string  `json:"unit"`
+	AppliedAt      string  `json:"applied_at"`
+	TxID           string  `json:"tx_id"`
+}
@@ -0,0 +1,106 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package export
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/xitongsys/parquet-go-source/local"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+// WriteCSV writes the table as CSV with a header row. Missing values are
+// empty fields.
+func WriteCSV(w io.Writer, table Table) error {
+	out := csv.NewWriter(w)
+	header := make([]string, len(table.Columns))
+	for i, column := range table.Columns {
+		header[i] = column.Name
+	}
+	if err := out.Write(header); err != nil {
+		return err
+	}
+	record := make([]string, len(table.Columns))
+	for _, row := range table.Rows {
+		for i, value := range row {
+			record[i] = ""
+			if value != nil {
+				record[i] = formatValue(value)
+			}
+		}
+		if err := out.Write(record); err != nil {
+			return err
+		}
+	}
+	out.Flush()
+	return out.Error()
+}
+
+// WriteParquet writes the table to a Parquet file. Every column is optional
+// so missing values are stored as nulls.
+func WriteParquet(path string, table Table) error {
+	schema := make([]string, len(table.Columns))
+	for i, column := range table.Columns {
+		var physical string
+		switch column.Type {
+		case String:
+			physical = "type=BYTE_ARRAY, convertedtype=UTF8"
+		case Int64:
+			physical = "type=INT64"
+		case Double:
+			physical = "type=DOUBLE"
+		}
+		schema[i] = fmt.Sprintf("name=%s, %s, repetitiontype=OPTIONAL", column.Name, physical)
+	}
+
+	file, err := local.NewLocalFileWriter(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	out, err := writer.NewCSVWriter(schema, file, 4)
+	if err != nil {
+		return err
+	}
+	for _, row := range table.Rows {
+		record := make([]*string, len(row))
+		for i, value := range row {
+			if value != nil {
+				formatted := formatValue(value)
+				record[i] = &formatted
+			}
+		}
+		if err = out.WriteString(record); err != nil {
+			return err
+		}
+	}
+	return out.WriteStop()
+}
+
+func formatValue(value interface{}) string {
+	switch v := value.(type) {
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	}
+	return fmt.Sprint(value)
+}
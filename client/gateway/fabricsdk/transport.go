@@ -0,0 +1,69 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+// Package fabricsdk is the gateway.Transport of a Fabric network, through
+// the Fabric SDK.
+package fabricsdk
+
+import (
+	"github.com/hyperledger/fabric-sdk-go/pkg/client/channel"
+	"github.com/hyperledger/fabric-sdk-go/pkg/common/errors/status"
+	"github.com/manilpuri9/hyperledger-fabric-precision-farming/client/gateway"
+)
+
+// Transport invokes the chaincode through a Fabric SDK channel client.
+// Submit waits for the transaction to be committed.
+type Transport struct {
+	Client    *channel.Client
+	Chaincode string
+}
+
+// Submit executes a function with channel.Client.Execute.
+func (t Transport) Submit(function string, args ...string) ([]byte, string, error) {
+	response, err := t.Client.Execute(t.request(function, args))
+	if err != nil {
+		return nil, "", chaincodeError(function, err)
+	}
+	return response.Payload, string(response.TransactionID), nil
+}
+
+// Evaluate queries a function with channel.Client.Query.
+func (t Transport) Evaluate(function string, args ...string) ([]byte, error) {
+	response, err := t.Client.Query(t.request(function, args))
+	if err != nil {
+		return nil, chaincodeError(function, err)
+	}
+	return response.Payload, nil
+}
+
+func (t Transport) request(function string, args []string) channel.Request {
+	request := channel.Request{ChaincodeID: t.Chaincode, Fcn: function}
+	for _, arg := range args {
+		request.Args = append(request.Args, []byte(arg))
+	}
+	return request
+}
+
+// chaincodeError tells the error responses of the chaincode, which the
+// endorsing peers return, from failures to reach the peers.
+func chaincodeError(function string, err error) error {
+	s, ok := status.FromError(err)
+	if ok && (s.Group == status.ChaincodeStatus || s.Group == status.EndorserServerStatus) {
+		return &gateway.ChaincodeError{Function: function, Message: s.Message}
+	}
+	return err
+}
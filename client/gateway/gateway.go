@@ -0,0 +1,143 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+// Package gateway serves the crop chaincode as a REST API with JSON bodies,
+// so farm apps and IoT gateways need neither the Fabric SDK nor the
+// positional arguments of the chaincode functions.
+//
+//	POST   /crops                       initCrop, 201 with the crop
+//	GET    /crops?owner=…               cropsOfOwner
+//	GET    /crops/{id}                  readCrop
+//	PATCH  /crops/{id}                  updateCrop, image and cghc, 200 with the crop
+//	DELETE /crops/{id}                  deleteCrop, 204
+//	POST   /crops/{id}/irrigations      recordIrrigation, 201 with the irrigation
+//	GET    /crops/{id}/irrigations      irrigationEventsOf, from and to in the query
+//
+// Bodies the gateway cannot decode fail with 400. Errors of the chaincode
+// are mapped per endpoint: 404 when the crop of a /crops/{id} path does not
+// exist, 409 when POST /crops names a crop that already exists, and 422 for
+// anything else the chaincode rejected, including species, cultivars and
+// fields a request body references but the ledger lacks. A ledger that
+// cannot be reached fails with 502. The chaincode is invoked through a
+// Transport, package fabricsdk for a Fabric network or package mockledger
+// in-process.
+package gateway
+
+import (
+	"strconv"
+
+	"github.com/manilpuri9/hyperledger-fabric-precision-farming/client/export"
+)
+
+// Crop is the crop document POST /crops takes. It has the fields of the
+// document readCrop returns that initCrop sets, the crop info of the base
+// record followed by the initial conditions and activities.
+type Crop struct {
+	export.CropInfo
+	Weather        export.WeatherType       `json:"weather"`
+	SoilCondition  export.SoilConditionType `json:"soil_condition"`
+	Image          string                   `json:"image"`
+	Cghc           int                      `json:"cghc"`
+	Irrigation     bool                     `json:"irrigation"`
+	AddFertilizer  bool                     `json:"fertilizer_addition"`
+	ApplyPesticide bool                     `json:"apply_pesticide"`
+	Harvesting     bool                     `json:"harvesting"`
+}
+
+// patchableFields are the fields of a crop PATCH /crops/{id} can change,
+// the ones updateCrop writes. Activities and the lifecycle have their own
+// chaincode functions.
+var patchableFields = map[string]bool{
+	"image": true,
+	"cghc":  true,
+}
+
+// readingFields are the fields of a crop that only signed readings of bound
+// devices change.
+var readingFields = map[string]bool{
+	"weather":        true,
+	"soil_condition": true,
+}
+
+// CropPatch is the body of PATCH /crops/{id}. Fields left out keep their
+// value.
+type CropPatch struct {
+	Image *string `json:"image,omitempty"`
+	Cghc  *int    `json:"cghc,omitempty"`
+}
+
+// IrrigationRequest is the body of POST /crops/{id}/irrigations. IrrigatedAt
+// is RFC3339 and defaults to the transaction time.
+type IrrigationRequest struct {
+	AmountMm    float64 `json:"amount_mm"`
+	IrrigatedAt string  `json:"irrigated_at,omitempty"`
+}
+
+// initCropArgs are the 23 arguments of initCrop for a crop.
+func initCropArgs(crop Crop) []string {
+	args := []string{
+		crop.Name,
+		crop.Owner,
+		strconv.Itoa(crop.Quantity),
+		formatFloat(crop.FarmInfo.GeoLocation.Latitude),
+		formatFloat(crop.FarmInfo.GeoLocation.Longitude),
+		crop.FarmInfo.SoilType,
+	}
+	args = append(args, conditionArgs(crop)...)
+	return append(args,
+		crop.Image,
+		strconv.Itoa(crop.Cghc),
+		strconv.FormatBool(crop.Irrigation),
+		strconv.FormatBool(crop.AddFertilizer),
+		strconv.FormatBool(crop.ApplyPesticide),
+		strconv.FormatBool(crop.Harvesting),
+		crop.Field,
+		crop.Species,
+		crop.Cultivar,
+	)
+}
+
+// updateCropArgs are the 3 arguments of updateCrop for a patch of a crop,
+// the name, image and cghc grade. updateCrop keeps the values passed empty.
+func updateCropArgs(name string, patch CropPatch) []string {
+	args := []string{name, "", ""}
+	if patch.Image != nil {
+		args[1] = *patch.Image
+	}
+	if patch.Cghc != nil {
+		args[2] = strconv.Itoa(*patch.Cghc)
+	}
+	return args
+}
+
+// conditionArgs are the initial weather and soil arguments of initCrop.
+func conditionArgs(crop Crop) []string {
+	return []string{
+		formatFloat(crop.Weather.Temperature.Celcius),
+		formatFloat(crop.Weather.Pressure.Pascal),
+		formatFloat(crop.Weather.Humidity.CubicMeter),
+		formatFloat(crop.Weather.Radiation.Rem),
+		formatFloat(crop.SoilCondition.Moisture.CubicMeter),
+		strconv.Itoa(crop.SoilCondition.Ph),
+		formatFloat(crop.SoilCondition.Nitrogen.Percentage),
+		formatFloat(crop.SoilCondition.Phosphorus.Percentage),
+	}
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
@@ -0,0 +1,211 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+// Package mockledger runs the crop chaincode in-process on a shim.MockStub,
+// as a gateway.Transport for local testing.
+//
+// The chaincode links the protos of Fabric 1.4 while the Fabric SDK links
+// fabric-protos-go, which register the same protobuf types. A program
+// cannot link both, so the mock ledger and package fabricsdk are never
+// used by the same command.
+package mockledger
+
+import (
+	"container/list"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/msp"
+	"github.com/manilpuri9/hyperledger-fabric-precision-farming/chaincode"
+	"github.com/manilpuri9/hyperledger-fabric-precision-farming/client/gateway"
+)
+
+// attributesOID is the certificate extension of the attributes the Fabric CA
+// issues, which cid.GetAttributeValue reads.
+var attributesOID = asn1.ObjectIdentifier{1, 2, 3, 4, 5, 6, 7, 8, 1}
+
+// Ledger is the world state of the crop chaincode in memory. Every function
+// is invoked as the identity the ledger was created with. Like on a peer,
+// a transaction that fails leaves the world state untouched and Evaluate
+// never commits. Chaincode events are dropped.
+type Ledger struct {
+	mu      sync.Mutex
+	stub    *shim.MockStub
+	cc      shim.Chaincode
+	creator []byte
+	txs     int
+
+	// Now is the clock of the transaction timestamps, time.Now by default.
+	Now func() time.Time
+}
+
+// New returns a ledger with an empty world state. Its transactions are
+// signed by a member of mspID whose certificate carries the attributes,
+// such as hssf.agronomist=true to edit the crop catalog.
+func New(mspID string, attributes map[string]string) (*Ledger, error) {
+	certificate, err := newCertificate(attributes)
+	if err != nil {
+		return nil, err
+	}
+	creator, err := proto.Marshal(&msp.SerializedIdentity{Mspid: mspID, IdBytes: certificate})
+	if err != nil {
+		return nil, err
+	}
+	cc := new(chaincode.SimpleChaincode)
+	return &Ledger{
+		stub:    shim.NewMockStub("mycc", cc),
+		cc:      cc,
+		creator: creator,
+		Now:     time.Now,
+	}, nil
+}
+
+// Submit invokes a function as a transaction with a new transaction ID.
+func (l *Ledger) Submit(function string, args ...string) ([]byte, string, error) {
+	return l.invoke(function, args, true)
+}
+
+// Evaluate invokes a function as a query, its writes are discarded.
+func (l *Ledger) Evaluate(function string, args ...string) ([]byte, error) {
+	payload, _, err := l.invoke(function, args, false)
+	return payload, err
+}
+
+func (l *Ledger) invoke(function string, args []string, commit bool) ([]byte, string, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.txs++
+	txID := fmt.Sprintf("%064x", l.txs)
+	stub := &mockStub{MockStub: l.stub, creator: l.creator, args: [][]byte{[]byte(function)}}
+	for _, arg := range args {
+		stub.args = append(stub.args, []byte(arg))
+	}
+	txTimestamp, err := ptypes.TimestampProto(l.Now())
+	if err != nil {
+		return nil, "", err
+	}
+
+	state, keys := l.snapshot()
+	l.stub.MockTransactionStart(txID)
+	l.stub.TxTimestamp = txTimestamp
+	response := l.cc.Invoke(stub)
+	l.stub.MockTransactionEnd(txID)
+
+	if !commit || response.Status >= shim.ERRORTHRESHOLD {
+		l.stub.State, l.stub.Keys = state, keys
+	}
+	if response.Status >= shim.ERRORTHRESHOLD {
+		return nil, "", &gateway.ChaincodeError{Function: function, Message: response.Message}
+	}
+	return response.Payload, txID, nil
+}
+
+// snapshot copies the world state, MockStub writes it as the chaincode runs.
+func (l *Ledger) snapshot() (map[string][]byte, *list.List) {
+	state := make(map[string][]byte, len(l.stub.State))
+	for key, value := range l.stub.State {
+		state[key] = value
+	}
+	keys := list.New()
+	keys.PushBackList(l.stub.Keys)
+	return state, keys
+}
+
+// mockStub is the stub of one invocation: the world state of the MockStub
+// with the arguments and the creator of the invocation.
+type mockStub struct {
+	*shim.MockStub
+	args    [][]byte
+	creator []byte
+}
+
+func (s *mockStub) GetArgs() [][]byte {
+	return s.args
+}
+
+func (s *mockStub) GetStringArgs() []string {
+	args := make([]string, 0, len(s.args))
+	for _, arg := range s.args {
+		args = append(args, string(arg))
+	}
+	return args
+}
+
+func (s *mockStub) GetFunctionAndParameters() (string, []string) {
+	args := s.GetStringArgs()
+	if len(args) == 0 {
+		return "", []string{}
+	}
+	return args[0], args[1:]
+}
+
+func (s *mockStub) GetArgsSlice() ([]byte, error) {
+	var slice []byte
+	for _, arg := range s.args {
+		slice = append(slice, arg...)
+	}
+	return slice, nil
+}
+
+func (s *mockStub) GetCreator() ([]byte, error) {
+	return s.creator, nil
+}
+
+func (s *mockStub) SetEvent(name string, payload []byte) error {
+	return nil
+}
+
+// newCertificate returns a self-signed PEM certificate carrying the
+// attributes in the extension of the Fabric CA.
+func newCertificate(attributes map[string]string) ([]byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "mockledger"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+	}
+	if len(attributes) > 0 {
+		value, err := json.Marshal(map[string]map[string]string{"attrs": attributes})
+		if err != nil {
+			return nil, err
+		}
+		template.ExtraExtensions = []pkix.Extension{{Id: attributesOID, Value: value}}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), nil
+}
@@ -0,0 +1,333 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package gateway
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/manilpuri9/hyperledger-fabric-precision-farming/client/export"
+)
+
+// maxBodyBytes limits the request bodies the gateway reads.
+const maxBodyBytes = 1 << 20
+
+// Server is the http.Handler of the REST API. It keeps no state of its own,
+// every request is served by the chaincode through the Transport.
+type Server struct {
+	Transport Transport
+}
+
+// requestError is a request the gateway rejects before invoking the
+// chaincode.
+type requestError string
+
+func (e requestError) Error() string {
+	return string(e)
+}
+
+func (s Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if path[0] != "crops" || len(path) > 3 || (len(path) > 1 && path[1] == "") {
+		writeError(w, http.StatusNotFound, "no such resource: "+r.URL.Path)
+		return
+	}
+
+	switch {
+	case len(path) == 1 && r.Method == http.MethodGet:
+		s.listCrops(w, r)
+	case len(path) == 1 && r.Method == http.MethodPost:
+		s.createCrop(w, r)
+	case len(path) == 1:
+		methodNotAllowed(w, "GET, POST")
+	case len(path) == 2 && r.Method == http.MethodGet:
+		s.readCrop(w, path[1])
+	case len(path) == 2 && r.Method == http.MethodPatch:
+		s.patchCrop(w, r, path[1])
+	case len(path) == 2 && r.Method == http.MethodDelete:
+		s.deleteCrop(w, path[1])
+	case len(path) == 2:
+		methodNotAllowed(w, "GET, PATCH, DELETE")
+	case path[2] != "irrigations":
+		writeError(w, http.StatusNotFound, "no such resource: "+r.URL.Path)
+	case r.Method == http.MethodGet:
+		s.listIrrigations(w, r, path[1])
+	case r.Method == http.MethodPost:
+		s.recordIrrigation(w, r, path[1])
+	default:
+		methodNotAllowed(w, "GET, POST")
+	}
+}
+
+// listCrops serves GET /crops?owner=…. Listing every crop of the ledger is
+// not supported, the owner is required.
+func (s Server) listCrops(w http.ResponseWriter, r *http.Request) {
+	owner := r.URL.Query().Get("owner")
+	if owner == "" {
+		writeFailure(w, requestError("the owner query parameter is required"), nil)
+		return
+	}
+	payload, err := s.Transport.Evaluate("cropsOfOwner", owner)
+	if err != nil {
+		writeFailure(w, err, nil)
+		return
+	}
+	writePayload(w, http.StatusOK, payload)
+}
+
+// createCrop serves POST /crops and responds with the crop as readCrop
+// returns it. Species, cultivars and fields the crop references but the
+// ledger lacks fail with 422 like any other crop the chaincode rejects.
+func (s Server) createCrop(w http.ResponseWriter, r *http.Request) {
+	var crop Crop
+	err := decodeBody(r, &crop)
+	if err != nil {
+		writeFailure(w, err, nil)
+		return
+	}
+	if crop.Name == "" || crop.Owner == "" {
+		writeFailure(w, requestError("name and owner are required"), nil)
+		return
+	}
+	_, _, err = s.Transport.Submit("initCrop", initCropArgs(crop)...)
+	if err != nil {
+		writeFailure(w, err, cropConflict(crop.Name))
+		return
+	}
+	payload, err := s.Transport.Evaluate("readCrop", crop.Name)
+	if err != nil {
+		writeFailure(w, err, nil)
+		return
+	}
+	w.Header().Set("Location", cropPath(crop.Name))
+	writePayload(w, http.StatusCreated, payload)
+}
+
+func (s Server) readCrop(w http.ResponseWriter, name string) {
+	payload, err := s.Transport.Evaluate("readCrop", name)
+	if err != nil {
+		writeFailure(w, err, cropNotFound(name))
+		return
+	}
+	writePayload(w, http.StatusOK, payload)
+}
+
+// patchCrop serves PATCH /crops/{id}. Only the fields the body names are
+// sent to updateCrop, which leaves the others as they are on the ledger, so
+// concurrent patches of different fields do not undo each other.
+func (s Server) patchCrop(w http.ResponseWriter, r *http.Request, name string) {
+	var fields map[string]json.RawMessage
+	err := decodeBody(r, &fields)
+	if err != nil {
+		writeFailure(w, err, nil)
+		return
+	}
+	for field := range fields {
+		switch {
+		case readingFields[field]:
+			writeFailure(w, requestError(field+" is measured by bound devices and submitted with submitSignedReading"), nil)
+			return
+		case !patchableFields[field]:
+			writeFailure(w, requestError("field "+field+" cannot be patched"), nil)
+			return
+		}
+	}
+	var patch CropPatch
+	body, err := json.Marshal(fields)
+	if err == nil {
+		err = json.Unmarshal(body, &patch)
+	}
+	if err != nil {
+		writeFailure(w, requestError("invalid crop patch: "+err.Error()), nil)
+		return
+	}
+	if patch.Image != nil && *patch.Image == "" {
+		writeFailure(w, requestError("image must be a non-empty string"), nil)
+		return
+	}
+
+	_, _, err = s.Transport.Submit("updateCrop", updateCropArgs(name, patch)...)
+	if err != nil {
+		writeFailure(w, err, cropNotFound(name))
+		return
+	}
+	s.readCrop(w, name)
+}
+
+func (s Server) deleteCrop(w http.ResponseWriter, name string) {
+	_, _, err := s.Transport.Submit("deleteCrop", name)
+	if err != nil {
+		writeFailure(w, err, cropNotFound(name))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// listIrrigations serves GET /crops/{id}/irrigations with optional RFC3339
+// from and to query parameters.
+func (s Server) listIrrigations(w http.ResponseWriter, r *http.Request, name string) {
+	query := r.URL.Query()
+	payload, err := s.Transport.Evaluate("irrigationEventsOf", name, query.Get("from"), query.Get("to"))
+	if err != nil {
+		writeFailure(w, err, cropNotFound(name))
+		return
+	}
+	writePayload(w, http.StatusOK, payload)
+}
+
+// recordIrrigation serves POST /crops/{id}/irrigations and responds with the
+// irrigation event the transaction stored.
+func (s Server) recordIrrigation(w http.ResponseWriter, r *http.Request, name string) {
+	var request IrrigationRequest
+	err := decodeBody(r, &request)
+	if err != nil {
+		writeFailure(w, err, nil)
+		return
+	}
+	if request.AmountMm <= 0 {
+		writeFailure(w, requestError("amount_mm must be a positive number of mm"), nil)
+		return
+	}
+	args := []string{name, formatFloat(request.AmountMm)}
+	if request.IrrigatedAt != "" {
+		args = append(args, request.IrrigatedAt)
+	}
+	_, txID, err := s.Transport.Submit("recordIrrigation", args...)
+	if err != nil {
+		writeFailure(w, err, cropNotFound(name))
+		return
+	}
+
+	// recordIrrigation returns nothing, the event is found by its
+	// transaction among the irrigations of the crop
+	payload, err := s.Transport.Evaluate("irrigationEventsOf", name, request.IrrigatedAt, "")
+	if err != nil {
+		writeFailure(w, err, cropNotFound(name))
+		return
+	}
+	var events []export.Irrigation
+	err = json.Unmarshal(payload, &events)
+	if err != nil {
+		writeFailure(w, err, nil)
+		return
+	}
+	event := export.Irrigation{Crop: name, IrrigatedAt: request.IrrigatedAt, AmountMm: request.AmountMm, TxID: txID}
+	for _, stored := range events {
+		if stored.TxID == txID {
+			event = stored
+		}
+	}
+	w.Header().Set("Location", cropPath(name)+"/irrigations")
+	writeJSON(w, http.StatusCreated, event)
+}
+
+func cropPath(name string) string {
+	return "/crops/" + url.PathEscape(name)
+}
+
+// decodeBody decodes a JSON request body, rejecting unknown fields.
+func decodeBody(r *http.Request, value interface{}) error {
+	decoder := json.NewDecoder(http.MaxBytesReader(nil, r.Body, maxBodyBytes))
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(value)
+	if err != nil {
+		return requestError("invalid request body: " + err.Error())
+	}
+	return nil
+}
+
+// errorStatus maps the message of a chaincode error to the status code of
+// an endpoint, 0 for the messages the endpoint does not tell apart.
+type errorStatus func(message string) int
+
+// cropNotFound is the errorStatus of the endpoints under /crops/{id}: 404 if
+// the crop of the path does not exist. Other missing assets are rejections.
+func cropNotFound(name string) errorStatus {
+	return func(message string) int {
+		// readCrop and deleteCrop wrap their errors in a JSON document
+		if strings.HasSuffix(strings.TrimSuffix(message, `"}`), "crop does not exist: "+name) {
+			return http.StatusNotFound
+		}
+		return 0
+	}
+}
+
+// cropConflict is the errorStatus of POST /crops: 409 if the crop already
+// exists.
+func cropConflict(name string) errorStatus {
+	return func(message string) int {
+		if strings.HasSuffix(message, "already exists: "+name) {
+			return http.StatusConflict
+		}
+		return 0
+	}
+}
+
+// statusOf maps an error to the status code of the response. Chaincode
+// errors the errorStatus of the endpoint does not know are 422.
+func statusOf(err error, known errorStatus) int {
+	switch e := err.(type) {
+	case requestError:
+		return http.StatusBadRequest
+	case *ChaincodeError:
+		if known != nil {
+			if code := known(e.Message); code != 0 {
+				return code
+			}
+		}
+		return http.StatusUnprocessableEntity
+	}
+	return http.StatusBadGateway
+}
+
+func writeFailure(w http.ResponseWriter, err error, known errorStatus) {
+	code := statusOf(err, known)
+	if code == http.StatusBadGateway {
+		log.Printf("gateway: %v", err)
+	}
+	writeError(w, code, err.Error())
+}
+
+func writeError(w http.ResponseWriter, code int, message string) {
+	writeJSON(w, code, map[string]string{"error": message})
+}
+
+func methodNotAllowed(w http.ResponseWriter, allowed string) {
+	w.Header().Set("Allow", allowed)
+	writeError(w, http.StatusMethodNotAllowed, fmt.Sprintf("method not allowed, expecting %s", allowed))
+}
+
+func writeJSON(w http.ResponseWriter, code int, value interface{}) {
+	payload, err := json.Marshal(value)
+	if err != nil {
+		code = http.StatusInternalServerError
+		payload = []byte(`{"error":"failed to encode the response"}`)
+	}
+	writePayload(w, code, payload)
+}
+
+// writePayload writes the JSON payload of a chaincode function as the body.
+func writePayload(w http.ResponseWriter, code int, payload []byte) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(payload)
+}
@@ -0,0 +1,221 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package gateway_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/manilpuri9/hyperledger-fabric-precision-farming/client/export"
+	"github.com/manilpuri9/hyperledger-fabric-precision-farming/client/gateway"
+	"github.com/manilpuri9/hyperledger-fabric-precision-farming/client/gateway/mockledger"
+)
+
+// riceCrop is a POST /crops body. The geo location, latitude and soil
+// moisture keys are those of the stored crop documents.
+const riceCrop = `{"name":"rice1","owner":"manil puri","quantity":400,
+	"farm_info":{"GeoLocation":{"Latitude":43.2,"longitude":21.3},"soil_type":"clay"},
+	"species":"rice",
+	"weather":{"temperature":{"celcius":35},"pressure":{"pascal":4},"humidity":{"cubic_meter":434},"radiation":{"rem":10.3}},
+	"soil_condition":{"moisture":{"cubic meter":32},"ph":3,"nitrogen":{"percentage":1.2},"phosphorus":{"percentage":3.2}},
+	"image":"rice1.jpg","cghc":4}`
+
+// newServer serves a mock ledger whose catalog has the rice species.
+func newServer(t *testing.T) (*httptest.Server, *mockledger.Ledger) {
+	t.Helper()
+	ledger, err := mockledger.New("Org1MSP", map[string]string{"hssf.agronomist": "true"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ledger.Now = func() time.Time { return time.Date(2018, 6, 1, 12, 0, 0, 0, time.UTC) }
+	_, _, err = ledger.Submit("putSpecies", `{"id":"rice","name":"Rice"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(gateway.Server{Transport: ledger})
+	t.Cleanup(server.Close)
+	return server, ledger
+}
+
+// do sends a request and decodes the JSON response into value, if any.
+func do(t *testing.T, server *httptest.Server, method, path, body string, value interface{}) *http.Response {
+	t.Helper()
+	request, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err := server.Client().Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	if value != nil {
+		err = json.NewDecoder(response.Body).Decode(value)
+		if err != nil {
+			t.Fatalf("%s %s: %v", method, path, err)
+		}
+	}
+	return response
+}
+
+func TestCreateCrop(t *testing.T) {
+	server, _ := newServer(t)
+
+	var crop gateway.Crop
+	response := do(t, server, http.MethodPost, "/crops", riceCrop, &crop)
+	if response.StatusCode != http.StatusCreated {
+		t.Fatalf("POST /crops: got status %d, want %d", response.StatusCode, http.StatusCreated)
+	}
+	if location := response.Header.Get("Location"); location != "/crops/rice1" {
+		t.Errorf("POST /crops: got location %q, want /crops/rice1", location)
+	}
+	if crop.Name != "rice1" || crop.Species != "rice" || crop.Quantity != 400 || crop.Weather.Temperature.Celcius != 35 {
+		t.Errorf("POST /crops: got crop %+v", crop)
+	}
+
+	var crops []gateway.Crop
+	response = do(t, server, http.MethodGet, "/crops?owner=manil%20puri", "", &crops)
+	if response.StatusCode != http.StatusOK || len(crops) != 1 || crops[0].Name != "rice1" {
+		t.Errorf("GET /crops: got status %d and crops %+v", response.StatusCode, crops)
+	}
+}
+
+func TestCreateCropStatus(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		body string
+		want int
+	}{
+		{"existing crop", riceCrop, http.StatusConflict},
+		{"unknown species", strings.Replace(riceCrop, `"species":"rice"`, `"species":"wheat"`, 1), http.StatusUnprocessableEntity},
+		{"unknown cultivar", strings.Replace(riceCrop, `"species":"rice"`, `"species":"rice","cultivar":"basmati"`, 1), http.StatusUnprocessableEntity},
+		{"unknown field", strings.Replace(riceCrop, `"species":"rice"`, `"species":"rice","field":"north"`, 1), http.StatusUnprocessableEntity},
+		{"unknown body field", strings.Replace(riceCrop, `"species":"rice"`, `"species":"rice","colour":"green"`, 1), http.StatusBadRequest},
+		{"missing owner", `{"name":"rice2"}`, http.StatusBadRequest},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			server, _ := newServer(t)
+			do(t, server, http.MethodPost, "/crops", riceCrop, nil)
+
+			var failure map[string]string
+			response := do(t, server, http.MethodPost, "/crops", test.body, &failure)
+			if response.StatusCode != test.want {
+				t.Errorf("POST /crops: got status %d (%s), want %d", response.StatusCode, failure["error"], test.want)
+			}
+		})
+	}
+}
+
+func TestUnknownCrop(t *testing.T) {
+	server, _ := newServer(t)
+	do(t, server, http.MethodPost, "/crops", riceCrop, nil)
+
+	for _, test := range []struct {
+		method, path, body string
+	}{
+		{http.MethodGet, "/crops/wheat1", ""},
+		{http.MethodPatch, "/crops/wheat1", `{"image":"wheat1.jpg"}`},
+		{http.MethodDelete, "/crops/wheat1", ""},
+		{http.MethodPost, "/crops/wheat1/irrigations", `{"amount_mm":12.5}`},
+		// a prefix of an existing crop is still unknown
+		{http.MethodGet, "/crops/rice", ""},
+	} {
+		var failure map[string]string
+		response := do(t, server, test.method, test.path, test.body, &failure)
+		if response.StatusCode != http.StatusNotFound {
+			t.Errorf("%s %s: got status %d (%s), want %d", test.method, test.path, response.StatusCode, failure["error"], http.StatusNotFound)
+		}
+	}
+}
+
+func TestPatchCrop(t *testing.T) {
+	server, ledger := newServer(t)
+	do(t, server, http.MethodPost, "/crops", riceCrop, nil)
+
+	var crop gateway.Crop
+	response := do(t, server, http.MethodPatch, "/crops/rice1", `{"image":"rice2.jpg"}`, &crop)
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("PATCH /crops/rice1: got status %d, want %d", response.StatusCode, http.StatusOK)
+	}
+	if crop.Image != "rice2.jpg" || crop.Weather.Temperature.Celcius != 35 || crop.Cghc != 4 {
+		t.Errorf("PATCH /crops/rice1: got crop %+v", crop)
+	}
+	response = do(t, server, http.MethodPatch, "/crops/rice1", `{"cghc":2}`, &crop)
+	if response.StatusCode != http.StatusOK || crop.Image != "rice2.jpg" || crop.Cghc != 2 {
+		t.Errorf("PATCH /crops/rice1 cghc: got status %d and crop %+v", response.StatusCode, crop)
+	}
+
+	// a patch stores no reading, the crop keeps the readings it had
+	var readings []json.RawMessage
+	if payload, err := ledger.Evaluate("queryReadings", "rice1", "", "", ""); err != nil {
+		t.Fatal(err)
+	} else if err = json.Unmarshal(payload, &readings); err != nil || len(readings) != 0 {
+		t.Errorf("readings after PATCH: got %s, %v", payload, err)
+	}
+
+	for _, body := range []string{`{"owner":"someone else"}`, `{"weather":{"temperature":{"celcius":28}}}`, `{"soil_condition":{"ph":7}}`, `{"image":""}`} {
+		response = do(t, server, http.MethodPatch, "/crops/rice1", body, nil)
+		if response.StatusCode != http.StatusBadRequest {
+			t.Errorf("PATCH /crops/rice1 %s: got status %d, want %d", body, response.StatusCode, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestDeleteCrop(t *testing.T) {
+	server, _ := newServer(t)
+	do(t, server, http.MethodPost, "/crops", riceCrop, nil)
+
+	response := do(t, server, http.MethodDelete, "/crops/rice1", "", nil)
+	if response.StatusCode != http.StatusNoContent {
+		t.Fatalf("DELETE /crops/rice1: got status %d, want %d", response.StatusCode, http.StatusNoContent)
+	}
+	response = do(t, server, http.MethodGet, "/crops/rice1", "", nil)
+	if response.StatusCode != http.StatusNotFound {
+		t.Errorf("GET /crops/rice1 after DELETE: got status %d, want %d", response.StatusCode, http.StatusNotFound)
+	}
+}
+
+func TestIrrigations(t *testing.T) {
+	server, _ := newServer(t)
+	do(t, server, http.MethodPost, "/crops", riceCrop, nil)
+
+	var event export.Irrigation
+	response := do(t, server, http.MethodPost, "/crops/rice1/irrigations", `{"amount_mm":12.5,"irrigated_at":"2018-06-01T08:00:00+02:00"}`, &event)
+	if response.StatusCode != http.StatusCreated {
+		t.Fatalf("POST irrigations: got status %d, want %d", response.StatusCode, http.StatusCreated)
+	}
+	if event.Crop != "rice1" || event.AmountMm != 12.5 || event.IrrigatedAt != "2018-06-01T06:00:00Z" || event.TxID == "" {
+		t.Errorf("POST irrigations: got event %+v", event)
+	}
+
+	// the ledger clock is 2018-06-01T12:00:00Z
+	response = do(t, server, http.MethodPost, "/crops/rice1/irrigations", `{"amount_mm":5,"irrigated_at":"2018-06-02T08:00:00Z"}`, nil)
+	if response.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("POST irrigations in advance: got status %d, want %d", response.StatusCode, http.StatusUnprocessableEntity)
+	}
+
+	var events []export.Irrigation
+	response = do(t, server, http.MethodGet, "/crops/rice1/irrigations?from=2018-06-01T00:00:00Z", "", &events)
+	if response.StatusCode != http.StatusOK || len(events) != 1 || events[0] != event {
+		t.Errorf("GET irrigations: got status %d and events %+v", response.StatusCode, events)
+	}
+}
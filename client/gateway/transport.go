@@ -0,0 +1,37 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package gateway
+
+// Transport invokes functions of the crop chaincode. Submit commits a
+// transaction and returns its payload and transaction ID, Evaluate runs a
+// query without one. A function the chaincode rejected fails with a
+// *ChaincodeError, any other error means the ledger could not be reached.
+type Transport interface {
+	Submit(function string, args ...string) ([]byte, string, error)
+	Evaluate(function string, args ...string) ([]byte, error)
+}
+
+// ChaincodeError is the error message a chaincode function returned.
+type ChaincodeError struct {
+	Function string
+	Message  string
+}
+
+func (e *ChaincodeError) Error() string {
+	return e.Function + ": " + e.Message
+}
@@ -0,0 +1,330 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package indexer
+
+import (
+	"database/sql"
+	"encoding/json"
+	"strings"
+
+	"github.com/manilpuri9/hyperledger-fabric-precision-farming/client/export"
+)
+
+// Composite key object types of the chaincode records the mirror keeps.
+// Crop base records are the only records stored under plain keys.
+// Fields are kept for the location of the crops on them.
+const (
+	fieldObjectType         = "field"
+	cropPartObjectType      = "crop~part"
+	readingObjectType       = "reading~crop~sensor~timestamp"
+	irrigationObjectType    = "crop~irrigatedat~irrigation"
+	fertilizerAppObjectType = "crop~appliedat~fertilizerapp"
+	pesticideAppObjectType  = "crop~appliedat~pesticideapp"
+)
+
+// compositeKeyNamespace starts every composite key created by the chaincode.
+const compositeKeyNamespace = "\x00"
+
+// conditionsRecord is the part of the crop conditions sub-record with columns.
+type conditionsRecord struct {
+	Weather       export.WeatherType       `json:"weather"`
+	SoilCondition export.SoilConditionType `json:"soil_condition"`
+	LatestReading struct {
+		Timestamp string `json:"timestamp"`
+	} `json:"latest_reading"`
+	FrostRisk string `json:"frost_risk"`
+	Pathology struct {
+		Level int `json:"level"`
+	} `json:"pathology"`
+	Health struct {
+		Score      int    `json:"score"`
+		ComputedAt string `json:"computed_at"`
+	} `json:"health"`
+	GrowthStage string `json:"growth_stage"`
+}
+
+// activitiesRecord is the crop activities sub-record.
+type activitiesRecord struct {
+	Irrigation     bool `json:"irrigation"`
+	AddFertilizer  bool `json:"fertilizer_addition"`
+	ApplyPesticide bool `json:"apply_pesticide"`
+}
+
+// fieldRecord is the part of a field record with columns.
+type fieldRecord struct {
+	ID           string                 `json:"id"`
+	Farm         string                 `json:"farm"`
+	Owner        string                 `json:"owner"`
+	AreaHectares float64                `json:"area_hectares"`
+	GeoLocation  export.GeoLocationType `json:"geo_location"`
+}
+
+// legacyPartFields are the fields that mark a crop base record written
+// before the crop was split into sub-records, when the base record held the
+// whole crop, by the sub-record they belong to.
+var legacyPartFields = map[string]string{
+	"conditions": "weather",
+	"activities": "irrigation",
+	"status":     "harvesting",
+}
+
+// statusRecord is the crop status sub-record.
+type statusRecord struct {
+	Harvesting bool `json:"harvesting"`
+}
+
+// splitCompositeKey returns the object type and attributes of a composite
+// key, ok is false for a plain key.
+func splitCompositeKey(key string) (string, []string, bool) {
+	if !strings.HasPrefix(key, compositeKeyNamespace) {
+		return "", nil, false
+	}
+	parts := strings.Split(strings.TrimSuffix(key[1:], "\x00"), "\x00")
+	return parts[0], parts[1:], true
+}
+
+// applyWrite mirrors one write. Writes to records the mirror does not keep
+// are ignored.
+func applyWrite(tx *sql.Tx, block uint64, transaction Transaction, write Write) error {
+	objectType, attributes, composite := splitCompositeKey(write.Key)
+	if !composite {
+		if err := recordHistory(tx, block, transaction, write, write.Key, "info"); err != nil {
+			return err
+		}
+		return applyCropInfo(tx, transaction, write)
+	}
+
+	switch objectType {
+	case fieldObjectType:
+		if len(attributes) != 1 {
+			return nil
+		}
+		return applyField(tx, write)
+	case cropPartObjectType:
+		if len(attributes) != 2 {
+			return nil
+		}
+		if err := recordHistory(tx, block, transaction, write, attributes[0], attributes[1]); err != nil {
+			return err
+		}
+		return applyCropPart(tx, transaction, write, attributes[0], attributes[1])
+	case readingObjectType:
+		return applyReading(tx, transaction, write)
+	case irrigationObjectType:
+		return applyIrrigation(tx, write)
+	case fertilizerAppObjectType:
+		return applyFertilization(tx, write)
+	case pesticideAppObjectType:
+		return applyPesticideApplication(tx, write)
+	}
+	return nil
+}
+
+// recordHistory appends a write of a crop record to its history.
+func recordHistory(tx *sql.Tx, block uint64, transaction Transaction, write Write, crop, record string) error {
+	var value interface{}
+	if !write.IsDelete {
+		value = string(write.Value)
+	}
+	_, err := tx.Exec(`INSERT INTO crop_history (block, tx_index, tx_id, timestamp, crop, record, is_delete, value)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		block, transaction.Index, transaction.ID, transaction.Timestamp, crop, record, write.IsDelete, value)
+	return err
+}
+
+// applyCropInfo mirrors a crop base record. Crops on a field are located at
+// the field. Base records written before the crop was split into sub-records
+// hold the whole crop and are mirrored into the sub-record tables as well.
+func applyCropInfo(tx *sql.Tx, transaction Transaction, write Write) error {
+	if write.IsDelete {
+		_, err := tx.Exec(`DELETE FROM crops WHERE name = ?`, write.Key)
+		return err
+	}
+	var info export.CropInfo
+	if err := json.Unmarshal(write.Value, &info); err != nil {
+		return err
+	}
+	location := info.FarmInfo.GeoLocation
+	if info.Field != "" {
+		err := tx.QueryRow(`SELECT latitude, longitude FROM fields WHERE id = ?`, info.Field).
+			Scan(&location.Latitude, &location.Longitude)
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+	}
+	_, err := tx.Exec(`INSERT OR REPLACE INTO crops
+		(name, owner, quantity, latitude, longitude, soil_type, field, species, cultivar, planted_at, tx_id, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		write.Key, info.Owner, info.Quantity, location.Latitude, location.Longitude,
+		info.FarmInfo.SoilType, info.Field, info.Species, info.Cultivar, info.PlantedAt, transaction.ID, transaction.Timestamp)
+	if err != nil {
+		return err
+	}
+
+	var fields map[string]json.RawMessage
+	if err = json.Unmarshal(write.Value, &fields); err != nil {
+		return err
+	}
+	for _, part := range []string{"conditions", "activities", "status"} {
+		if _, legacy := fields[legacyPartFields[part]]; !legacy {
+			continue
+		}
+		// the flat legacy document has the fields of every sub-record
+		if err = applyCropPart(tx, transaction, write, write.Key, part); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyField mirrors a field and moves the crops on it to its location.
+func applyField(tx *sql.Tx, write Write) error {
+	if write.IsDelete {
+		return nil
+	}
+	var field fieldRecord
+	if err := json.Unmarshal(write.Value, &field); err != nil {
+		return err
+	}
+	_, err := tx.Exec(`INSERT OR REPLACE INTO fields (id, farm, owner, area_hectares, latitude, longitude)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		field.ID, field.Farm, field.Owner, field.AreaHectares, field.GeoLocation.Latitude, field.GeoLocation.Longitude)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`UPDATE crops SET latitude = ?, longitude = ? WHERE field = ?`,
+		field.GeoLocation.Latitude, field.GeoLocation.Longitude, field.ID)
+	return err
+}
+
+func applyCropPart(tx *sql.Tx, transaction Transaction, write Write, crop, part string) error {
+	table := map[string]string{
+		"conditions": "crop_conditions",
+		"activities": "crop_activities",
+		"status":     "crop_status",
+	}[part]
+	if table == "" {
+		return nil
+	}
+	if write.IsDelete {
+		_, err := tx.Exec(`DELETE FROM `+table+` WHERE crop = ?`, crop)
+		return err
+	}
+
+	var err error
+	switch part {
+	case "conditions":
+		var conditions conditionsRecord
+		if err = json.Unmarshal(write.Value, &conditions); err != nil {
+			return err
+		}
+		var health interface{}
+		if conditions.Health.ComputedAt != "" {
+			health = conditions.Health.Score
+		}
+		weather, soil := conditions.Weather, conditions.SoilCondition
+		_, err = tx.Exec(`INSERT OR REPLACE INTO crop_conditions
+			(crop, celcius, pascal, humidity_cubic_meter, radiation_rem, moisture_cubic_meter, ph,
+			nitrogen_percentage, phosphorus_percentage, potassium_percentage, latest_reading_at,
+			frost_risk, pathology_level, health_score, growth_stage, document, tx_id, updated_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			crop, weather.Temperature.Celcius, weather.Pressure.Pascal, weather.Humidity.CubicMeter, weather.Radiation.Rem,
+			soil.Moisture.CubicMeter, soil.Ph, soil.Nitrogen.Percentage, soil.Phosphorus.Percentage, soil.Potassium.Percentage,
+			conditions.LatestReading.Timestamp, conditions.FrostRisk, conditions.Pathology.Level, health,
+			conditions.GrowthStage, string(write.Value), transaction.ID, transaction.Timestamp)
+	case "activities":
+		var activities activitiesRecord
+		if err = json.Unmarshal(write.Value, &activities); err != nil {
+			return err
+		}
+		_, err = tx.Exec(`INSERT OR REPLACE INTO crop_activities
+			(crop, irrigation, fertilizer_addition, apply_pesticide, tx_id, updated_at) VALUES (?, ?, ?, ?, ?, ?)`,
+			crop, activities.Irrigation, activities.AddFertilizer, activities.ApplyPesticide, transaction.ID, transaction.Timestamp)
+	case "status":
+		var status statusRecord
+		if err = json.Unmarshal(write.Value, &status); err != nil {
+			return err
+		}
+		_, err = tx.Exec(`INSERT OR REPLACE INTO crop_status
+			(crop, harvesting, tx_id, updated_at) VALUES (?, ?, ?, ?)`,
+			crop, status.Harvesting, transaction.ID, transaction.Timestamp)
+	}
+	return err
+}
+
+func applyReading(tx *sql.Tx, transaction Transaction, write Write) error {
+	if write.IsDelete {
+		return nil
+	}
+	var reading export.Reading
+	if err := json.Unmarshal(write.Value, &reading); err != nil {
+		return err
+	}
+	weather, soil := reading.Weather, reading.SoilCondition
+	_, err := tx.Exec(`INSERT OR REPLACE INTO readings
+		(crop, sensor, timestamp, celcius, pascal, humidity_cubic_meter, radiation_rem, moisture_cubic_meter, ph,
+		nitrogen_percentage, phosphorus_percentage, potassium_percentage, tx_id)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		reading.Crop, reading.Sensor, reading.Timestamp, weather.Temperature.Celcius, weather.Pressure.Pascal,
+		weather.Humidity.CubicMeter, weather.Radiation.Rem, soil.Moisture.CubicMeter, soil.Ph,
+		soil.Nitrogen.Percentage, soil.Phosphorus.Percentage, soil.Potassium.Percentage, transaction.ID)
+	return err
+}
+
+func applyIrrigation(tx *sql.Tx, write Write) error {
+	if write.IsDelete {
+		return nil
+	}
+	var irrigation export.Irrigation
+	if err := json.Unmarshal(write.Value, &irrigation); err != nil {
+		return err
+	}
+	_, err := tx.Exec(`INSERT OR REPLACE INTO irrigations (crop, irrigated_at, tx_id, amount_mm) VALUES (?, ?, ?, ?)`,
+		irrigation.Crop, irrigation.IrrigatedAt, irrigation.TxID, irrigation.AmountMm)
+	return err
+}
+
+func applyFertilization(tx *sql.Tx, write Write) error {
+	if write.IsDelete {
+		return nil
+	}
+	var fertilization export.Fertilization
+	if err := json.Unmarshal(write.Value, &fertilization); err != nil {
+		return err
+	}
+	_, err := tx.Exec(`INSERT OR REPLACE INTO fertilizer_applications
+		(crop, applied_at, tx_id, product, rate_kg_ha, nitrogen_kg_ha, phosphorus_kg_ha, potassium_kg_ha)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		fertilization.Crop, fertilization.AppliedAt, fertilization.TxID, fertilization.Product, fertilization.RateKgHa,
+		fertilization.NitrogenKgHa, fertilization.PhosphorusKgHa, fertilization.PotassiumKgHa)
+	return err
+}
+
+func applyPesticideApplication(tx *sql.Tx, write Write) error {
+	if write.IsDelete {
+		return nil
+	}
+	var application export.PesticideApplication
+	if err := json.Unmarshal(write.Value, &application); err != nil {
+		return err
+	}
+	_, err := tx.Exec(`INSERT OR REPLACE INTO pesticide_applications
+		(crop, applied_at, tx_id, product, dose_per_hectare, unit) VALUES (?, ?, ?, ?, ?, ?)`,
+		application.Crop, application.AppliedAt, application.TxID, application.Product, application.DosePerHectare, application.Unit)
+	return err
+}
@@ -0,0 +1,174 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package indexer
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric-protos-go/common"
+	"github.com/hyperledger/fabric-protos-go/ledger/rwset"
+	"github.com/hyperledger/fabric-protos-go/ledger/rwset/kvrwset"
+	"github.com/hyperledger/fabric-protos-go/peer"
+	"github.com/hyperledger/fabric-sdk-go/pkg/client/event"
+	providers "github.com/hyperledger/fabric-sdk-go/pkg/common/providers/context"
+	"github.com/hyperledger/fabric-sdk-go/pkg/fab/events/deliverclient/seek"
+	"github.com/manilpuri9/hyperledger-fabric-precision-farming/client/export"
+)
+
+// FabricBlockSource delivers the blocks of a channel from the deliver service
+// of its peers, keeping the writes to one chaincode.
+type FabricBlockSource struct {
+	Channel   providers.ChannelProvider
+	Chaincode string
+}
+
+// Blocks implements BlockSource. The deliver service replays the ledger from
+// the requested block and then streams new blocks as they are committed.
+func (s FabricBlockSource) Blocks(ctx context.Context, from uint64) (<-chan *Block, <-chan error) {
+	blocks := make(chan *Block)
+	errs := make(chan error, 1)
+
+	client, err := event.New(s.Channel, event.WithBlockEvents(), event.WithSeekType(seek.FromBlock), event.WithBlockNum(from))
+	if err != nil {
+		errs <- err
+		return blocks, errs
+	}
+	registration, events, err := client.RegisterBlockEvent()
+	if err != nil {
+		errs <- err
+		return blocks, errs
+	}
+
+	go func() {
+		defer client.Unregister(registration)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case blockEvent, ok := <-events:
+				if !ok {
+					errs <- fmt.Errorf("block event stream closed")
+					return
+				}
+				block, err := ParseBlock(blockEvent.Block, s.Chaincode)
+				if err != nil {
+					errs <- err
+					return
+				}
+				select {
+				case blocks <- block:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return blocks, errs
+}
+
+// ParseBlock reduces a block to its endorser transactions and their writes to
+// the chaincode namespace. Configuration transactions keep their position but
+// have no writes.
+func ParseBlock(block *common.Block, chaincode string) (*Block, error) {
+	parsed := &Block{Number: block.Header.Number}
+	var filter []byte
+	if len(block.Metadata.Metadata) > int(common.BlockMetadataIndex_TRANSACTIONS_FILTER) {
+		filter = block.Metadata.Metadata[common.BlockMetadataIndex_TRANSACTIONS_FILTER]
+	}
+
+	for index, data := range block.Data.Data {
+		envelope := &common.Envelope{}
+		if err := proto.Unmarshal(data, envelope); err != nil {
+			return nil, fmt.Errorf("block %d transaction %d: %v", parsed.Number, index, err)
+		}
+		payload := &common.Payload{}
+		if err := proto.Unmarshal(envelope.Payload, payload); err != nil {
+			return nil, fmt.Errorf("block %d transaction %d: %v", parsed.Number, index, err)
+		}
+		header := &common.ChannelHeader{}
+		if err := proto.Unmarshal(payload.Header.ChannelHeader, header); err != nil {
+			return nil, fmt.Errorf("block %d transaction %d: %v", parsed.Number, index, err)
+		}
+
+		transaction := Transaction{
+			ID:    header.TxId,
+			Index: index,
+			Valid: index < len(filter) && filter[index] == byte(peer.TxValidationCode_VALID),
+		}
+		if header.Timestamp != nil {
+			// the layout of the chaincode timestamps, so updated_at compares with them
+			transaction.Timestamp = time.Unix(header.Timestamp.Seconds, int64(header.Timestamp.Nanos)).UTC().Format(export.TimeLayout)
+		}
+		if header.Type == int32(common.HeaderType_ENDORSER_TRANSACTION) {
+			writes, err := transactionWrites(payload.Data, chaincode)
+			if err != nil {
+				return nil, fmt.Errorf("block %d transaction %s: %v", parsed.Number, transaction.ID, err)
+			}
+			transaction.Writes = writes
+		}
+		parsed.Transactions = append(parsed.Transactions, transaction)
+	}
+	return parsed, nil
+}
+
+// transactionWrites returns the writes of an endorser transaction to the
+// chaincode namespace.
+func transactionWrites(data []byte, chaincode string) ([]Write, error) {
+	transaction := &peer.Transaction{}
+	if err := proto.Unmarshal(data, transaction); err != nil {
+		return nil, err
+	}
+	var writes []Write
+	for _, action := range transaction.Actions {
+		actionPayload := &peer.ChaincodeActionPayload{}
+		if err := proto.Unmarshal(action.Payload, actionPayload); err != nil {
+			return nil, err
+		}
+		if actionPayload.Action == nil {
+			continue
+		}
+		responsePayload := &peer.ProposalResponsePayload{}
+		if err := proto.Unmarshal(actionPayload.Action.ProposalResponsePayload, responsePayload); err != nil {
+			return nil, err
+		}
+		chaincodeAction := &peer.ChaincodeAction{}
+		if err := proto.Unmarshal(responsePayload.Extension, chaincodeAction); err != nil {
+			return nil, err
+		}
+		readWriteSet := &rwset.TxReadWriteSet{}
+		if err := proto.Unmarshal(chaincodeAction.Results, readWriteSet); err != nil {
+			return nil, err
+		}
+		for _, namespace := range readWriteSet.NsRwset {
+			if namespace.Namespace != chaincode {
+				continue
+			}
+			kvSet := &kvrwset.KVRWSet{}
+			if err := proto.Unmarshal(namespace.Rwset, kvSet); err != nil {
+				return nil, err
+			}
+			for _, write := range kvSet.Writes {
+				writes = append(writes, Write{Key: write.Key, Value: write.Value, IsDelete: write.IsDelete})
+			}
+		}
+	}
+	return writes, nil
+}
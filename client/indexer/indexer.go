@@ -0,0 +1,178 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+// Package indexer mirrors the state of the precision farming chaincode into a
+// normalized SQLite database for analytics, so questions about crops,
+// readings and activities no longer run as CouchDB queries on the endorsing
+// peers.
+//
+// The indexer consumes the blocks of the channel in order and applies the
+// writes of the valid transactions of the chaincode. Each block is applied in
+// one SQL transaction together with the checkpoint, the number of the next
+// block to apply, so an interrupted indexer resumes exactly where it stopped
+// and the mirror can be rebuilt from the genesis block at any time.
+package indexer
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	// registers the sqlite3 database/sql driver
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// Write is one key written or deleted by a transaction.
+type Write struct {
+	Key      string
+	Value    []byte
+	IsDelete bool
+}
+
+// Transaction is a transaction of a block with its writes to the chaincode
+// namespace. Invalid transactions are kept so the position inside the block
+// stays visible, their writes are never applied. Timestamp is in
+// export.TimeLayout like the timestamps the chaincode stores.
+type Transaction struct {
+	ID        string
+	Index     int
+	Timestamp string
+	Valid     bool
+	Writes    []Write
+}
+
+// Block is a block of the channel reduced to the chaincode transactions.
+type Block struct {
+	Number       uint64
+	Transactions []Transaction
+}
+
+// BlockSource delivers the blocks of the channel in order starting at a block
+// number, waiting for new blocks once the end of the ledger is reached. The
+// error channel reports a failure after which no more blocks are delivered.
+type BlockSource interface {
+	Blocks(ctx context.Context, from uint64) (<-chan *Block, <-chan error)
+}
+
+// Indexer maintains the SQLite mirror.
+type Indexer struct {
+	db *sql.DB
+}
+
+// Open opens or creates the mirror database at path.
+func Open(path string) (*Indexer, error) {
+	db, err := sql.Open("sqlite3", path+"?_foreign_keys=on&_journal_mode=WAL")
+	if err != nil {
+		return nil, err
+	}
+	// sqlite serialises writers, one connection avoids busy errors
+	db.SetMaxOpenConns(1)
+	if _, err = db.Exec(schema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("create schema: %v", err)
+	}
+	return &Indexer{db: db}, nil
+}
+
+// DB returns the mirror database for queries.
+func (ix *Indexer) DB() *sql.DB {
+	return ix.db
+}
+
+// Close closes the mirror database.
+func (ix *Indexer) Close() error {
+	return ix.db.Close()
+}
+
+// Checkpoint returns the number of the next block to apply, 0 for an empty
+// mirror.
+func (ix *Indexer) Checkpoint() (uint64, error) {
+	var next uint64
+	err := ix.db.QueryRow(`SELECT next_block FROM checkpoint WHERE id = 1`).Scan(&next)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return next, err
+}
+
+// Rebuild empties the mirror so the next Run applies the ledger again from
+// the genesis block.
+func (ix *Indexer) Rebuild() error {
+	_, err := ix.db.Exec(dropSchema + schema)
+	return err
+}
+
+// Run applies the blocks of the source from the checkpoint on until the
+// context is cancelled or the source fails.
+func (ix *Indexer) Run(ctx context.Context, source BlockSource) error {
+	next, err := ix.Checkpoint()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	blocks, errs := source.Blocks(ctx, next)
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case err := <-errs:
+			return err
+		case block, ok := <-blocks:
+			if !ok {
+				return nil
+			}
+			if block.Number < next {
+				// redelivered after a reconnect, already applied
+				continue
+			}
+			if block.Number > next {
+				return fmt.Errorf("expected block %d, source delivered block %d", next, block.Number)
+			}
+			if err = ix.Apply(block); err != nil {
+				return fmt.Errorf("apply block %d: %v", block.Number, err)
+			}
+			next++
+		}
+	}
+}
+
+// Apply applies the writes of the valid transactions of a block and moves the
+// checkpoint past it, all in one SQL transaction.
+func (ix *Indexer) Apply(block *Block) error {
+	tx, err := ix.db.Begin()
+	if err != nil {
+		return err
+	}
+	for _, transaction := range block.Transactions {
+		if !transaction.Valid {
+			continue
+		}
+		for _, write := range transaction.Writes {
+			if err = applyWrite(tx, block.Number, transaction, write); err != nil {
+				tx.Rollback()
+				return fmt.Errorf("transaction %s key %q: %v", transaction.ID, write.Key, err)
+			}
+		}
+	}
+	_, err = tx.Exec(`INSERT OR REPLACE INTO checkpoint (id, next_block) VALUES (1, ?)`, block.Number+1)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,284 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package indexer
+
+import (
+	"database/sql"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/hyperledger/fabric-protos-go/common"
+	"github.com/hyperledger/fabric-protos-go/ledger/rwset"
+	"github.com/hyperledger/fabric-protos-go/ledger/rwset/kvrwset"
+	"github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// compositeKey builds a key the way the chaincode shim does.
+func compositeKey(objectType string, attributes ...string) string {
+	return compositeKeyNamespace + objectType + "\x00" + strings.Join(attributes, "\x00") + "\x00"
+}
+
+// marshal encodes a protobuf message of a synthetic block.
+func marshal(t *testing.T, message proto.Message) []byte {
+	t.Helper()
+	data, err := proto.Marshal(message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// envelope builds a transaction envelope. Endorser transactions carry the
+// writes by namespace, other transaction types carry no data.
+func envelope(t *testing.T, headerType common.HeaderType, txID string, seconds int64, nanos int32, writes map[string][]*kvrwset.KVWrite) []byte {
+	t.Helper()
+	header := &common.ChannelHeader{
+		Type:      int32(headerType),
+		TxId:      txID,
+		Timestamp: &timestamp.Timestamp{Seconds: seconds, Nanos: nanos},
+	}
+	payload := &common.Payload{Header: &common.Header{ChannelHeader: marshal(t, header)}}
+	if headerType == common.HeaderType_ENDORSER_TRANSACTION {
+		readWriteSet := &rwset.TxReadWriteSet{DataModel: rwset.TxReadWriteSet_KV}
+		for namespace, kvWrites := range writes {
+			readWriteSet.NsRwset = append(readWriteSet.NsRwset, &rwset.NsReadWriteSet{
+				Namespace: namespace,
+				Rwset:     marshal(t, &kvrwset.KVRWSet{Writes: kvWrites}),
+			})
+		}
+		responsePayload := &peer.ProposalResponsePayload{
+			Extension: marshal(t, &peer.ChaincodeAction{Results: marshal(t, readWriteSet)}),
+		}
+		actionPayload := &peer.ChaincodeActionPayload{
+			Action: &peer.ChaincodeEndorsedAction{ProposalResponsePayload: marshal(t, responsePayload)},
+		}
+		payload.Data = marshal(t, &peer.Transaction{
+			Actions: []*peer.TransactionAction{{Payload: marshal(t, actionPayload)}},
+		})
+	}
+	return marshal(t, &common.Envelope{Payload: marshal(t, payload)})
+}
+
+func TestParseBlock(t *testing.T) {
+	// 2018-06-01T12:00:00Z
+	const seconds = 1527854400
+	block := &common.Block{
+		Header: &common.BlockHeader{Number: 7},
+		Data: &common.BlockData{Data: [][]byte{
+			envelope(t, common.HeaderType_ENDORSER_TRANSACTION, "tx0", seconds, 500000000, map[string][]*kvrwset.KVWrite{
+				"mycc": {
+					{Key: "rice1", Value: []byte(`{"name":"rice1"}`)},
+					{Key: compositeKey("crop~part", "rice1", "status"), IsDelete: true},
+				},
+				"lscc": {{Key: "mycc", Value: []byte("definition")}},
+			}),
+			envelope(t, common.HeaderType_CONFIG, "", seconds+1, 0, nil),
+			envelope(t, common.HeaderType_ENDORSER_TRANSACTION, "tx2", seconds+2, 0, map[string][]*kvrwset.KVWrite{
+				"mycc": {{Key: "rice2", Value: []byte(`{"name":"rice2"}`)}},
+			}),
+		}},
+		Metadata: &common.BlockMetadata{Metadata: [][]byte{{}, {}, {
+			byte(peer.TxValidationCode_VALID),
+			byte(peer.TxValidationCode_VALID),
+			byte(peer.TxValidationCode_MVCC_READ_CONFLICT),
+		}}},
+	}
+
+	parsed, err := ParseBlock(block, "mycc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Number != 7 {
+		t.Errorf("block number %d, want 7", parsed.Number)
+	}
+	tests := []struct {
+		name string
+		want Transaction
+	}{
+		{"endorser writes of the chaincode only", Transaction{
+			ID:        "tx0",
+			Index:     0,
+			Timestamp: "2018-06-01T12:00:00Z",
+			Valid:     true,
+			Writes: []Write{
+				{Key: "rice1", Value: []byte(`{"name":"rice1"}`)},
+				{Key: compositeKey("crop~part", "rice1", "status"), IsDelete: true},
+			},
+		}},
+		{"configuration keeps its position", Transaction{
+			Index:     1,
+			Timestamp: "2018-06-01T12:00:01Z",
+			Valid:     true,
+		}},
+		{"invalid transaction is kept", Transaction{
+			ID:        "tx2",
+			Index:     2,
+			Timestamp: "2018-06-01T12:00:02Z",
+			Writes:    []Write{{Key: "rice2", Value: []byte(`{"name":"rice2"}`)}},
+		}},
+	}
+	if len(parsed.Transactions) != len(tests) {
+		t.Fatalf("%d transactions, want %d", len(parsed.Transactions), len(tests))
+	}
+	for i, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := parsed.Transactions[i]; !reflect.DeepEqual(got, test.want) {
+				t.Errorf("transaction\n got %+v\nwant %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestApplyWrite(t *testing.T) {
+	transaction := Transaction{ID: "tx1", Index: 0, Timestamp: "2018-06-01T12:00:00Z", Valid: true}
+	cropInfo := `{"name":"rice1","owner":"manil","quantity":400,"farm_info":{"GeoLocation":{"Latitude":43.2,"longitude":21.3},"soil_type":"clay"},"species":"rice"}`
+	tests := []struct {
+		name   string
+		writes []Write
+		query  string
+		want   []string
+	}{
+		{
+			name:   "crop base record",
+			writes: []Write{{Key: "rice1", Value: []byte(cropInfo)}},
+			query:  `SELECT name || ' ' || owner || ' ' || latitude || ' ' || species || ' ' || updated_at FROM crops`,
+			want:   []string{"rice1 manil 43.2 rice 2018-06-01T12:00:00Z"},
+		},
+		{
+			name: "legacy base record fills the sub-record tables",
+			writes: []Write{{Key: "rice1", Value: []byte(`{"name":"rice1","owner":"manil","weather":{"temperature":{"celcius":35}},` +
+				`"irrigation":true,"harvesting":true}`)}},
+			query: `SELECT 'conditions ' || celcius FROM crop_conditions UNION ALL
+				SELECT 'activities ' || irrigation FROM crop_activities UNION ALL
+				SELECT 'status ' || harvesting FROM crop_status`,
+			want: []string{"conditions 35.0", "activities 1", "status 1"},
+		},
+		{
+			name:   "crop sub-record under a composite key",
+			writes: []Write{{Key: compositeKey("crop~part", "rice1", "status"), Value: []byte(`{"harvesting":true}`)}},
+			query:  `SELECT crop || ' ' || harvesting || ' ' || tx_id FROM crop_status`,
+			want:   []string{"rice1 1 tx1"},
+		},
+		{
+			name: "deleted sub-record",
+			writes: []Write{
+				{Key: compositeKey("crop~part", "rice1", "status"), Value: []byte(`{"harvesting":true}`)},
+				{Key: compositeKey("crop~part", "rice1", "status"), IsDelete: true},
+			},
+			query: `SELECT crop FROM crop_status`,
+		},
+		{
+			name: "deleted base record",
+			writes: []Write{
+				{Key: "rice1", Value: []byte(cropInfo)},
+				{Key: "rice1", IsDelete: true},
+			},
+			query: `SELECT name FROM crops`,
+		},
+		{
+			name: "history of base and sub-records",
+			writes: []Write{
+				{Key: "rice1", Value: []byte(cropInfo)},
+				{Key: compositeKey("crop~part", "rice1", "activities"), Value: []byte(`{"irrigation":true}`)},
+				{Key: "rice1", IsDelete: true},
+			},
+			query: `SELECT crop || ' ' || record || ' ' || is_delete || ' ' || timestamp FROM crop_history ORDER BY rowid`,
+			want: []string{
+				"rice1 info 0 2018-06-01T12:00:00Z",
+				"rice1 activities 0 2018-06-01T12:00:00Z",
+				"rice1 info 1 2018-06-01T12:00:00Z",
+			},
+		},
+		{
+			name: "field moves the crops on it",
+			writes: []Write{
+				{Key: "rice1", Value: []byte(`{"name":"rice1","owner":"manil","field":"field1"}`)},
+				{Key: compositeKey("field", "field1"), Value: []byte(`{"id":"field1","farm":"farm1","geo_location":{"Latitude":44.5,"longitude":20.5}}`)},
+			},
+			query: `SELECT name || ' ' || latitude || ' ' || longitude FROM crops`,
+			want:  []string{"rice1 44.5 20.5"},
+		},
+		{
+			name: "reading",
+			writes: []Write{{Key: compositeKey("reading~crop~sensor~timestamp", "rice1", "s1", "2018-06-01T11:00:00Z"),
+				Value: []byte(`{"crop":"rice1","sensor":"s1","timestamp":"2018-06-01T11:00:00Z","weather":{"temperature":{"celcius":21}}}`)}},
+			query: `SELECT crop || ' ' || sensor || ' ' || timestamp || ' ' || celcius || ' ' || tx_id FROM readings`,
+			want:  []string{"rice1 s1 2018-06-01T11:00:00Z 21.0 tx1"},
+		},
+		{
+			name:   "records the mirror does not keep",
+			writes: []Write{{Key: compositeKey("crop~timestamp~sensor~txid~frostalert", "rice1", "2018-06-01T11:00:00Z", "s1", "tx0"), Value: []byte(`{}`)}},
+			query:  `SELECT crop FROM crop_history`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ix, err := Open(filepath.Join(t.TempDir(), "mirror.db"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer ix.Close()
+
+			tx, err := ix.db.Begin()
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, write := range test.writes {
+				if err = applyWrite(tx, 3, transaction, write); err != nil {
+					tx.Rollback()
+					t.Fatalf("write %q: %v", write.Key, err)
+				}
+			}
+			if err = tx.Commit(); err != nil {
+				t.Fatal(err)
+			}
+
+			got := queryStrings(t, ix.db, test.query)
+			if len(got) != len(test.want) || (len(got) > 0 && !reflect.DeepEqual(got, test.want)) {
+				t.Errorf("rows\n got %q\nwant %q", got, test.want)
+			}
+		})
+	}
+}
+
+// queryStrings returns the single string column of the rows of a query.
+func queryStrings(t *testing.T, db *sql.DB, query string) []string {
+	t.Helper()
+	rows, err := db.Query(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var values []string
+	for rows.Next() {
+		var value string
+		if err = rows.Scan(&value); err != nil {
+			t.Fatal(err)
+		}
+		values = append(values, value)
+	}
+	if err = rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return values
+}
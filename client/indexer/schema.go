@@ -0,0 +1,167 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package indexer
+
+// schema is the normalized mirror. Crops are split like the chaincode stores
+// them, the base record in crops and one table per sub-record. Fields locate
+// the crops on them. Documents keep
+// the full JSON of records whose nested values have no columns.
+const schema = `
+CREATE TABLE IF NOT EXISTS checkpoint (
+	id         INTEGER PRIMARY KEY CHECK (id = 1),
+	next_block INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS crops (
+	name       TEXT PRIMARY KEY,
+	owner      TEXT,
+	quantity   INTEGER,
+	latitude   REAL,
+	longitude  REAL,
+	soil_type  TEXT,
+	field      TEXT,
+	species    TEXT,
+	cultivar   TEXT,
+	planted_at TEXT,
+	tx_id      TEXT,
+	updated_at TEXT
+);
+CREATE INDEX IF NOT EXISTS crops_owner ON crops (owner);
+CREATE INDEX IF NOT EXISTS crops_species ON crops (species);
+
+CREATE TABLE IF NOT EXISTS fields (
+	id            TEXT PRIMARY KEY,
+	farm          TEXT,
+	owner         TEXT,
+	area_hectares REAL,
+	latitude      REAL,
+	longitude     REAL
+);
+
+CREATE TABLE IF NOT EXISTS crop_conditions (
+	crop                  TEXT PRIMARY KEY,
+	celcius               REAL,
+	pascal                REAL,
+	humidity_cubic_meter  REAL,
+	radiation_rem         REAL,
+	moisture_cubic_meter  REAL,
+	ph                    INTEGER,
+	nitrogen_percentage   REAL,
+	phosphorus_percentage REAL,
+	potassium_percentage  REAL,
+	latest_reading_at     TEXT,
+	frost_risk            TEXT,
+	pathology_level       INTEGER,
+	health_score          INTEGER,
+	growth_stage          TEXT,
+	document              TEXT,
+	tx_id                 TEXT,
+	updated_at            TEXT
+);
+CREATE INDEX IF NOT EXISTS crop_conditions_health ON crop_conditions (health_score);
+
+CREATE TABLE IF NOT EXISTS crop_activities (
+	crop                TEXT PRIMARY KEY,
+	irrigation          INTEGER,
+	fertilizer_addition INTEGER,
+	apply_pesticide     INTEGER,
+	tx_id               TEXT,
+	updated_at          TEXT
+);
+
+CREATE TABLE IF NOT EXISTS crop_status (
+	crop       TEXT PRIMARY KEY,
+	harvesting INTEGER,
+	tx_id      TEXT,
+	updated_at TEXT
+);
+
+CREATE TABLE IF NOT EXISTS readings (
+	crop                  TEXT NOT NULL,
+	sensor                TEXT NOT NULL,
+	timestamp             TEXT NOT NULL,
+	celcius               REAL,
+	pascal                REAL,
+	humidity_cubic_meter  REAL,
+	radiation_rem         REAL,
+	moisture_cubic_meter  REAL,
+	ph                    INTEGER,
+	nitrogen_percentage   REAL,
+	phosphorus_percentage REAL,
+	potassium_percentage  REAL,
+	tx_id                 TEXT NOT NULL,
+	PRIMARY KEY (crop, sensor, timestamp, tx_id)
+);
+CREATE INDEX IF NOT EXISTS readings_crop_time ON readings (crop, timestamp);
+
+CREATE TABLE IF NOT EXISTS irrigations (
+	crop         TEXT NOT NULL,
+	irrigated_at TEXT NOT NULL,
+	tx_id        TEXT NOT NULL,
+	amount_mm    REAL,
+	PRIMARY KEY (crop, irrigated_at, tx_id)
+);
+
+CREATE TABLE IF NOT EXISTS fertilizer_applications (
+	crop             TEXT NOT NULL,
+	applied_at       TEXT NOT NULL,
+	tx_id            TEXT NOT NULL,
+	product          TEXT,
+	rate_kg_ha       REAL,
+	nitrogen_kg_ha   REAL,
+	phosphorus_kg_ha REAL,
+	potassium_kg_ha  REAL,
+	PRIMARY KEY (crop, applied_at, tx_id)
+);
+
+CREATE TABLE IF NOT EXISTS pesticide_applications (
+	crop             TEXT NOT NULL,
+	applied_at       TEXT NOT NULL,
+	tx_id            TEXT NOT NULL,
+	product          TEXT,
+	dose_per_hectare REAL,
+	unit             TEXT,
+	PRIMARY KEY (crop, applied_at, tx_id)
+);
+
+CREATE TABLE IF NOT EXISTS crop_history (
+	block     INTEGER NOT NULL,
+	tx_index  INTEGER NOT NULL,
+	tx_id     TEXT NOT NULL,
+	timestamp TEXT NOT NULL,
+	crop      TEXT NOT NULL,
+	record    TEXT NOT NULL,
+	is_delete INTEGER NOT NULL,
+	value     TEXT
+);
+CREATE INDEX IF NOT EXISTS crop_history_crop ON crop_history (crop, block, tx_index);
+`
+
+const dropSchema = `
+DROP TABLE IF EXISTS checkpoint;
+DROP TABLE IF EXISTS crops;
+DROP TABLE IF EXISTS fields;
+DROP TABLE IF EXISTS crop_conditions;
+DROP TABLE IF EXISTS crop_activities;
+DROP TABLE IF EXISTS crop_status;
+DROP TABLE IF EXISTS readings;
+DROP TABLE IF EXISTS irrigations;
+DROP TABLE IF EXISTS fertilizer_applications;
+DROP TABLE IF EXISTS pesticide_applications;
+DROP TABLE IF EXISTS crop_history;
+`
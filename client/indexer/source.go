@@ -0,0 +1,131 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package indexer
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/manilpuri9/hyperledger-fabric-precision-farming/client/export"
+)
+
+// MirrorSource reads crop history from the mirror instead of the peers. It
+// implements export.Source.
+type MirrorSource struct {
+	DB *sql.DB
+}
+
+// Crop reads the base record of a crop.
+func (s MirrorSource) Crop(name string) (export.CropInfo, error) {
+	info := export.CropInfo{Name: name}
+	err := s.DB.QueryRow(`SELECT owner, quantity, latitude, longitude, soil_type, field, species, cultivar, planted_at
+		FROM crops WHERE name = ?`, name).Scan(&info.Owner, &info.Quantity, &info.FarmInfo.GeoLocation.Latitude,
+		&info.FarmInfo.GeoLocation.Longitude, &info.FarmInfo.SoilType, &info.Field, &info.Species, &info.Cultivar, &info.PlantedAt)
+	if err == sql.ErrNoRows {
+		return info, fmt.Errorf("crop does not exist: %s", name)
+	}
+	return info, err
+}
+
+// Readings reads the readings of every sensor of a crop in time order.
+func (s MirrorSource) Readings(crop, from, to string) ([]export.Reading, error) {
+	rows, err := s.DB.Query(`SELECT sensor, timestamp, celcius, pascal, humidity_cubic_meter, radiation_rem,
+		moisture_cubic_meter, ph, nitrogen_percentage, phosphorus_percentage, potassium_percentage
+		FROM readings WHERE crop = ? AND (? = '' OR timestamp >= ?) AND (? = '' OR timestamp <= ?)
+		ORDER BY timestamp, sensor`, crop, from, from, to, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var readings []export.Reading
+	for rows.Next() {
+		reading := export.Reading{Crop: crop}
+		weather, soil := &reading.Weather, &reading.SoilCondition
+		err = rows.Scan(&reading.Sensor, &reading.Timestamp, &weather.Temperature.Celcius, &weather.Pressure.Pascal,
+			&weather.Humidity.CubicMeter, &weather.Radiation.Rem, &soil.Moisture.CubicMeter, &soil.Ph,
+			&soil.Nitrogen.Percentage, &soil.Phosphorus.Percentage, &soil.Potassium.Percentage)
+		if err != nil {
+			return nil, err
+		}
+		readings = append(readings, reading)
+	}
+	return readings, rows.Err()
+}
+
+// Irrigations reads the irrigation applied to a crop in time order.
+func (s MirrorSource) Irrigations(crop, from, to string) ([]export.Irrigation, error) {
+	rows, err := s.DB.Query(`SELECT irrigated_at, tx_id, amount_mm FROM irrigations
+		WHERE crop = ? AND (? = '' OR irrigated_at >= ?) AND (? = '' OR irrigated_at <= ?)
+		ORDER BY irrigated_at`, crop, from, from, to, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var irrigations []export.Irrigation
+	for rows.Next() {
+		irrigation := export.Irrigation{Crop: crop}
+		if err = rows.Scan(&irrigation.IrrigatedAt, &irrigation.TxID, &irrigation.AmountMm); err != nil {
+			return nil, err
+		}
+		irrigations = append(irrigations, irrigation)
+	}
+	return irrigations, rows.Err()
+}
+
+// Fertilizations reads the fertilizer applications of a crop in time order.
+func (s MirrorSource) Fertilizations(crop string) ([]export.Fertilization, error) {
+	rows, err := s.DB.Query(`SELECT applied_at, tx_id, product, rate_kg_ha, nitrogen_kg_ha, phosphorus_kg_ha, potassium_kg_ha
+		FROM fertilizer_applications WHERE crop = ? ORDER BY applied_at`, crop)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var fertilizations []export.Fertilization
+	for rows.Next() {
+		f := export.Fertilization{Crop: crop}
+		err = rows.Scan(&f.AppliedAt, &f.TxID, &f.Product, &f.RateKgHa, &f.NitrogenKgHa, &f.PhosphorusKgHa, &f.PotassiumKgHa)
+		if err != nil {
+			return nil, err
+		}
+		fertilizations = append(fertilizations, f)
+	}
+	return fertilizations, rows.Err()
+}
+
+// PesticideApplications reads the pesticide applications of a crop in time order.
+func (s MirrorSource) PesticideApplications(crop string) ([]export.PesticideApplication, error) {
+	rows, err := s.DB.Query(`SELECT applied_at, tx_id, product, dose_per_hectare, unit
+		FROM pesticide_applications WHERE crop = ? ORDER BY applied_at`, crop)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var applications []export.PesticideApplication
+	for rows.Next() {
+		a := export.PesticideApplication{Crop: crop}
+		if err = rows.Scan(&a.AppliedAt, &a.TxID, &a.Product, &a.DosePerHectare, &a.Unit); err != nil {
+			return nil, err
+		}
+		applications = append(applications, a)
+	}
+	return applications, rows.Err()
+}
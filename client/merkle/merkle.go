@@ -0,0 +1,266 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+// Package merkle builds batches of sensor readings for anchoring with the
+// anchorBatch chaincode function and produces the inclusion proofs accepted
+// by verifyBatchReading.
+//
+// The tree layout must match the chaincode: a leaf is
+// sha256(0x00 || canonical reading), an inner node is
+// sha256(0x01 || left || right) and the last node of an odd level is promoted
+// to the next level unchanged.
+package merkle
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	leafPrefix = 0x00
+	nodePrefix = 0x01
+)
+
+// TimeLayout is the timestamp layout the chaincode stores readings with.
+const TimeLayout = "2006-01-02T15:04:05Z"
+
+// Reading mirrors the JSON document of a chaincode sensor reading.
+type Reading struct {
+	Crop      string `json:"crop"`
+	Sensor    string `json:"sensor"`
+	Timestamp string `json:"timestamp"`
+	Weather   struct {
+		Temperature struct {
+			Celcius float64 `json:"celcius"`
+		} `json:"temperature"`
+		Pressure struct {
+			Pascal float64 `json:"pascal"`
+		} `json:"pressure"`
+		Humidity struct {
+			CubicMeter float64 `json:"cubic_meter"`
+		} `json:"humidity"`
+		Radiation struct {
+			Rem float64 `json:"rem"`
+		} `json:"radiation"`
+	} `json:"weather"`
+	SoilCondition struct {
+		Moisture struct {
+			CubicMeter float64 `json:"cubic meter"`
+		} `json:"moisture"`
+		Ph       int `json:"ph"`
+		Nitrogen struct {
+			Percentage float64 `json:"percentage"`
+		} `json:"nitrogen"`
+		Phosphorus struct {
+			Percentage float64 `json:"percentage"`
+		} `json:"phosphorus"`
+	} `json:"soil_condition"`
+	Nonce     string `json:"nonce,omitempty"`
+	Signature string `json:"signature,omitempty"`
+}
+
+// Canonical returns the encoding of the reading that is hashed into a leaf.
+// It is the same encoding a device signs for submitSignedReading.
+func (r Reading) Canonical() ([]byte, error) {
+	timestamp, err := time.Parse(time.RFC3339, r.Timestamp)
+	if err != nil {
+		return nil, fmt.Errorf("merkle: reading timestamp must be RFC3339: %s", r.Timestamp)
+	}
+	formatFloat := func(value float64) string {
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	}
+	fields := []string{
+		r.Crop,
+		r.Sensor,
+		timestamp.UTC().Format(TimeLayout),
+		r.Nonce,
+		formatFloat(r.Weather.Temperature.Celcius),
+		formatFloat(r.Weather.Pressure.Pascal),
+		formatFloat(r.Weather.Humidity.CubicMeter),
+		formatFloat(r.Weather.Radiation.Rem),
+		formatFloat(r.SoilCondition.Moisture.CubicMeter),
+		strconv.Itoa(r.SoilCondition.Ph),
+		formatFloat(r.SoilCondition.Nitrogen.Percentage),
+		formatFloat(r.SoilCondition.Phosphorus.Percentage),
+	}
+	return []byte(strings.Join(fields, "|")), nil
+}
+
+// ProofStep is one sibling hash on the path from a leaf to the root. Left is
+// true when the sibling is the left child of their parent.
+type ProofStep struct {
+	Hash string `json:"hash"`
+	Left bool   `json:"left"`
+}
+
+// Proof is the list of sibling hashes from a leaf up to the root.
+type Proof []ProofStep
+
+// Metadata holds the batch arguments of the anchorBatch chaincode function.
+type Metadata struct {
+	MerkleRoot   string
+	ReadingCount int
+	From         string
+	To           string
+	Crops        []string
+}
+
+// Batch is a Merkle tree over an ordered batch of readings.
+type Batch struct {
+	readings []Reading
+	// levels[0] holds the leaves, the last level holds the root
+	levels [][][]byte
+}
+
+// NewBatch builds the Merkle tree over readings in the given order.
+func NewBatch(readings []Reading) (*Batch, error) {
+	if len(readings) == 0 {
+		return nil, errors.New("merkle: a batch needs at least one reading")
+	}
+
+	leaves := make([][]byte, len(readings))
+	for i, reading := range readings {
+		canonical, err := reading.Canonical()
+		if err != nil {
+			return nil, err
+		}
+		leaves[i] = LeafHash(canonical)
+	}
+
+	levels := [][][]byte{leaves}
+	for level := leaves; len(level) > 1; {
+		next := make([][]byte, 0, (len(level)+1)/2)
+		for i := 0; i < len(level); i += 2 {
+			if i+1 == len(level) {
+				next = append(next, level[i])
+				continue
+			}
+			next = append(next, NodeHash(level[i], level[i+1]))
+		}
+		levels = append(levels, next)
+		level = next
+	}
+
+	return &Batch{
+		readings: append([]Reading(nil), readings...),
+		levels:   levels,
+	}, nil
+}
+
+// Len returns the number of readings in the batch.
+func (b *Batch) Len() int {
+	return len(b.readings)
+}
+
+// Root returns the Merkle root of the batch.
+func (b *Batch) Root() []byte {
+	return b.levels[len(b.levels)-1][0]
+}
+
+// Proof returns the inclusion proof of the i-th reading of the batch.
+func (b *Batch) Proof(i int) (Proof, error) {
+	if i < 0 || i >= len(b.readings) {
+		return nil, fmt.Errorf("merkle: reading %d is not in the batch", i)
+	}
+
+	proof := Proof{}
+	index := i
+	for _, level := range b.levels[:len(b.levels)-1] {
+		sibling := index ^ 1
+		if sibling < len(level) {
+			proof = append(proof, ProofStep{
+				Hash: hex.EncodeToString(level[sibling]),
+				Left: sibling < index,
+			})
+		}
+		index /= 2
+	}
+	return proof, nil
+}
+
+// Metadata returns the values to anchor the batch with.
+func (b *Batch) Metadata() Metadata {
+	metadata := Metadata{
+		MerkleRoot:   hex.EncodeToString(b.Root()),
+		ReadingCount: len(b.readings),
+	}
+
+	crops := map[string]bool{}
+	for _, reading := range b.readings {
+		// Canonical already validated every timestamp in NewBatch
+		parsed, _ := time.Parse(time.RFC3339, reading.Timestamp)
+		timestamp := parsed.UTC().Format(TimeLayout)
+		if metadata.From == "" || timestamp < metadata.From {
+			metadata.From = timestamp
+		}
+		if timestamp > metadata.To {
+			metadata.To = timestamp
+		}
+		if !crops[reading.Crop] {
+			crops[reading.Crop] = true
+			metadata.Crops = append(metadata.Crops, reading.Crop)
+		}
+	}
+	sort.Strings(metadata.Crops)
+	return metadata
+}
+
+// Verify reports whether proof shows that reading is part of the batch with
+// the given root.
+func Verify(root []byte, reading Reading, proof Proof) (bool, error) {
+	canonical, err := reading.Canonical()
+	if err != nil {
+		return false, err
+	}
+
+	node := LeafHash(canonical)
+	for _, step := range proof {
+		sibling, err := hex.DecodeString(step.Hash)
+		if err != nil || len(sibling) != sha256.Size {
+			return false, errors.New("merkle: proof hashes must be hex encoded SHA-256 hashes")
+		}
+		if step.Left {
+			node = NodeHash(sibling, node)
+		} else {
+			node = NodeHash(node, sibling)
+		}
+	}
+	return bytes.Equal(node, root), nil
+}
+
+// LeafHash hashes the canonical encoding of a reading into a leaf.
+func LeafHash(canonical []byte) []byte {
+	sum := sha256.Sum256(append([]byte{leafPrefix}, canonical...))
+	return sum[:]
+}
+
+// NodeHash hashes two child hashes into their parent.
+func NodeHash(left, right []byte) []byte {
+	buffer := make([]byte, 0, 1+len(left)+len(right))
+	buffer = append(buffer, nodePrefix)
+	buffer = append(buffer, left...)
+	buffer = append(buffer, right...)
+	sum := sha256.Sum256(buffer)
+	return sum[:]
+}
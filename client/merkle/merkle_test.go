@@ -0,0 +1,201 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package merkle_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/manilpuri9/hyperledger-fabric-precision-farming/client/gateway/mockledger"
+	"github.com/manilpuri9/hyperledger-fabric-precision-farming/client/merkle"
+)
+
+// batchReadings are five readings of two crops, an odd level on the way up,
+// with timestamps in other zones and values that need all their digits.
+func batchReadings() []merkle.Reading {
+	readings := make([]merkle.Reading, 5)
+	for i := range readings {
+		reading := &readings[i]
+		reading.Crop = []string{"rice1", "rice2"}[i%2]
+		reading.Sensor = "sensor1"
+		reading.Timestamp = time.Date(2018, 6, 1, 8+i, 30, 0, 0, time.FixedZone("", 2*60*60)).Format(time.RFC3339)
+		reading.Nonce = string(rune('a' + i))
+		reading.Weather.Temperature.Celcius = 21.5 + float64(i)/10
+		reading.Weather.Pressure.Pascal = 101325
+		reading.Weather.Humidity.CubicMeter = 12.345678901
+		reading.Weather.Radiation.Rem = 0.1 + 0.2
+		reading.SoilCondition.Moisture.CubicMeter = 32
+		reading.SoilCondition.Ph = 6
+		reading.SoilCondition.Nitrogen.Percentage = 1e-7
+		reading.SoilCondition.Phosphorus.Percentage = 3.25
+	}
+	return readings
+}
+
+// anchoredLedger returns a ledger on which the batch is anchored as batch1
+// by gateway gw1.
+func anchoredLedger(t *testing.T, batch *merkle.Batch) *mockledger.Ledger {
+	t.Helper()
+	ledger, err := mockledger.New("Org1MSP", map[string]string{"hssf.agronomist": "true"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicKey := string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+
+	metadata := batch.Metadata()
+	transactions := [][]string{
+		{"putSpecies", `{"id":"rice","name":"Rice"}`},
+		{"registerDevice", "gw1", "gateway", "acme", publicKey, "2018-01-01", "farm1"},
+	}
+	for _, crop := range metadata.Crops {
+		transactions = append(transactions, []string{"initCrop", crop, "manil puri", "400", "43.2", "21.3", "clay",
+			"35", "4", "434", "10.3", "32", "3", "1.2", "3.2", "rice.jpg", "4", "true", "true", "true", "false", "", "rice"},
+			[]string{"bindDevice", "gw1", "crop", crop})
+	}
+	transactions = append(transactions, []string{"anchorBatch", "batch1", "gw1", metadata.MerkleRoot, "5",
+		metadata.From, metadata.To, "s3://readings/batch1.parquet", strings.Join(metadata.Crops, ",")})
+	for _, transaction := range transactions {
+		_, _, err = ledger.Submit(transaction[0], transaction[1:]...)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return ledger
+}
+
+// verifyBatchReading returns the result of the verifyBatchReading chaincode
+// function for a reading and its proof.
+func verifyBatchReading(t *testing.T, ledger *mockledger.Ledger, reading merkle.Reading, proof merkle.Proof) (leaf string, verified bool) {
+	t.Helper()
+	readingJSON, err := json.Marshal(reading)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proofJSON, err := json.Marshal(proof)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, err := ledger.Evaluate("verifyBatchReading", "batch1", string(readingJSON), string(proofJSON))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var result struct {
+		Leaf     string `json:"leaf"`
+		Verified bool   `json:"verified"`
+	}
+	err = json.Unmarshal(payload, &result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result.Leaf, result.Verified
+}
+
+// TestChaincodeVerifiesBatch anchors the root of a batch and checks the
+// proof of every reading with the chaincode, so this package and
+// chaincode/batch_anchor.go hash the same bytes.
+func TestChaincodeVerifiesBatch(t *testing.T) {
+	readings := batchReadings()
+	batch, err := merkle.NewBatch(readings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ledger := anchoredLedger(t, batch)
+
+	payload, err := ledger.Evaluate("readBatch", "batch1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var anchor struct {
+		MerkleRoot string `json:"merkle_root"`
+		From       string `json:"from"`
+		To         string `json:"to"`
+	}
+	err = json.Unmarshal(payload, &anchor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	metadata := batch.Metadata()
+	if anchor.MerkleRoot != metadata.MerkleRoot || anchor.From != metadata.From || anchor.To != metadata.To {
+		t.Errorf("anchored batch %+v does not match the metadata %+v", anchor, metadata)
+	}
+
+	for i, reading := range readings {
+		proof, err := batch.Proof(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		canonical, err := reading.Canonical()
+		if err != nil {
+			t.Fatal(err)
+		}
+		leaf, verified := verifyBatchReading(t, ledger, reading, proof)
+		if leaf != hex.EncodeToString(merkle.LeafHash(canonical)) {
+			t.Errorf("reading %d: chaincode leaf %s, merkle leaf %x", i, leaf, merkle.LeafHash(canonical))
+		}
+		if !verified {
+			t.Errorf("reading %d: chaincode rejected the proof", i)
+		}
+		if ok, err := merkle.Verify(batch.Root(), reading, proof); err != nil || !ok {
+			t.Errorf("reading %d: merkle.Verify = %v, %v", i, ok, err)
+		}
+	}
+}
+
+// TestChaincodeRejectsAlteredReading checks that both sides reject a proof
+// for a reading that differs from the one in the batch.
+func TestChaincodeRejectsAlteredReading(t *testing.T) {
+	readings := batchReadings()
+	batch, err := merkle.NewBatch(readings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ledger := anchoredLedger(t, batch)
+
+	proof, err := batch.Proof(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	altered := readings[2]
+	altered.Weather.Temperature.Celcius += 0.01
+	if _, verified := verifyBatchReading(t, ledger, altered, proof); verified {
+		t.Error("chaincode verified an altered reading")
+	}
+	if ok, err := merkle.Verify(batch.Root(), altered, proof); err != nil || ok {
+		t.Errorf("merkle.Verify of an altered reading = %v, %v", ok, err)
+	}
+	// a truncated proof does not lead to the anchored root
+	if _, verified := verifyBatchReading(t, ledger, readings[2], proof[1:]); verified {
+		t.Error("chaincode verified a reading with a truncated proof")
+	}
+}
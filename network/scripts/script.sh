@@ -118,7 +118,7 @@ joinChannel () {
 installChaincode () {
 	PEER=$1
 	setGlobals $PEER
-	peer chaincode install -n mycc -v 1.0 -p github.com/hyperledger/fabric/examples/chaincode/go/ >&log.txt
+	peer chaincode install -n mycc -v 1.0 -p github.com/manilpuri9/hyperledger-fabric-precision-farming/chaincode/cmd/cropcc >&log.txt
 	res=$?
 	cat log.txt
         verifyResult $res "Chaincode installation on remote peer PEER$PEER has Failed"